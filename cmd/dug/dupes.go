@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dustin/go-humanize"
+	"github.com/michaelscutari/dug/internal/dupes"
+	"github.com/michaelscutari/dug/internal/throttle"
+	"github.com/spf13/cobra"
+
+	_ "modernc.org/sqlite"
+)
+
+var dupesCmd = &cobra.Command{
+	Use:   "dupes",
+	Short: "Find duplicate files in a snapshot",
+	Long: `Find duplicate files in a snapshot by hashing their contents.
+
+Only files that share a size with another file are read. Each is first
+hashed by its head and tail; files whose partial hashes collide are then
+hashed in full. Hardlinks to the same file are not duplicates and are
+ignored. Hashes are saved in the snapshot's file_hashes table, so running
+dupes again on the same snapshot only reads files it hasn't hashed yet.`,
+	RunE: runDupes,
+}
+
+var (
+	dupesDB        string
+	dupesMinSize   string
+	dupesWorkers   int
+	dupesRateLimit float64
+	dupesBandwidth string
+	dupesLimit     int
+)
+
+func init() {
+	dupesCmd.Flags().StringVarP(&dupesDB, "db", "d", "./data/latest.db", "Path to database file")
+	dupesCmd.Flags().StringVar(&dupesMinSize, "min-size", "1MiB", "Ignore files smaller than this")
+	dupesCmd.Flags().IntVarP(&dupesWorkers, "workers", "w", 4, "Files hashed at once")
+	dupesCmd.Flags().Float64Var(&dupesRateLimit, "rate-limit", 0, "Max files opened per second (0 = unlimited)")
+	dupesCmd.Flags().StringVar(&dupesBandwidth, "bandwidth", "0", "Max bytes read per second, e.g. 200MiB (0 = unlimited)")
+	dupesCmd.Flags().IntVarP(&dupesLimit, "limit", "n", 20, "Duplicate sets to list (0 = all)")
+}
+
+func runDupes(cmd *cobra.Command, args []string) error {
+	minSize, err := humanize.ParseBytes(dupesMinSize)
+	if err != nil {
+		return fmt.Errorf("invalid --min-size: %w", err)
+	}
+	bandwidth, err := humanize.ParseBytes(dupesBandwidth)
+	if err != nil {
+		return fmt.Errorf("invalid --bandwidth: %w", err)
+	}
+
+	database, err := sql.Open("sqlite", dupesDB)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	opts := dupes.DefaultOptions().
+		WithWorkers(dupesWorkers).
+		WithMinSize(int64(minSize)).
+		WithStageFunc(func(stage string, files int) {
+			if files > 0 {
+				fmt.Fprintf(os.Stderr, "Hashing %s files (%s)...\n", humanize.Comma(int64(files)), stage)
+			}
+		})
+	if dupesRateLimit > 0 {
+		opts.WithLimiter(throttle.NewLimiter(dupesRateLimit))
+	}
+	if bandwidth > 0 {
+		opts.WithBandwidth(throttle.NewLimiter(float64(bandwidth) / dupes.ChunkSize))
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	report, err := dupes.Find(ctx, database, opts)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "Canceled. Hashes computed so far are saved.")
+			return nil
+		}
+		return fmt.Errorf("duplicate search failed: %w", err)
+	}
+
+	for i, set := range report.Sets {
+		if dupesLimit > 0 && i == dupesLimit {
+			fmt.Printf("... %d more sets\n\n", len(report.Sets)-i)
+			break
+		}
+		fmt.Printf("%d copies of %s, %s reclaimable\n", len(set.Files), humanize.Bytes(uint64(set.Size)), humanize.Bytes(uint64(set.Reclaimable)))
+		for _, f := range set.Files {
+			if f.Links > 0 {
+				fmt.Printf("  %s (+%d hardlinks)\n", f.Path, f.Links)
+			} else {
+				fmt.Printf("  %s\n", f.Path)
+			}
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Duplicate sets: %s\n", humanize.Comma(int64(len(report.Sets))))
+	fmt.Printf("Reclaimable:    %s\n", humanize.Bytes(uint64(report.Reclaimable)))
+	fmt.Printf("Candidates:     %s files (%s hashed this run)\n", humanize.Comma(int64(report.Candidates)), humanize.Comma(int64(report.Hashed)))
+	if report.Skipped > 0 {
+		fmt.Printf("Skipped:        %s files unreadable or changed since the scan\n", humanize.Comma(int64(report.Skipped)))
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/michaelscutari/dug/internal/db"
 	"github.com/spf13/cobra"
 
 	_ "modernc.org/sqlite"
@@ -25,12 +26,22 @@ func init() {
 }
 
 func runInfo(cmd *cobra.Command, args []string) error {
-	database, err := sql.Open("sqlite", infoDB)
+	path, cleanup, err := db.Uncompress(infoDB)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	database, err := sql.Open("sqlite", path)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
 	defer database.Close()
 
+	if err := db.CheckSchema(database); err != nil {
+		return err
+	}
+
 	var rootPath string
 	var startTime, endTime int64
 	var totalSize, totalBlocks, fileCount, dirCount, errorCount int64
@@ -51,6 +62,7 @@ func runInfo(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Scan Information\n")
 	fmt.Printf("================\n\n")
 	fmt.Printf("Root Path:    %s\n", rootPath)
+	printSchema(database)
 	fmt.Printf("Start Time:   %s\n", start.Format(time.RFC3339))
 	if endTime > 0 {
 		fmt.Printf("End Time:     %s\n", end.Format(time.RFC3339))
@@ -66,5 +78,122 @@ func runInfo(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Errors:        %s\n", humanize.Comma(errorCount))
 	}
 
+	printRootTotals(database, "")
+
+	var minWorkers, maxWorkers, lastWorkers sql.NullInt64
+	err = database.QueryRow(`
+		SELECT MIN(workers), MAX(workers),
+		       (SELECT workers FROM scan_concurrency ORDER BY time DESC, rowid DESC LIMIT 1)
+		FROM scan_concurrency
+	`).Scan(&minWorkers, &maxWorkers, &lastWorkers)
+	if err == nil && lastWorkers.Valid {
+		fmt.Printf("Workers:       auto, %d-%d (final %d)\n", minWorkers.Int64, maxWorkers.Int64, lastWorkers.Int64)
+	}
+
+	var memLimit, peakRSS int64
+	if err := database.QueryRow(`SELECT memory_limit, peak_rss FROM scan_meta WHERE id = 1`).Scan(&memLimit, &peakRSS); err == nil && peakRSS > 0 {
+		if memLimit > 0 {
+			fmt.Printf("Peak Memory:   %s (limit %s)\n", humanize.IBytes(uint64(peakRSS)), humanize.IBytes(uint64(memLimit)))
+		} else {
+			fmt.Printf("Peak Memory:   %s\n", humanize.IBytes(uint64(peakRSS)))
+		}
+	}
+
+	var timedOut int64
+	if err := database.QueryRow(`SELECT COUNT(*) FROM dirs WHERE timed_out = 1`).Scan(&timedOut); err == nil && timedOut > 0 {
+		fmt.Printf("Timed Out:     %s dirs (partial)\n", humanize.Comma(timedOut))
+	}
+
+	printSources(database)
+
 	return nil
 }
+
+// printSources lists the snapshots a merged database was built from.
+// Unmerged snapshots have no sources and print nothing.
+func printSources(database *sql.DB) {
+	rows, err := database.Query(`SELECT path, root_path, COALESCE(end_time, 0), file_count, total_size, shard_index, shard_count FROM sources ORDER BY id`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	header := false
+	for rows.Next() {
+		var path, root string
+		var endTime, files, size, shardIndex, shardCount int64
+		if err := rows.Scan(&path, &root, &endTime, &files, &size, &shardIndex, &shardCount); err != nil {
+			return
+		}
+		if !header {
+			fmt.Printf("\nSources\n")
+			fmt.Printf("-------\n")
+			header = true
+		}
+		label := root
+		if shardCount > 1 {
+			label = fmt.Sprintf("%s (shard %d/%d)", root, shardIndex, shardCount)
+		}
+		fmt.Printf("%s\n  %s, scanned %s, %s files, %s\n", label, path,
+			time.Unix(endTime, 0).Format(time.RFC3339), humanize.Comma(files), humanize.Bytes(uint64(size)))
+	}
+}
+
+// printSchema prints the snapshot's schema version and what wrote it.
+func printSchema(database *sql.DB) {
+	version, err := db.Version(database)
+	if err != nil {
+		return
+	}
+	if version == 0 {
+		fmt.Printf("Schema:       unversioned (run dug migrate to upgrade)\n")
+		return
+	}
+	info, _ := db.SchemaInfo(database)
+	if v := info[db.InfoDugVersion]; v != "" {
+		fmt.Printf("Schema:       v%d, written by dug %s\n", version, v)
+	} else {
+		fmt.Printf("Schema:       v%d\n", version)
+	}
+	if from, ok := info[db.InfoMigratedFrom]; ok {
+		fmt.Printf("Migrated:     from v%s\n", from)
+	}
+	if opts := info[db.InfoScanOptions]; opts != "" {
+		fmt.Printf("Options:      %s\n", opts)
+	}
+	if depth, ok := db.SummaryDepth(database); ok {
+		fmt.Printf("Summary:      directories to depth %d, no files\n", depth)
+	}
+}
+
+// printRootTotals lists per-root totals for snapshots with several roots.
+// Older snapshots have no scan_roots table and print nothing.
+func printRootTotals(database *sql.DB, indent string) {
+	rows, err := database.Query(`SELECT path, total_size, total_blocks, file_count, dir_count FROM scan_roots ORDER BY path`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	type rootTotals struct {
+		path                      string
+		size, blocks, files, dirs int64
+	}
+	var roots []rootTotals
+	for rows.Next() {
+		var r rootTotals
+		if err := rows.Scan(&r.path, &r.size, &r.blocks, &r.files, &r.dirs); err != nil {
+			return
+		}
+		roots = append(roots, r)
+	}
+	if len(roots) < 2 {
+		return
+	}
+
+	fmt.Printf("\n%sRoots\n", indent)
+	for _, r := range roots {
+		fmt.Printf("%s  %s: %s files, %s dirs, %s apparent, %s disk\n", indent, r.path,
+			humanize.Comma(r.files), humanize.Comma(r.dirs), humanize.Bytes(uint64(r.size)), humanize.Bytes(uint64(r.blocks)))
+	}
+}
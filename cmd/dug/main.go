@@ -30,4 +30,10 @@ func init() {
 	rootCmd.AddCommand(tuiCmd)
 	rootCmd.AddCommand(infoCmd)
 	rootCmd.AddCommand(queryCmd)
+	rootCmd.AddCommand(sqlCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(mergeCmd)
+	rootCmd.AddCommand(dupesCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(pruneCmd)
 }
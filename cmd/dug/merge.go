@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge [snapshot.db...]",
+	Short: "Merge snapshot databases into one",
+	Long: `Merge snapshot databases into a single one.
+
+Snapshots of separate trees (for example one per NFS server) are placed
+under a synthetic top directory at their roots' common ancestor. The shard
+snapshots written by "dug scan --shard I/N" are stitched together at their
+shared root instead; use --shards to pick up the latest snapshot of every
+shard-* directory under an output directory.
+
+If --out ends in .db the result is written to that file. Otherwise --out is
+a snapshot directory and the result becomes its latest.db.`,
+	RunE: runMerge,
+}
+
+var (
+	mergeOut    string
+	mergeShards string
+	mergeRetain retentionFlags
+	mergeSearch bool
+)
+
+func init() {
+	mergeCmd.Flags().StringVarP(&mergeOut, "out", "o", "./data", "Output snapshot directory, or a .db file")
+	mergeCmd.Flags().StringVar(&mergeShards, "shards", "", "Merge the latest snapshot of each shard-* directory under this directory")
+	mergeRetain.register(mergeCmd.Flags())
+	mergeCmd.Flags().BoolVar(&mergeSearch, "search-index", false, "Index every file and directory name for whole-snapshot search")
+}
+
+func runMerge(cmd *cobra.Command, args []string) error {
+	inputs := args
+	if mergeShards != "" {
+		shards, err := shardSnapshots(mergeShards)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, shards...)
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("no snapshots to merge (pass databases or --shards DIR)")
+	}
+	if err := mergeRetain.check(); err != nil {
+		return err
+	}
+
+	out, err := filepath.Abs(mergeOut)
+	if err != nil {
+		return fmt.Errorf("failed to resolve output path: %w", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if strings.HasSuffix(out, ".db") {
+		return mergeFile(ctx, inputs, out, mergeSearch)
+	}
+	return mergeSnapshots(ctx, inputs, out, mergeRetain, mergeSearch)
+}
+
+// mergeFile merges inputs into the standalone database at path.
+func mergeFile(ctx context.Context, inputs []string, path string, searchIndex bool) error {
+	fmt.Printf("Merging %d snapshots...\n", len(inputs))
+	start := time.Now()
+
+	mgr := snapshot.NewManager(filepath.Dir(path), 0)
+	mgr.SetVersion(version)
+	mgr.SetSearchIndex(searchIndex)
+	if err := mgr.MergeFile(ctx, inputs, path); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "Merge canceled.")
+			return nil
+		}
+		return fmt.Errorf("merge failed: %w", err)
+	}
+
+	fmt.Printf("Database: %s\n", path)
+	fmt.Printf("Merge completed in %s\n", time.Since(start).Round(time.Millisecond))
+	printMergeSummary(path)
+	return nil
+}
+
+// mergeSnapshots merges inputs into a new snapshot in outDir and prints
+// its summary, rotating outDir's snapshots as retain says.
+func mergeSnapshots(ctx context.Context, inputs []string, outDir string, retain retentionFlags, searchIndex bool) error {
+	fmt.Printf("Merging %d snapshots...\n", len(inputs))
+	start := time.Now()
+
+	mgr := snapshot.NewManager(outDir, 0)
+	mgr.SetVersion(version)
+	mgr.SetSearchIndex(searchIndex)
+	retain.apply(mgr)
+	dbPath, err := mgr.RunMerge(ctx, inputs)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "Merge canceled.")
+			return nil
+		}
+		return fmt.Errorf("merge failed: %w", err)
+	}
+
+	fmt.Printf("Database: %s\n", dbPath)
+	fmt.Printf("Merge completed in %s\n", time.Since(start).Round(time.Millisecond))
+	printMergeSummary(dbPath)
+	return nil
+}
+
+// printMergeSummary prints the merged totals and each source's root.
+func printMergeSummary(dbPath string) {
+	database, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return // Non-fatal
+	}
+	defer database.Close()
+
+	var fileCount, dirCount, totalSize, totalBlocks, errorCount int64
+	database.QueryRow(`SELECT file_count, dir_count, total_size, total_blocks, error_count FROM scan_meta WHERE id = 1`).
+		Scan(&fileCount, &dirCount, &totalSize, &totalBlocks, &errorCount)
+
+	fmt.Printf("\nSummary:\n")
+	fmt.Printf("  Files: %d\n", fileCount)
+	fmt.Printf("  Directories: %d\n", dirCount)
+	fmt.Printf("  Apparent size: %s\n", humanizeBytes(totalSize))
+	fmt.Printf("  Disk usage: %s\n", humanizeBytes(totalBlocks))
+	if errorCount > 0 {
+		fmt.Printf("  Errors: %d\n", errorCount)
+	}
+	printRootTotals(database, "  ")
+}
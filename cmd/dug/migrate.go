@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/spf13/cobra"
+
+	_ "modernc.org/sqlite"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [snapshot.db...]",
+	Short: "Upgrade snapshots to the current schema",
+	Long: `Upgrade snapshot databases in place to the schema this dug writes.
+
+Missing tables and columns are added and derived data is backfilled, such as
+the slack and sparse totals of older rollups. Each schema version is applied
+in its own transaction, so an interrupted migration leaves the snapshot at a
+consistent version. The snapshot is vacuumed afterwards to return the space
+freed by rebuilt tables.`,
+	RunE: runMigrate,
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	paths := args
+	if len(paths) == 0 {
+		paths = []string{"./data/latest.db"}
+	}
+
+	var failed int
+	for _, path := range paths {
+		if err := migrateOne(path); err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d snapshots failed to migrate", failed, len(paths))
+	}
+	return nil
+}
+
+func migrateOne(path string) error {
+	database, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	from, err := db.Migrate(database)
+	if err != nil {
+		return err
+	}
+	if from == db.SchemaVersion {
+		fmt.Printf("%s: already at schema v%d\n", path, from)
+		return nil
+	}
+
+	// Rebuilt tables leave free pages behind
+	if _, err := database.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum: %w", err)
+	}
+	fmt.Printf("%s: migrated schema v%d -> v%d\n", path, from, db.SchemaVersion)
+	return nil
+}
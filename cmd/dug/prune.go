@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/michaelscutari/dug/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Apply a retention policy to a snapshot directory",
+	Long: `Apply a retention policy to the snapshots in an output directory, as
+dug scan does after each scan, and list what is kept and why.
+
+--retention keeps the newest snapshots. The --keep-* rules keep the newest
+snapshot of each recent day, week, month or year, so nightly scans can be
+thinned to a long history. A snapshot is kept if any rule keeps it. With
+--dry-run nothing is removed.`,
+	Example: `  dug prune --out ./scans --keep-daily 7 --keep-weekly 4 --keep-monthly 12 --keep-yearly -1 --dry-run`,
+	RunE:    runPrune,
+}
+
+var (
+	pruneOut    string
+	pruneRetain retentionFlags
+	pruneDryRun bool
+)
+
+func init() {
+	pruneCmd.Flags().StringVarP(&pruneOut, "out", "o", "./data", "Snapshot directory to prune")
+	pruneRetain.register(pruneCmd.Flags())
+	pruneCmd.Flags().BoolVarP(&pruneDryRun, "dry-run", "n", false, "Show what would be pruned without removing anything")
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	if err := pruneRetain.check(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(pruneOut); err != nil {
+		return fmt.Errorf("failed to open snapshot directory: %w", err)
+	}
+
+	mgr := snapshot.NewManager(pruneOut, 0)
+	pruneRetain.apply(mgr)
+
+	var plans []snapshot.Plan
+	var err error
+	if pruneDryRun {
+		plans, err = mgr.PlanRetention()
+	} else {
+		plans, err = mgr.Prune()
+	}
+	if err != nil {
+		return err
+	}
+
+	drop := "prune"
+	if pruneRetain.archive != snapshot.ArchiveNone {
+		drop = "archive"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var dropped int
+	for _, p := range plans {
+		action := "keep"
+		if !p.Keep {
+			action = drop
+			dropped++
+		}
+		when := "unknown"
+		if !p.Time.IsZero() {
+			when = p.Time.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", action, p.Name, when, strings.Join(p.Reasons, ", "))
+	}
+	w.Flush()
+
+	verb := "Pruned"
+	if drop == "archive" {
+		verb = "Archived"
+	}
+	if pruneDryRun {
+		verb = "Would " + drop
+	}
+	fmt.Printf("%s %d of %d snapshots (%s)\n", verb, dropped, len(plans), pruneRetain.policy)
+	return nil
+}
@@ -22,10 +22,13 @@ var queryCmd = &cobra.Command{
 }
 
 var (
-	queryDB    string
-	queryPath  string
-	querySort  string
-	queryLimit int
+	queryDB     string
+	queryPath   string
+	querySort   string
+	queryLimit  int
+	queryOffset int
+	queryCursor string
+	queryBy     string
 )
 
 func init() {
@@ -33,10 +36,19 @@ func init() {
 	queryCmd.Flags().StringVarP(&queryPath, "path", "p", "", "Directory path to query")
 	queryCmd.Flags().StringVarP(&querySort, "sort", "s", "size", "Sort by: size, disk, name, files")
 	queryCmd.Flags().IntVarP(&queryLimit, "limit", "n", 20, "Maximum number of results")
+	queryCmd.Flags().IntVar(&queryOffset, "offset", 0, "Skip this many children first")
+	queryCmd.Flags().StringVar(&queryCursor, "cursor", "", "Continue a listing after the cursor printed by an earlier query with the same --path and --sort")
+	queryCmd.Flags().StringVar(&queryBy, "by", "children", "Report: children (list --path) or efficiency (directories below --path ranked by block slack; --sort sparse ranks by sparse savings)")
 }
 
 func runQuery(cmd *cobra.Command, args []string) error {
-	database, err := sql.Open("sqlite", queryDB)
+	path, cleanup, err := db.Uncompress(queryDB)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	database, err := sql.Open("sqlite", path)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
@@ -51,9 +63,35 @@ func runQuery(cmd *cobra.Command, args []string) error {
 	}
 	queryPath = pathutil.Normalize(queryPath)
 
-	entries, err := db.LoadChildren(database, queryPath, querySort, queryLimit)
-	if err != nil {
-		return fmt.Errorf("query failed: %w", err)
+	switch queryBy {
+	case "children":
+	case "efficiency":
+		if queryOffset != 0 || queryCursor != "" {
+			return fmt.Errorf("--offset and --cursor apply to --by children")
+		}
+		return queryEfficiency(database)
+	default:
+		return fmt.Errorf("invalid --by %q (expected children|efficiency)", queryBy)
+	}
+	if queryOffset != 0 && queryCursor != "" {
+		return fmt.Errorf("--offset and --cursor are mutually exclusive")
+	}
+
+	var entries []db.DisplayEntry
+	if queryCursor != "" {
+		cursor, err := db.ParseCursor(queryCursor)
+		if err != nil {
+			return err
+		}
+		entries, err = db.LoadChildrenAfter(database, queryPath, querySort, cursor, queryLimit)
+		if err != nil {
+			return fmt.Errorf("query failed: %w", err)
+		}
+	} else {
+		entries, err = db.LoadChildrenPage(database, queryPath, querySort, queryOffset, queryLimit)
+		if err != nil {
+			return fmt.Errorf("query failed: %w", err)
+		}
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
@@ -69,5 +107,43 @@ func runQuery(cmd *cobra.Command, args []string) error {
 	}
 	w.Flush()
 
+	// A full page may have more after it; the cursor goes to stderr so the
+	// table stays parseable
+	if len(entries) == queryLimit && queryLimit > 0 {
+		fmt.Fprintf(os.Stderr, "next: --cursor %s\n", entries[len(entries)-1].Cursor(querySort))
+	}
+
+	return nil
+}
+
+// queryEfficiency lists the directories below queryPath whose own files
+// waste the most disk on block rounding, or save the most by being sparse.
+func queryEfficiency(database *sql.DB) error {
+	dirs, err := db.LoadEfficiency(database, queryPath, querySort, queryLimit)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+
+	if r, err := db.GetRollup(database, queryPath); err == nil && r != nil {
+		fmt.Printf("%s: %s apparent, %s disk, %s slack, %s sparse savings\n\n", queryPath,
+			humanize.Bytes(uint64(r.TotalSize)), humanize.Bytes(uint64(r.TotalBlocks)),
+			humanize.Bytes(uint64(r.TotalSlack)), humanize.Bytes(uint64(r.TotalSparse)))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SLACK\tSPARSE\tFILES\tAVG SIZE\tDISK\tCLASS\tPATH\n")
+	for _, d := range dirs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			humanize.Bytes(uint64(d.Slack)),
+			humanize.Bytes(uint64(d.Sparse)),
+			humanize.Comma(d.Files),
+			humanize.Bytes(uint64(d.Size/max(d.Files, 1))),
+			humanize.Bytes(uint64(d.Blocks)),
+			d.Efficiency(),
+			d.Path,
+		)
+	}
+	w.Flush()
+
 	return nil
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/michaelscutari/dug/internal/snapshot"
+	"github.com/spf13/pflag"
+)
+
+// retentionFlags are the flags of commands that rotate snapshots in an
+// output directory.
+type retentionFlags struct {
+	policy       snapshot.Policy
+	archive      string
+	archiveDepth int
+}
+
+func (f *retentionFlags) register(fs *pflag.FlagSet) {
+	fs.IntVar(&f.policy.Last, "retention", 5, "Number of snapshots to retain (0 = unlimited, or none beyond the --keep-* rules)")
+	fs.IntVar(&f.policy.Daily, "keep-daily", 0, "Also keep the newest snapshot of each of the last N days that have one (-1 = all)")
+	fs.IntVar(&f.policy.Weekly, "keep-weekly", 0, "Also keep the newest snapshot of each of the last N weeks that have one (-1 = all)")
+	fs.IntVar(&f.policy.Monthly, "keep-monthly", 0, "Also keep the newest snapshot of each of the last N months that have one (-1 = all)")
+	fs.IntVar(&f.policy.Yearly, "keep-yearly", 0, "Also keep the newest snapshot of each of the last N years that have one (-1 = all)")
+	fs.StringVar(&f.archive, "archive", "", "Archive pruned snapshots into <out>/archive instead of deleting them: gzip|summary")
+	fs.IntVar(&f.archiveDepth, "archive-depth", snapshot.DefaultArchiveDepth, "Directory levels below the top that --archive summary keeps")
+}
+
+// check validates the flags.
+func (f *retentionFlags) check() error {
+	switch f.archive {
+	case snapshot.ArchiveNone, snapshot.ArchiveGzip, snapshot.ArchiveSummary:
+	default:
+		return fmt.Errorf("invalid archive mode %q (expected gzip|summary)", f.archive)
+	}
+	if f.archiveDepth < 0 {
+		return fmt.Errorf("invalid --archive-depth %d", f.archiveDepth)
+	}
+	return nil
+}
+
+// apply configures mgr to rotate snapshots as the flags say.
+func (f *retentionFlags) apply(mgr *snapshot.Manager) {
+	mgr.SetPolicy(f.policy)
+	mgr.SetArchive(f.archive, f.archiveDepth)
+}
@@ -5,16 +5,24 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/dustin/go-humanize"
+	"github.com/michaelscutari/dug/internal/budget"
 	"github.com/michaelscutari/dug/internal/pathutil"
 	"github.com/michaelscutari/dug/internal/scan"
 	"github.com/michaelscutari/dug/internal/snapshot"
+	"github.com/michaelscutari/dug/internal/source"
+	"github.com/michaelscutari/dug/internal/throttle"
 	"github.com/spf13/cobra"
 
 	_ "modernc.org/sqlite"
@@ -30,54 +38,192 @@ var scanCmd = &cobra.Command{
 }
 
 var (
-	scanRoot      string
-	scanOut       string
-	scanWorkers   int
-	scanXdev      bool
-	scanRetention int
-	scanExclude   []string
-	scanMaxErrors int
-	scanVerbose   bool
-	scanProgress  time.Duration
-	scanIndexMode string
-	scanSQLiteTmp string
+	scanRoots       []string
+	scanOut         string
+	scanWorkers     string
+	scanMinWork     int
+	scanMaxWork     int
+	scanXdev        bool
+	scanRetain      retentionFlags
+	scanExclude     []string
+	scanMaxErrors   int
+	scanVerbose     bool
+	scanProgress    time.Duration
+	scanIndexMode   string
+	scanSearchIndex bool
+	scanSQLiteTmp   string
+	scanDirTimeout  time.Duration
+	scanStatTimeout time.Duration
+	scanRateLimit   float64
+	scanRateSched   []string
+	scanRateFile    string
+	scanArchives    bool
+	scanFromTar     string
+	scanFromList    string
+	scanStatMethod  string
+	scanQueueMem    string
+	scanArchiveMem  string
+	scanMemLimit    string
+	scanShard       string
+	scanLocalShards int
 )
 
 func init() {
-	scanCmd.Flags().StringVarP(&scanRoot, "root", "r", ".", "Root directory to scan")
+	scanCmd.Flags().StringArrayVarP(&scanRoots, "root", "r", []string{"."}, "Root directory to scan (repeat to combine several roots in one snapshot)")
 	scanCmd.Flags().StringVarP(&scanOut, "out", "o", "./data", "Output directory for database")
-	scanCmd.Flags().IntVarP(&scanWorkers, "workers", "w", 8, "Number of worker goroutines")
+	scanCmd.Flags().StringVarP(&scanWorkers, "workers", "w", "8", "Number of worker goroutines, or \"auto\" to adapt to filesystem latency")
+	scanCmd.Flags().IntVar(&scanMinWork, "min-workers", 2, "Lower bound for --workers auto")
+	scanCmd.Flags().IntVar(&scanMaxWork, "max-workers", 32, "Upper bound for --workers auto")
 	scanCmd.Flags().BoolVar(&scanXdev, "xdev", true, "Don't cross filesystem boundaries")
-	scanCmd.Flags().IntVar(&scanRetention, "retention", 5, "Number of snapshots to retain (0 = unlimited)")
+	scanRetain.register(scanCmd.Flags())
 	scanCmd.Flags().StringSliceVarP(&scanExclude, "exclude", "e", nil, "Regex patterns to exclude (can be repeated)")
 	scanCmd.Flags().IntVar(&scanMaxErrors, "max-errors", 0, "Stop after N errors (0 = unlimited)")
 	scanCmd.Flags().BoolVarP(&scanVerbose, "verbose", "v", false, "Enable verbose scan logging")
 	scanCmd.Flags().DurationVar(&scanProgress, "progress-interval", 30*time.Second, "Emit progress lines to stderr at this interval when not a TTY (0 to disable)")
 	scanCmd.Flags().StringVar(&scanIndexMode, "index-mode", "memory", "Index build mode: memory|disk|skip")
+	scanCmd.Flags().BoolVar(&scanSearchIndex, "search-index", false, "Index every file and directory name for whole-snapshot search (skipped with --index-mode skip)")
 	scanCmd.Flags().StringVar(&scanSQLiteTmp, "sqlite-tmp-dir", "", "Directory for SQLite temp files during index build")
+	scanCmd.Flags().DurationVar(&scanDirTimeout, "readdir-timeout", 0, "Give up on a directory whose listing takes longer than this (0 = no limit)")
+	scanCmd.Flags().DurationVar(&scanStatTimeout, "stat-timeout", 0, "Give up on a directory when one lstat takes longer than this (0 = no limit)")
+	scanCmd.Flags().Float64Var(&scanRateLimit, "rate-limit", 0, "Max readdir+lstat calls per second across all workers (0 = unlimited)")
+	scanCmd.Flags().StringSliceVar(&scanRateSched, "rate-schedule", nil, "Time-of-day rate window HH:MM-HH:MM=RATE, overriding --rate-limit (can be repeated)")
+	scanCmd.Flags().StringVar(&scanRateFile, "rate-control-file", "", "File holding an ops/sec limit, re-read whenever it changes")
+	scanCmd.Flags().BoolVar(&scanArchives, "descend-archives", false, "List members of .tar, .tar.gz and .zip files as virtual directories")
+	scanCmd.Flags().StringVar(&scanArchiveMem, "archive-memory", "256MiB", "Memory for archive listings held at once with --descend-archives; larger archives are skipped (0 = unlimited)")
+	scanCmd.Flags().StringVar(&scanFromTar, "from-tar", "", "Build the snapshot from a tar archive (or - for stdin) instead of a live tree")
+	scanCmd.Flags().StringVar(&scanFromList, "from-list", "", "Build the snapshot from a file listing (or - for stdin) instead of a live tree")
+	scanCmd.MarkFlagsMutuallyExclusive("from-tar", "from-list")
+	scanCmd.Flags().StringVar(&scanMemLimit, "memory-limit", "", "Overall memory budget (e.g. 4GiB); sizes caches, queues and channels to fit")
+	scanCmd.Flags().StringVar(&scanQueueMem, "queue-memory", "64MiB", "Memory for pending directories before they spill to disk in the output directory (0 = never spill)")
+	scanCmd.Flags().StringVar(&scanStatMethod, "stat-method", "auto", "How to list and stat directories: auto, lstat, fstatat or statx")
+	scanCmd.Flags().StringVar(&scanShard, "shard", "", "Scan only shard I/N of the root's top-level subtrees into <out>/shard-III (merge with dug merge --shards)")
+	scanCmd.Flags().IntVar(&scanLocalShards, "local-shards", 0, "Run N shard processes on this host, then merge them into one snapshot")
 }
 
 func runScan(cmd *cobra.Command, args []string) error {
 	// Resolve paths
-	root, err := filepath.Abs(scanRoot)
-	if err != nil {
-		return fmt.Errorf("failed to resolve root path: %w", err)
+	roots := make([]string, len(scanRoots))
+	for i, r := range scanRoots {
+		abs, err := filepath.Abs(r)
+		if err != nil {
+			return fmt.Errorf("failed to resolve root path: %w", err)
+		}
+		roots[i] = pathutil.Normalize(abs)
 	}
-	root = pathutil.Normalize(root)
 
 	outDir, err := filepath.Abs(scanOut)
 	if err != nil {
 		return fmt.Errorf("failed to resolve output path: %w", err)
 	}
 
-	fmt.Printf("Scanning %s...\n", root)
+	shardIndex, shardCount, err := parseShard(scanShard)
+	if err != nil {
+		return err
+	}
+	if (shardCount > 0 || scanLocalShards > 0) && len(roots) > 1 {
+		return fmt.Errorf("sharded scans take a single --root")
+	}
+	if shardCount > 0 && scanLocalShards > 0 {
+		return fmt.Errorf("--shard and --local-shards are mutually exclusive")
+	}
+	// Every shard process would need stdin to itself
+	if scanLocalShards > 0 && (scanFromTar == "-" || scanFromList == "-") {
+		return fmt.Errorf("--local-shards cannot read --from-tar or --from-list from stdin; pass a file instead")
+	}
+	if err := scanRetain.check(); err != nil {
+		return err
+	}
+	if scanLocalShards > 0 {
+		return runLocalShards(cmd, outDir, scanLocalShards)
+	}
+	if shardCount > 0 {
+		outDir = shardDir(outDir, shardIndex)
+	}
+
+	method, err := source.ParseStatMethod(scanStatMethod)
+	if err != nil {
+		return err
+	}
+	var src source.Source = source.OS{Method: method}
+
+	// Replayed sources pick their own root unless one is given
+	if scanFromTar != "" || scanFromList != "" {
+		mem, err := loadReplaySource()
+		if err != nil {
+			return err
+		}
+		if !cmd.Flags().Changed("root") {
+			roots = []string{mem.Root()}
+		}
+		src = mem
+	}
+
+	if shardCount > 0 {
+		fmt.Printf("Scanning %s (shard %d/%d)...\n", roots[0], shardIndex, shardCount)
+	} else {
+		fmt.Printf("Scanning %s...\n", strings.Join(roots, ", "))
+	}
 
 	// Configure scanner
 	opts := scan.DefaultOptions().
-		WithWorkers(scanWorkers).
+		WithSource(src).
 		WithXdev(scanXdev).
 		WithMaxErrors(scanMaxErrors).
-		WithVerbose(scanVerbose)
+		WithVerbose(scanVerbose).
+		WithReadDirTimeout(scanDirTimeout).
+		WithStatTimeout(scanStatTimeout).
+		WithDescendArchives(scanArchives).
+		WithSpillDir(outDir).
+		WithShard(shardIndex, shardCount)
+
+	queueMem, err := humanize.ParseBytes(scanQueueMem)
+	if err != nil {
+		return fmt.Errorf("invalid --queue-memory: %w", err)
+	}
+	opts.WithQueueMemory(int64(queueMem))
+	archiveMem, err := humanize.ParseBytes(scanArchiveMem)
+	if err != nil {
+		return fmt.Errorf("invalid --archive-memory: %w", err)
+	}
+	opts.WithArchiveMemory(int64(archiveMem))
+
+	// One budget sizes the scanner's buffers, SQLite's cache and the Go heap
+	var memBudget budget.Budget
+	if scanMemLimit != "" {
+		limit, err := humanize.ParseBytes(scanMemLimit)
+		if err != nil {
+			return fmt.Errorf("invalid --memory-limit: %w", err)
+		}
+		memBudget, err = budget.Split(int64(limit))
+		if err != nil {
+			return err
+		}
+		opts.WithBudget(memBudget)
+		if cmd.Flags().Changed("queue-memory") {
+			opts.WithQueueMemory(int64(queueMem))
+		}
+		if cmd.Flags().Changed("archive-memory") {
+			opts.WithArchiveMemory(int64(archiveMem))
+		}
+		debug.SetMemoryLimit(memBudget.GoHeap)
+		// Sorting for index builds in RAM can dwarf the scan itself
+		if !cmd.Flags().Changed("index-mode") {
+			scanIndexMode = "disk"
+		}
+	}
+
+	if scanWorkers == "auto" {
+		if scanMinWork < 1 || scanMaxWork < scanMinWork {
+			return fmt.Errorf("invalid worker bounds %d-%d", scanMinWork, scanMaxWork)
+		}
+		opts.WithAutoWorkers(scanMinWork, scanMaxWork)
+	} else {
+		n, err := strconv.Atoi(scanWorkers)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid --workers %q (expected a positive number or auto)", scanWorkers)
+		}
+		opts.WithWorkers(n)
+	}
 
 	for _, pattern := range scanExclude {
 		if err := opts.AddExcludePattern(pattern); err != nil {
@@ -85,6 +231,19 @@ func runScan(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	schedule := throttle.Schedule{Default: scanRateLimit}
+	for _, spec := range scanRateSched {
+		window, err := throttle.ParseWindow(spec)
+		if err != nil {
+			return err
+		}
+		schedule.Windows = append(schedule.Windows, window)
+	}
+	limiter := throttle.NewLimiter(schedule.RateAt(time.Now()))
+	opts.WithLimiter(limiter)
+	gate := throttle.NewGate()
+	opts.WithGate(gate)
+
 	switch scanIndexMode {
 	case "memory", "disk", "skip":
 	default:
@@ -92,11 +251,17 @@ func runScan(cmd *cobra.Command, args []string) error {
 	}
 
 	// Use snapshot manager
-	mgr := snapshot.NewManager(outDir, scanRetention)
+	mgr := snapshot.NewManager(outDir, 0)
+	mgr.SetVersion(version)
 	mgr.SetIndexMode(scanIndexMode)
+	mgr.SetSearchIndex(scanSearchIndex)
+	scanRetain.apply(mgr)
 	if scanSQLiteTmp != "" {
 		mgr.SetSQLiteTmpDir(scanSQLiteTmp)
 	}
+	if memBudget.Limit > 0 {
+		mgr.SetSQLiteMemory(memBudget.SQLiteCache, memBudget.SQLiteMmap)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	sigCh := make(chan os.Signal, 2)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
@@ -109,11 +274,64 @@ func runScan(cmd *cobra.Command, args []string) error {
 		os.Exit(130)
 	}()
 	startTime := time.Now()
+	isTTY := isTerminal()
+
+	// Rate limit follows the schedule and control file; SIGUSR1 halves it,
+	// SIGUSR2 doubles it.
+	rateCtl := throttle.NewController(limiter, schedule, scanRateFile)
+	rateCtl.SetChangeFunc(func(rate float64, reason string) {
+		prefix := ""
+		if isTTY {
+			prefix = "\r\033[K"
+		}
+		fmt.Fprintf(os.Stderr, "%sRate limit: %s (%s)\n", prefix, rateLabel(rate), reason)
+	})
+	go rateCtl.Run(ctx)
+	rateSigCh := make(chan os.Signal, 1)
+	signal.Notify(rateSigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(rateSigCh)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-rateSigCh:
+				if sig == syscall.SIGUSR1 {
+					rateCtl.Halve()
+				} else {
+					rateCtl.Double()
+				}
+			}
+		}
+	}()
+
+	// SIGTSTP (Ctrl+Z) pauses the scan in place and SIGCONT resumes it. A
+	// second SIGTSTP also resumes, since the shell never sees the job stop.
+	pauseSigCh := make(chan os.Signal, 1)
+	signal.Notify(pauseSigCh, syscall.SIGTSTP, syscall.SIGCONT)
+	defer signal.Stop(pauseSigCh)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-pauseSigCh:
+				prefix := ""
+				if isTTY {
+					prefix = "\r\033[K"
+				}
+				if sig == syscall.SIGTSTP && gate.Pause("SIGTSTP") {
+					fmt.Fprintf(os.Stderr, "%sPaused. Send SIGCONT (kill -CONT %d) or press Ctrl+Z again to resume.\n", prefix, os.Getpid())
+				} else if gate.Resume(sig.String()) {
+					fmt.Fprintf(os.Stderr, "%sResumed.\n", prefix)
+				}
+			}
+		}
+	}()
 
 	// Set up progress display
 	var lastFiles, lastDirs, lastErrors, lastBytes int64
 	var spinnerIdx int
-	isTTY := isTerminal()
 	var stage atomic.Value
 	stage.Store("scan")
 
@@ -155,6 +373,9 @@ func runScan(cmd *cobra.Command, args []string) error {
 					if stageStr != "" && stageStr != "scan" {
 						fmt.Fprintf(os.Stderr, "\r\033[K%s %s... | %s",
 							spinner, stageStr, elapsed)
+					} else if gate.Paused() {
+						fmt.Fprintf(os.Stderr, "\r\033[K⏸ Paused... %d files | %d dirs | %s | %s",
+							files, dirs, humanizeBytes(bytes), elapsed)
 					} else {
 						// Calculate rate (files per second)
 						rate := float64(0)
@@ -166,6 +387,9 @@ func runScan(cmd *cobra.Command, args []string) error {
 						if errors > 0 {
 							errStr = fmt.Sprintf(" | %d errors", errors)
 						}
+						if rate := limiter.Rate(); rate > 0 {
+							errStr += fmt.Sprintf(" | limit %s", rateLabel(rate))
+						}
 
 						fmt.Fprintf(os.Stderr, "\r\033[K%s Scanning... %d files | %d dirs | %s | %.0f/sec | %s%s",
 							spinner, files, dirs, humanizeBytes(bytes), rate, elapsed, errStr)
@@ -185,9 +409,11 @@ func runScan(cmd *cobra.Command, args []string) error {
 
 					if stageStr != "" && stageStr != "scan" {
 						fmt.Fprintf(os.Stderr, "PROGRESS stage=%s elapsed=%s\n", stageStr, elapsed)
+					} else if gate.Paused() {
+						fmt.Fprintf(os.Stderr, "PROGRESS stage=paused files=%d dirs=%d elapsed=%s\n", files, dirs, elapsed)
 					} else {
-						fmt.Fprintf(os.Stderr, "PROGRESS files=%d dirs=%d bytes=%s rate=%.0f/sec elapsed=%s errors=%d\n",
-							files, dirs, humanizeBytes(bytes), rate, elapsed, errors)
+						fmt.Fprintf(os.Stderr, "PROGRESS files=%d dirs=%d bytes=%s rate=%.0f/sec elapsed=%s errors=%d limit=%.0f\n",
+							files, dirs, humanizeBytes(bytes), rate, elapsed, errors, limiter.Rate())
 					}
 					lastNonTTY = time.Now()
 				}
@@ -195,7 +421,7 @@ func runScan(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
-	dbPath, err := mgr.RunScan(ctx, root, opts)
+	dbPath, err := mgr.RunScanRoots(ctx, roots, opts)
 	close(progressDone)
 
 	// Clear progress line
@@ -233,6 +459,9 @@ func runScan(cmd *cobra.Command, args []string) error {
 	if errorCount > 0 {
 		fmt.Printf("  Errors: %d\n", errorCount)
 	}
+	if len(roots) > 1 {
+		printRootTotals(database, "  ")
+	}
 
 	return nil
 }
@@ -250,6 +479,45 @@ func humanizeBytes(b int64) string {
 	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
 }
 
+// loadReplaySource reads --from-tar or --from-list into an in-memory tree.
+func loadReplaySource() (*source.Memory, error) {
+	name := scanFromTar
+	if name == "" {
+		name = scanFromList
+	}
+
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	fmt.Printf("Reading %s...\n", name)
+	if scanFromTar != "" {
+		mem, err := source.ParseTar(r, "/")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar %s: %w", name, err)
+		}
+		return mem, nil
+	}
+	mem, err := source.ParseListing(r, "/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read listing %s: %w", name, err)
+	}
+	return mem, nil
+}
+
+func rateLabel(rate float64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%.0f ops/s", rate)
+}
+
 func isTerminal() bool {
 	fi, err := os.Stdout.Stat()
 	if err != nil {
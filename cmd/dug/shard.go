@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/michaelscutari/dug/internal/budget"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// parseShard parses a --shard value of the form I/N. An empty value means
+// the scan is not sharded and returns a count of 0.
+func parseShard(s string) (index, count int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	i, n, ok := strings.Cut(s, "/")
+	if ok {
+		index, err = strconv.Atoi(i)
+		if err == nil {
+			count, err = strconv.Atoi(n)
+		}
+	}
+	if !ok || err != nil || count < 1 || index < 0 || index >= count {
+		return 0, 0, fmt.Errorf("invalid --shard %q (expected I/N with 0 <= I < N)", s)
+	}
+	return index, count, nil
+}
+
+// shardDir is where shard index keeps its snapshots under the output directory.
+func shardDir(outDir string, index int) string {
+	return filepath.Join(outDir, fmt.Sprintf("shard-%03d", index))
+}
+
+// shardSnapshots returns the latest snapshot of every shard under dir.
+func shardSnapshots(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "shard-*", "latest.db"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no shard snapshots found under %s", dir)
+	}
+	return matches, nil
+}
+
+// runLocalShards re-runs this binary as count shard processes with the same
+// scan flags, waits for them, and merges their snapshots into outDir.
+func runLocalShards(cmd *cobra.Command, outDir string, count int) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate dug binary: %w", err)
+	}
+
+	// Forward every flag the user set except the ones this coordinator owns.
+	// Only the merged snapshot gets a search index, and only merged
+	// snapshots are worth archiving.
+	var base []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		switch f.Name {
+		case "local-shards", "shard", "out", "search-index", "archive", "archive-depth", "memory-limit":
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			for _, v := range sv.GetSlice() {
+				base = append(base, fmt.Sprintf("--%s=%s", f.Name, v))
+			}
+			return
+		}
+		base = append(base, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+	})
+
+	// The shards run side by side, so each gets its share of the budget
+	if scanMemLimit != "" {
+		limit, err := humanize.ParseBytes(scanMemLimit)
+		if err != nil {
+			return fmt.Errorf("invalid --memory-limit: %w", err)
+		}
+		share := int64(limit) / int64(count)
+		if _, err := budget.Split(share); err != nil {
+			return fmt.Errorf("--memory-limit %s split across %d shards leaves %s each: %w",
+				scanMemLimit, count, humanize.IBytes(uint64(share)), err)
+		}
+		base = append(base, fmt.Sprintf("--memory-limit=%d", share))
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	fmt.Printf("Running %d shard processes...\n", count)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	errs := make([]error, count)
+	for i := 0; i < count; i++ {
+		dir := shardDir(outDir, i)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create shard directory: %w", err)
+		}
+		logPath := filepath.Join(dir, "scan.log")
+		logFile, err := os.Create(logPath)
+		if err != nil {
+			return fmt.Errorf("failed to create shard log: %w", err)
+		}
+
+		args := append([]string{"scan", "--out", outDir, "--shard", fmt.Sprintf("%d/%d", i, count)}, base...)
+		child := exec.CommandContext(ctx, exe, args...)
+		child.Stdout = logFile
+		child.Stderr = logFile
+		child.Cancel = func() error { return child.Process.Signal(os.Interrupt) }
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer logFile.Close()
+			if err := child.Run(); err != nil {
+				errs[i] = fmt.Errorf("shard %d failed (see %s): %w", i, logPath, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Scan canceled.")
+		return nil
+	}
+	fmt.Printf("Shards completed in %s\n", time.Since(start).Round(time.Millisecond))
+
+	inputs := make([]string, count)
+	for i := range inputs {
+		inputs[i] = filepath.Join(shardDir(outDir, i), "latest.db")
+	}
+	return mergeSnapshots(ctx, inputs, outDir, scanRetain, scanSearchIndex)
+}
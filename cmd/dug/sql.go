@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/spf13/cobra"
+
+	_ "modernc.org/sqlite"
+)
+
+var sqlCmd = &cobra.Command{
+	Use:   "sql",
+	Short: "Run SQL against a scan database",
+	Long: `Run SQL against a scan database opened read-only, with helper views
+(files, dir_tree, dir_paths) and functions (human_bytes, age_days,
+kind_name). With -e each query runs and dug exits; otherwise queries are
+read from stdin, one per ';', with a prompt when stdin is a terminal.`,
+	Example: `  dug sql -e "SELECT path, human_bytes(size) FROM files ORDER BY size DESC LIMIT 10"
+  dug sql --format csv -e "SELECT path, total_files FROM dir_tree WHERE depth = 1" > top.csv`,
+	RunE: runSQL,
+}
+
+var (
+	sqlDB     string
+	sqlExec   []string
+	sqlFormat string
+)
+
+func init() {
+	sqlCmd.Flags().StringVarP(&sqlDB, "db", "d", "./data/latest.db", "Path to database file")
+	sqlCmd.Flags().StringArrayVarP(&sqlExec, "execute", "e", nil, "Run this query and exit (repeatable)")
+	sqlCmd.Flags().StringVarP(&sqlFormat, "format", "f", "table", "Output format: table, csv or json")
+}
+
+func runSQL(cmd *cobra.Command, args []string) error {
+	if err := checkSQLFormat(sqlFormat); err != nil {
+		return err
+	}
+	if err := db.RegisterFunctions(); err != nil {
+		return err
+	}
+
+	path, cleanup, err := db.Uncompress(sqlDB)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	database, err := db.OpenReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	// The helper views are TEMP, so every query must use the connection
+	// that created them
+	database.SetMaxOpenConns(1)
+	if err := db.ApplyReadPragmas(database); err != nil {
+		return fmt.Errorf("failed to apply pragmas: %w", err)
+	}
+	if err := db.CreateHelperViews(database); err != nil {
+		return err
+	}
+
+	if len(sqlExec) > 0 {
+		for _, query := range sqlExec {
+			if err := runSQLQuery(database, os.Stdout, query, sqlFormat); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	fi, err := os.Stdin.Stat()
+	interactive := err == nil && fi.Mode()&os.ModeCharDevice != 0
+	return sqlShell(database, os.Stdin, os.Stdout, interactive)
+}
+
+func checkSQLFormat(format string) error {
+	switch format {
+	case "table", "csv", "json":
+		return nil
+	}
+	return fmt.Errorf("invalid format %q (expected table|csv|json)", format)
+}
+
+// sqlShell reads queries from in, each ended by a ';' at the end of a line,
+// and dot commands such as .help. Interactive shells prompt and carry on
+// after errors; otherwise the first error stops the shell.
+func sqlShell(database *sql.DB, in io.Reader, out io.Writer, interactive bool) error {
+	if interactive {
+		fmt.Fprintln(out, `Enter SQL ending in ";", or .help for help.`)
+	}
+	format := sqlFormat
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	var buf strings.Builder
+	for {
+		if interactive {
+			if buf.Len() == 0 {
+				fmt.Fprint(out, "dug> ")
+			} else {
+				fmt.Fprint(out, "...> ")
+			}
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+
+		var err error
+		switch trimmed := strings.TrimSpace(line); {
+		case buf.Len() == 0 && trimmed == "":
+			continue
+		case buf.Len() == 0 && strings.HasPrefix(trimmed, "."):
+			var quit bool
+			quit, err = sqlDotCommand(database, out, trimmed, &format)
+			if quit {
+				return nil
+			}
+		default:
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+			if !strings.HasSuffix(trimmed, ";") || !quotesBalanced(buf.String()) {
+				continue
+			}
+			query := buf.String()
+			buf.Reset()
+			err = runSQLQuery(database, out, query, format)
+		}
+		if err != nil {
+			if !interactive {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(buf.String()) != "" {
+		return runSQLQuery(database, out, buf.String(), format)
+	}
+	if interactive {
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+// quotesBalanced reports whether s closes every string and quoted
+// identifier it opens, so a ';' at the end of the line ends the statement.
+func quotesBalanced(s string) bool {
+	var open rune
+	for _, r := range s {
+		switch {
+		case open == 0 && (r == '\'' || r == '"'):
+			open = r
+		case r == open:
+			open = 0
+		}
+	}
+	return open == 0
+}
+
+func sqlDotCommand(database *sql.DB, out io.Writer, line string, format *string) (quit bool, err error) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ".quit", ".exit":
+		return true, nil
+	case ".help":
+		fmt.Fprintln(out, `.format table|csv|json  Set the output format
+.tables                 List tables and helper views
+.schema NAME            Show how a table or view is defined
+.views                  Describe the helper views and functions
+.quit                   Exit`)
+	case ".format":
+		if len(fields) != 2 {
+			return false, fmt.Errorf("usage: .format table|csv|json")
+		}
+		if err := checkSQLFormat(fields[1]); err != nil {
+			return false, err
+		}
+		*format = fields[1]
+	case ".tables":
+		return false, runSQLQuery(database, out, `
+			SELECT name, type FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%'
+			UNION ALL
+			SELECT name, 'temp view' FROM sqlite_temp_master WHERE type = 'view'
+			ORDER BY name`, *format)
+	case ".schema":
+		if len(fields) != 2 {
+			return false, fmt.Errorf("usage: .schema NAME")
+		}
+		return false, runSQLQuery(database, out, `
+			SELECT sql FROM sqlite_master WHERE name = ?1 AND sql IS NOT NULL
+			UNION ALL
+			SELECT sql FROM sqlite_temp_master WHERE name = ?1`, *format, fields[1])
+	case ".views":
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, v := range db.HelperViews {
+			fmt.Fprintf(w, "%s\t%s\n", v.Name, v.Description)
+		}
+		for _, f := range db.HelperFunctions {
+			fmt.Fprintf(w, "%s\t%s\n", f.Name, f.Description)
+		}
+		w.Flush()
+	default:
+		return false, fmt.Errorf("unknown command %s (try .help)", fields[0])
+	}
+	return false, nil
+}
+
+// runSQLQuery runs one query and writes its rows in format.
+func runSQLQuery(database *sql.DB, out io.Writer, query, format string, args ...any) error {
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		return rows.Err()
+	}
+
+	var emit func(values []any) error
+	var done func() error
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		if err := w.Write(cols); err != nil {
+			return err
+		}
+		record := make([]string, len(cols))
+		emit = func(values []any) error {
+			for i, v := range values {
+				record[i] = ""
+				if v != nil {
+					record[i] = fmt.Sprint(v)
+				}
+			}
+			return w.Write(record)
+		}
+		done = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	case "json":
+		// Objects keep the column order, which a map would lose
+		keys := make([][]byte, len(cols))
+		for i, c := range cols {
+			keys[i], _ = json.Marshal(c)
+		}
+		n := 0
+		fmt.Fprint(out, "[")
+		emit = func(values []any) error {
+			var b strings.Builder
+			if n > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString("\n  {")
+			for i, v := range values {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				value, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				b.Write(keys[i])
+				b.WriteString(": ")
+				b.Write(value)
+			}
+			b.WriteByte('}')
+			n++
+			_, err := io.WriteString(out, b.String())
+			return err
+		}
+		done = func() error {
+			if n > 0 {
+				fmt.Fprint(out, "\n")
+			}
+			_, err := fmt.Fprintln(out, "]")
+			return err
+		}
+	default:
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(cols, "\t"))
+		cells := make([]string, len(cols))
+		emit = func(values []any) error {
+			for i, v := range values {
+				cells[i] = "NULL"
+				if v != nil {
+					cells[i] = fmt.Sprint(v)
+				}
+			}
+			_, err := fmt.Fprintln(w, strings.Join(cells, "\t"))
+			return err
+		}
+		done = w.Flush
+	}
+
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		if err := emit(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return done()
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 
 	"github.com/michaelscutari/dug/internal/db"
@@ -26,9 +25,15 @@ func init() {
 }
 
 func runTUI(cmd *cobra.Command, args []string) error {
-	database, err := sql.Open("sqlite", tuiDB+"?mode=ro")
+	path, cleanup, err := db.Uncompress(tuiDB)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return err
+	}
+	defer cleanup()
+
+	database, err := db.OpenReadOnly(path)
+	if err != nil {
+		return err
 	}
 	defer database.Close()
 
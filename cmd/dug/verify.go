@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/spf13/cobra"
+
+	_ "modernc.org/sqlite"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check a scan database for corruption",
+	Long: `Check a scan database for corruption, such as a snapshot truncated by
+a full disk. Runs SQLite's integrity check, then checks that every entry
+and directory has a parent, every directory has a rollup, every rollup
+adds up, and the scan_meta totals match the top directory.
+
+Exits non-zero when any check fails, so cron jobs can alert on it.`,
+	RunE: runVerify,
+}
+
+var (
+	verifyDB    string
+	verifyQuick bool
+)
+
+func init() {
+	verifyCmd.Flags().StringVarP(&verifyDB, "db", "d", "./data/latest.db", "Path to database file")
+	verifyCmd.Flags().BoolVar(&verifyQuick, "quick", false, "Use PRAGMA quick_check, which skips checking indexes against their tables")
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	// Failures below are findings, not usage mistakes
+	cmd.SilenceUsage = true
+
+	path, cleanup, err := db.Uncompress(verifyDB)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	database, err := db.OpenReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	problems, err := db.Verify(database, verifyQuick)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %w", verifyDB, err)
+	}
+
+	byCheck := make(map[string][]string)
+	for _, p := range problems {
+		byCheck[p.Check] = append(byCheck[p.Check], p.Message)
+	}
+	for _, check := range db.VerifyChecks {
+		msgs := byCheck[check]
+		if len(msgs) == 0 {
+			fmt.Printf("%-10s ok\n", check)
+			continue
+		}
+		for _, msg := range msgs {
+			fmt.Printf("%-10s FAIL %s\n", check, msg)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s failed verification with %d problem(s)", verifyDB, len(problems))
+	}
+	return nil
+}
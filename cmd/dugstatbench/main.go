@@ -19,8 +19,18 @@ func main() {
 	shuffle := flag.Bool("shuffle", false, "Shuffle sampled paths")
 	sampleSeed := flag.Int64("seed", 0, "Shuffle seed (0 = time-based)")
 	inline := flag.Bool("inline", false, "Measure lstat during walk to avoid warming cache")
+	methods := flag.String("methods", "", "Compare stat methods per directory: comma list of lstat, fstatat, statx, auto, or all")
+	skipDirs := flag.Bool("skip-dirs", false, "With -methods, skip stat for entries the listing marks as directories (as with --xdev=false)")
 	flag.Parse()
 
+	if *methods != "" {
+		if err := runMethods(*dir, *methods, *workers, *limit, *recursive, *skipDirs); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *inline && *shuffle {
 		fmt.Fprintln(os.Stderr, "warning: --shuffle is ignored with --inline")
 		*shuffle = false
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/entry"
+	"github.com/michaelscutari/dug/internal/source"
+)
+
+// methodResult is the outcome of walking the tree with one stat method.
+type methodResult struct {
+	dirs      int64
+	stats     int64
+	dirStats  int64 // of stats, directories stat'ed for their device alone
+	skipped   int64
+	errors    int64
+	readDirUs int64
+	statUs    int64
+	elapsed   time.Duration
+}
+
+// runMethods walks dir once per method, listing each directory and stat'ing
+// its children the way the scanner does, and prints one line per method.
+// Children listed as directories are stat'ed for their device alone, as
+// under --xdev, or skipped with skipDirs.
+func runMethods(dir string, methods string, workers, limit int, recursive, skipDirs bool) error {
+	var parsed []source.StatMethod
+	for _, name := range strings.Split(methods, ",") {
+		if name == "all" {
+			parsed = append(parsed, source.StatLstat, source.StatFstatat, source.StatStatx)
+			continue
+		}
+		m, err := source.ParseStatMethod(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, m)
+	}
+
+	fmt.Printf("dir=%s workers=%d recursive=%t skip-dirs=%t limit=%d\n", dir, workers, recursive, skipDirs, limit)
+	fmt.Println("note: earlier methods warm the cache for later ones; repeat the run or reorder -methods to compare fairly")
+	fmt.Printf("%-8s %8s %10s %10s %8s %12s %12s %10s %14s\n", "METHOD", "DIRS", "STATS", "DIR-STATS", "ERRORS", "AVG-READDIR", "AVG-STAT", "TOTAL", "ENTRIES/SEC")
+	for _, m := range parsed {
+		r := walkMethod(source.OS{Method: m}, dir, workers, limit, recursive, skipDirs)
+		avgRead, avgStat := time.Duration(0), time.Duration(0)
+		if r.dirs > 0 {
+			avgRead = time.Duration(r.readDirUs/r.dirs) * time.Microsecond
+		}
+		if r.stats > 0 {
+			avgStat = time.Duration(r.statUs/r.stats) * time.Microsecond
+		}
+		rate := 0.0
+		if r.elapsed.Seconds() > 0 {
+			rate = float64(r.stats+r.skipped) / r.elapsed.Seconds()
+		}
+		fmt.Printf("%-8s %8d %10d %10d %8d %12v %12v %10v %14.0f\n", m, r.dirs, r.stats, r.dirStats, r.errors, avgRead, avgStat, r.elapsed.Round(time.Millisecond), rate)
+	}
+	return nil
+}
+
+func walkMethod(src source.OS, root string, workers, limit int, recursive, skipDirs bool) methodResult {
+	var r methodResult
+	var seen int64
+	var pending sync.WaitGroup
+	queue := make(chan string, 1<<16)
+
+	var visit func(path string)
+	visit = func(path string) {
+		defer pending.Done()
+		t0 := time.Now()
+		d, err := src.OpenDir(path)
+		if err != nil {
+			atomic.AddInt64(&r.errors, 1)
+			return
+		}
+		defer d.Close()
+		children, err := d.ReadDir()
+		atomic.AddInt64(&r.readDirUs, time.Since(t0).Microseconds())
+		atomic.AddInt64(&r.dirs, 1)
+		if err != nil {
+			atomic.AddInt64(&r.errors, 1)
+			return
+		}
+
+		for _, de := range children {
+			if limit > 0 && atomic.AddInt64(&seen, 1) > int64(limit) {
+				return
+			}
+			kind := de.Kind
+			if kind == entry.KindDir && skipDirs {
+				atomic.AddInt64(&r.skipped, 1)
+			} else {
+				stat := d.Lstat
+				if kind == entry.KindDir {
+					stat = func(name string) (source.Stat, error) { return source.StatDev(d, name) }
+					atomic.AddInt64(&r.dirStats, 1)
+				}
+				t1 := time.Now()
+				st, err := stat(de.Name)
+				atomic.AddInt64(&r.statUs, time.Since(t1).Microseconds())
+				atomic.AddInt64(&r.stats, 1)
+				if err != nil {
+					atomic.AddInt64(&r.errors, 1)
+					continue
+				}
+				kind = st.Kind
+			}
+			if recursive && kind == entry.KindDir {
+				pending.Add(1)
+				child := filepath.Join(path, de.Name)
+				select {
+				case queue <- child:
+				default:
+					visit(child) // queue full: walk it inline
+				}
+			}
+		}
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				visit(path)
+			}
+		}()
+	}
+	pending.Add(1)
+	queue <- root
+	pending.Wait()
+	close(queue)
+	wg.Wait()
+	r.elapsed = time.Since(start)
+
+	if r.errors > 0 && r.dirs == 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s could not read %s\n", src.Method, root)
+	}
+	return r
+}
@@ -0,0 +1,74 @@
+// Package budget splits a single memory limit across the parts of a scan that
+// hold memory: the Go heap (channels, work queue, rollup state) and SQLite's
+// page cache and mmap window, which live outside the Go heap.
+package budget
+
+import "fmt"
+
+// MinLimit is the smallest limit a scan can reasonably run in.
+const MinLimit = 128 << 20
+
+// Per-item estimates used to turn byte shares into counts.
+const (
+	// PendingDirBytes approximates one directory held by the rollup
+	// aggregator: the rollup struct plus its map slots.
+	PendingDirBytes = 256
+
+	// ArchiveMemberBytes approximates one archive member held while its
+	// archive's listing is built, not counting its path.
+	ArchiveMemberBytes = 256
+)
+
+// Budget is the share of a memory limit given to each consumer.
+type Budget struct {
+	// Limit is the overall target in bytes.
+	Limit int64
+
+	// SQLiteCache is the page cache for the scan database.
+	SQLiteCache int64
+
+	// SQLiteMmap is the memory-mapped I/O window.
+	SQLiteMmap int64
+
+	// GoHeap is the soft limit for the Go runtime (debug.SetMemoryLimit).
+	// It covers everything except SQLite, which allocates outside the heap.
+	GoHeap int64
+
+	// Channels bounds the buffered pipeline channels.
+	Channels int64
+
+	// QueueMemory bounds pending directory work before it spills to disk.
+	QueueMemory int64
+
+	// ArchiveMemory bounds the archive listings held by all workers.
+	ArchiveMemory int64
+
+	// MaxPendingDirs is how many incomplete directories the rollup
+	// aggregator may hold before workers are pushed to finish subtrees
+	// depth-first.
+	MaxPendingDirs int
+}
+
+// Split divides limit between the scan's memory consumers. Roughly: an eighth
+// each to the SQLite cache, the mmap window (capped at 256 MiB), the pipeline
+// channels and the aggregator, a sixteenth each to the work queue and archive
+// listings, and the remainder is headroom for the Go runtime and index builds.
+func Split(limit int64) (Budget, error) {
+	if limit < MinLimit {
+		return Budget{}, fmt.Errorf("memory limit %d bytes is below the minimum of %d", limit, int64(MinLimit))
+	}
+
+	b := Budget{
+		Limit:         limit,
+		SQLiteCache:   limit / 8,
+		SQLiteMmap:    min(limit/8, 256<<20),
+		Channels:      limit / 8,
+		QueueMemory:   limit / 16,
+		ArchiveMemory: limit / 16,
+	}
+	b.MaxPendingDirs = int(limit / 8 / PendingDirBytes)
+
+	// Leave a tenth of the limit for fragmentation and the runtime itself.
+	b.GoHeap = limit - b.SQLiteCache - b.SQLiteMmap - limit/10
+	return b, nil
+}
@@ -0,0 +1,25 @@
+package budget
+
+import "testing"
+
+func TestSplitStaysWithinLimit(t *testing.T) {
+	for _, limit := range []int64{MinLimit, 1 << 30, 4 << 30, 64 << 30} {
+		b, err := Split(limit)
+		if err != nil {
+			t.Fatalf("split %d: %v", limit, err)
+		}
+		if b.SQLiteCache+b.SQLiteMmap+b.GoHeap > limit {
+			t.Fatalf("limit %d: sqlite %d + mmap %d + heap %d exceeds limit", limit, b.SQLiteCache, b.SQLiteMmap, b.GoHeap)
+		}
+		if b.Channels+b.QueueMemory+b.ArchiveMemory+int64(b.MaxPendingDirs)*PendingDirBytes > b.GoHeap {
+			t.Fatalf("limit %d: heap consumers exceed heap share %d", limit, b.GoHeap)
+		}
+		if b.SQLiteMmap > 256<<20 {
+			t.Fatalf("limit %d: mmap %d above cap", limit, b.SQLiteMmap)
+		}
+	}
+
+	if _, err := Split(MinLimit - 1); err == nil {
+		t.Fatalf("expected error below minimum")
+	}
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// CompressedExt is the suffix of gzip-compressed snapshots.
+const CompressedExt = ".gz"
+
+// Summarize reduces a snapshot to its directories down to depth, with
+// their rollups, and drops every file entry along with the tables derived
+// from them. Totals stay exact, since rollups already include everything
+// below them. The snapshot is vacuumed afterwards to return the space.
+func Summarize(db *sql.DB, depth int) error {
+	listing := hasTable(db, "dir_listing")
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		`DELETE FROM entries`,
+		fmt.Sprintf(`DELETE FROM rollups WHERE dir_id IN (SELECT id FROM dirs WHERE depth > %d)`, depth),
+		fmt.Sprintf(`DELETE FROM dirs WHERE depth > %d`, depth),
+		`DROP TABLE IF EXISTS name_index`,
+		`DROP TABLE IF EXISTS file_hashes`,
+	}
+	if listing {
+		stmts = append(stmts, `DELETE FROM dir_listing WHERE dir_id NOT IN (SELECT id FROM dirs)`)
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to summarize snapshot: %w", err)
+		}
+	}
+	if err := setInfo(tx, InfoSummaryDepth, strconv.Itoa(depth)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit summary: %w", err)
+	}
+
+	if _, err := db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("failed to vacuum summary: %w", err)
+	}
+	return nil
+}
+
+// SummaryDepth returns the depth a summary snapshot keeps directories to,
+// and false for full snapshots.
+func SummaryDepth(db *sql.DB) (int, bool) {
+	info, err := SchemaInfo(db)
+	if err != nil {
+		return 0, false
+	}
+	depth, err := strconv.Atoi(info[InfoSummaryDepth])
+	if err != nil {
+		return 0, false
+	}
+	return depth, true
+}
+
+// Uncompress makes a snapshot openable by SQLite. A path ending in .gz is
+// decompressed to a temporary file, which cleanup removes; any other path
+// is returned as is, with a cleanup that does nothing. Until cleanup runs,
+// an interrupt or termination signal also removes the temporary file, which
+// can be many gigabytes, before the signal takes its usual effect.
+func Uncompress(path string) (string, func(), error) {
+	if !strings.HasSuffix(path, CompressedExt) {
+		return path, func() {}, nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	defer zr.Close()
+
+	out, err := os.CreateTemp("", "dug-*.db")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp database: %w", err)
+	}
+	cleanup := removeOnSignal(out.Name())
+	if _, err := io.Copy(out, zr); err != nil {
+		out.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	if err := out.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	return out.Name(), cleanup, nil
+}
+
+// removeOnSignal removes name when the returned cleanup is called or the
+// process receives SIGINT or SIGTERM, whichever comes first. The signal is
+// then raised again so the process exits as it would have.
+func removeOnSignal(name string) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(done)
+			os.Remove(name)
+		})
+	}
+	go func() {
+		select {
+		case sig := <-sigs:
+			cleanup()
+			syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+		case <-done:
+		}
+	}()
+	return cleanup
+}
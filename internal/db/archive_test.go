@@ -0,0 +1,92 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func writeCompressed(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "snap.db"+CompressedExt)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte("not really a database"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUncompressCleanup(t *testing.T) {
+	path, cleanup, err := Uncompress(writeCompressed(t, t.TempDir()))
+	if err != nil {
+		t.Fatalf("uncompress: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "not really a database" {
+		t.Fatalf("decompressed %q, %v", data, err)
+	}
+	cleanup()
+	cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("temp file left behind: %v", err)
+	}
+}
+
+// TestUncompressRemovesOnSignal runs the test binary again as a helper that
+// decompresses a snapshot and waits, then terminates it.
+func TestUncompressRemovesOnSignal(t *testing.T) {
+	if src := os.Getenv("DUG_UNCOMPRESS_HELPER"); src != "" {
+		path, _, err := Uncompress(src)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		fmt.Println(path)
+		select {}
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestUncompressRemovesOnSignal$")
+	cmd.Env = append(os.Environ(), "DUG_UNCOMPRESS_HELPER="+writeCompressed(t, t.TempDir()))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	path, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("read helper output: %v", err)
+	}
+	path = strings.TrimSpace(path)
+	if _, err := os.Stat(path); err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("helper reported %q: %v", path, err)
+	}
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	err = cmd.Wait()
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() || status.Signal() != syscall.SIGTERM {
+		t.Fatalf("helper exited with %v, want termination by SIGTERM", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		os.Remove(path)
+		t.Fatalf("temp file left behind after SIGTERM: %v", err)
+	}
+}
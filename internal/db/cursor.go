@@ -0,0 +1,132 @@
+package db
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Cursor marks a position in a directory listing: LoadChildrenAfter returns
+// the entries that sort after it. Unlike an offset it costs the same
+// however deep into a listing it points: the rows before it are filtered
+// out rather than sorted and skipped.
+//
+// The zero Cursor is the start of the listing.
+type Cursor struct {
+	Sort  string // the order the cursor was taken in
+	Value int64  // the entry's value of the sort column; zero for name order
+	Name  string
+	Key   int64 // entries.id, or the negated dirs.id
+}
+
+// IsZero reports whether c is the start of a listing.
+func (c Cursor) IsZero() bool {
+	return c == Cursor{}
+}
+
+// String encodes the cursor as an opaque token for ParseCursor.
+func (c Cursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	raw := fmt.Sprintf("%s:%d:%d:%s", c.Sort, c.Value, c.Key, c.Name)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a token from Cursor.String. The empty token is the
+// zero Cursor.
+func ParseCursor(token string) (Cursor, error) {
+	if token == "" {
+		return Cursor{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", token)
+	}
+	parts := strings.SplitN(string(raw), ":", 4)
+	if len(parts) != 4 {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", token)
+	}
+	c := Cursor{Sort: parts[0], Name: parts[3]}
+	if c.Value, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", token)
+	}
+	if c.Key, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", token)
+	}
+	return c, nil
+}
+
+// Cursor returns the position just after e in a listing sorted by sortBy.
+// Only entries from LoadChildren and its variants carry the key it needs.
+func (e DisplayEntry) Cursor(sortBy string) Cursor {
+	order := childOrderFor(sortBy)
+	return Cursor{Sort: order.name, Value: order.value(e), Name: e.Name, Key: e.Key}
+}
+
+// childOrder is one of the orders a directory listing can be sorted in.
+type childOrder struct {
+	name   string // canonical sort name recorded in cursors
+	column string // sort column, or "" to sort by name alone
+}
+
+func childOrderFor(sortBy string) childOrder {
+	switch sortBy {
+	case "name":
+		return childOrder{name: "name"}
+	case "files":
+		return childOrder{name: "files", column: "total_files"}
+	case "blocks", "disk":
+		return childOrder{name: "disk", column: "total_blocks"}
+	default:
+		return childOrder{name: "size", column: "total_size"}
+	}
+}
+
+// value returns e's value of the sort column, or zero for name order.
+func (o childOrder) value(e DisplayEntry) int64 {
+	switch o.name {
+	case "size":
+		return e.TotalSize
+	case "disk":
+		return e.TotalBlocks
+	case "files":
+		return e.TotalFiles
+	}
+	return 0
+}
+
+// less reports whether a sorts before b in the listing, as orderBy sorts
+// them in SQL.
+func (o childOrder) less(a, b DisplayEntry) bool {
+	if va, vb := o.value(a), o.value(b); va != vb {
+		return va > vb
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.Key < b.Key
+}
+
+// orderBy is the ORDER BY clause for the listing, with key the expression
+// for ascending key order. Ties sort by name and then key, so every
+// position is distinct.
+func (o childOrder) orderBy(key string) string {
+	if o.column == "" {
+		return "name, " + key
+	}
+	return o.column + " DESC, name, " + key
+}
+
+// after is a WHERE condition selecting the rows that sort after c, with
+// its arguments. It leads with a bound on the first sort column alone, so
+// the listing indexes can seek to c rather than scan up to it.
+func (o childOrder) after(c Cursor) (string, []any) {
+	tie := "(name > ? OR (name = ? AND key > ?))"
+	if o.column == "" {
+		return "name >= ? AND " + tie, []any{c.Name, c.Name, c.Name, c.Key}
+	}
+	return fmt.Sprintf("%[1]s <= ? AND (%[1]s < ? OR (%[1]s = ? AND %[2]s))", o.column, tie),
+		[]any{c.Value, c.Value, c.Value, c.Name, c.Name, c.Key}
+}
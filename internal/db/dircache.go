@@ -8,48 +8,50 @@ import (
 
 const dirCacheSize = 4096
 
-type dirCacheEntry struct {
-	key   string
-	value int64
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
 }
 
-type dirCache struct {
+// lruCache is a fixed-size map that evicts the least recently used key.
+type lruCache[K comparable, V any] struct {
 	mu    sync.Mutex
 	max   int
 	ll    *list.List
-	items map[string]*list.Element
+	items map[K]*list.Element
 }
 
-func newDirCache(max int) *dirCache {
-	return &dirCache{
+func newLRUCache[K comparable, V any](max int) *lruCache[K, V] {
+	return &lruCache[K, V]{
 		max:   max,
 		ll:    list.New(),
-		items: make(map[string]*list.Element),
+		items: make(map[K]*list.Element),
 	}
 }
 
-func (c *dirCache) Get(key string) (int64, bool) {
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if el, ok := c.items[key]; ok {
 		c.ll.MoveToFront(el)
-		return el.Value.(dirCacheEntry).value, true
+		return el.Value.(lruEntry[K, V]).value, true
 	}
-	return 0, false
+	var zero V
+	return zero, false
 }
 
-func (c *dirCache) Set(key string, value int64) {
+func (c *lruCache[K, V]) Set(key K, value V) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if el, ok := c.items[key]; ok {
-		el.Value = dirCacheEntry{key: key, value: value}
+		el.Value = lruEntry[K, V]{key: key, value: value}
 		c.ll.MoveToFront(el)
 		return
 	}
 
-	el := c.ll.PushFront(dirCacheEntry{key: key, value: value})
+	el := c.ll.PushFront(lruEntry[K, V]{key: key, value: value})
 	c.items[key] = el
 
 	if c.ll.Len() > c.max {
@@ -58,20 +60,37 @@ func (c *dirCache) Set(key string, value int64) {
 			return
 		}
 		c.ll.Remove(last)
-		delete(c.items, last.Value.(dirCacheEntry).key)
+		delete(c.items, last.Value.(lruEntry[K, V]).key)
 	}
 }
 
-var dbDirCaches sync.Map // map[*sql.DB]*dirCache
+// dirCache maps directory paths to IDs; pathCache maps IDs back to paths.
+type (
+	dirCache  = lruCache[string, int64]
+	pathCache = lruCache[int64, string]
+)
+
+var (
+	dbDirCaches  sync.Map // map[*sql.DB]*dirCache
+	dbPathCaches sync.Map // map[*sql.DB]*pathCache
+)
 
 func getDirCache(db *sql.DB) *dirCache {
+	return loadCache[string, int64](&dbDirCaches, db)
+}
+
+func getPathCache(db *sql.DB) *pathCache {
+	return loadCache[int64, string](&dbPathCaches, db)
+}
+
+func loadCache[K comparable, V any](caches *sync.Map, db *sql.DB) *lruCache[K, V] {
 	if db == nil {
 		return nil
 	}
-	if existing, ok := dbDirCaches.Load(db); ok {
-		return existing.(*dirCache)
+	if existing, ok := caches.Load(db); ok {
+		return existing.(*lruCache[K, V])
 	}
-	cache := newDirCache(dirCacheSize)
-	actual, _ := dbDirCaches.LoadOrStore(db, cache)
-	return actual.(*dirCache)
+	cache := newLRUCache[K, V](dirCacheSize)
+	actual, _ := caches.LoadOrStore(db, cache)
+	return actual.(*lruCache[K, V])
 }
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+type columnKey struct {
+	db     *sql.DB
+	table  string
+	column string
+}
+
+var dbColumns sync.Map // map[columnKey]bool
+
+// hasColumn reports whether table has column, caching the answer per
+// database. Readers use it to keep older snapshots browsable.
+func hasColumn(db *sql.DB, table, column string) bool {
+	key := columnKey{db: db, table: table, column: column}
+	if v, ok := dbColumns.Load(key); ok {
+		return v.(bool)
+	}
+
+	var n int
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = ?`, table), column).Scan(&n)
+	found := err == nil && n > 0
+	if err == nil {
+		dbColumns.Store(key, found)
+	}
+	return found
+}
+
+// dirKind is an expression for the kind of the directory aliased as alias.
+// Snapshots from before archive support have no dirs.kind column, and all
+// their directories are plain ones.
+func dirKind(db *sql.DB, alias string) string {
+	if hasColumn(db, "dirs", "kind") {
+		return alias + ".kind"
+	}
+	return fmt.Sprintf("%d", entry.KindDir)
+}
+
+// dirListing selects the rows of dir_listing: every directory with its
+// rollup. A descended archive stands in for the archive file beside it,
+// so it is listed with that file's size, disk usage and mtime rather than
+// its members' totals, and the listing still adds up to its parent.
+func dirListing(db *sql.DB) string {
+	return fmt.Sprintf(`
+		SELECT d.id AS dir_id, d.parent_id, d.name, %[1]s AS kind,
+		       COALESCE(f.size, 0) AS size,
+		       COALESCE(f.blocks, 0) AS blocks,
+		       COALESCE(f.mtime, 0) AS mtime,
+		       COALESCE(f.size, r.total_size, 0) AS total_size,
+		       COALESCE(f.blocks, r.total_blocks, 0) AS total_blocks,
+		       CASE WHEN f.id IS NULL THEN COALESCE(r.total_files, 0) ELSE 1 END AS total_files,
+		       CASE WHEN f.id IS NULL THEN COALESCE(r.total_dirs, 0) ELSE 0 END AS total_dirs
+		FROM dirs d
+		LEFT JOIN rollups r ON r.dir_id = d.id
+		LEFT JOIN entries f ON %[1]s = %[2]d AND f.parent_id = d.parent_id AND f.name = d.name AND f.kind = %[3]d`,
+		dirKind(db, "d"), entry.KindArchive, entry.KindFile)
+}
+
+// listedEntry is a condition on the entries row aliased as alias that
+// leaves out archive files whose descended archive is listed instead.
+func listedEntry(db *sql.DB, alias string) string {
+	if !hasColumn(db, "dirs", "kind") {
+		return "1"
+	}
+	return fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM dirs a WHERE a.parent_id = %[1]s.parent_id AND a.name = %[1]s.name AND a.kind = %[2]d)`,
+		alias, entry.KindArchive)
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// migration upgrades a snapshot from version to-1 to version to.
+type migration struct {
+	to    int
+	apply func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{to: 1, apply: migrateTo1},
+	{to: 2, apply: migrateTo2},
+}
+
+// Migrate upgrades the snapshot in place to SchemaVersion, one transaction
+// per version, and returns the version it started from. A failed step
+// leaves the snapshot at the last version that completed.
+func Migrate(db *sql.DB) (int, error) {
+	from, err := Version(db)
+	if err != nil {
+		return 0, err
+	}
+	if from > SchemaVersion {
+		return from, fmt.Errorf("snapshot schema v%d is newer than this dug (v%d); upgrade dug instead", from, SchemaVersion)
+	}
+
+	for _, m := range migrations {
+		current, err := Version(db)
+		if err != nil {
+			return from, err
+		}
+		if current >= m.to {
+			continue
+		}
+		if err := runMigration(db, m, from); err != nil {
+			return from, fmt.Errorf("failed to migrate to schema v%d: %w", m.to, err)
+		}
+	}
+
+	// Readers cache what they learned about the old layout
+	forgetSchema(db)
+	return from, nil
+}
+
+func runMigration(db *sql.DB, m migration, from int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.apply(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.to)); err != nil {
+		return err
+	}
+	for key, value := range map[string]string{
+		InfoSchemaVersion: strconv.Itoa(m.to),
+		InfoMigratedFrom:  strconv.Itoa(from),
+		InfoMigratedAt:    strconv.FormatInt(time.Now().Unix(), 10),
+	} {
+		if err := setInfo(tx, key, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// unversionedColumns were added to existing tables before schema
+// versioning, so unversioned snapshots may lack any of them.
+var unversionedColumns = []struct {
+	table, column, def string
+}{
+	{"dirs", "timed_out", "INTEGER NOT NULL DEFAULT 0"},
+	{"dirs", "kind", "INTEGER NOT NULL DEFAULT 1"},
+	{"scan_errors", "timed_out", "INTEGER NOT NULL DEFAULT 0"},
+	{"scan_meta", "memory_limit", "INTEGER DEFAULT 0"},
+	{"scan_meta", "peak_rss", "INTEGER DEFAULT 0"},
+	{"scan_meta", "shard_index", "INTEGER DEFAULT 0"},
+	{"scan_meta", "shard_count", "INTEGER DEFAULT 0"},
+	{"rollups", "total_slack", "INTEGER NOT NULL DEFAULT 0"},
+	{"rollups", "total_sparse", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// migrateTo1 brings an unversioned snapshot up to the first versioned
+// layout: missing tables and columns are added, and slack and sparse
+// rollups are computed from the entries.
+func migrateTo1(tx *sql.Tx) error {
+	for _, ddl := range tableDDLs {
+		if _, err := tx.Exec(ddl); err != nil {
+			return fmt.Errorf("failed to create table: %w", err)
+		}
+	}
+
+	backfillSlack := false
+	for _, c := range unversionedColumns {
+		ok, err := txHasColumn(tx, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.def)); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", c.table, c.column, err)
+		}
+		if c.table == "rollups" {
+			backfillSlack = true
+		}
+	}
+
+	if backfillSlack {
+		return backfillEfficiency(tx)
+	}
+	return nil
+}
+
+// backfillEfficiency computes total_slack and total_sparse for every
+// rollup: each directory's own files first, then children are added to
+// parents from the deepest level up.
+func backfillEfficiency(tx *sql.Tx) error {
+	for _, ddl := range []string{dirsParentIndexDDL, entriesParentIndexDDL} {
+		if _, err := tx.Exec(ddl); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	_, err := tx.Exec(`
+		UPDATE rollups SET
+			total_slack = COALESCE((SELECT SUM(MAX(blocks - size, 0)) FROM entries WHERE parent_id = rollups.dir_id AND kind = 0), 0),
+			total_sparse = COALESCE((SELECT SUM(MAX(size - blocks, 0)) FROM entries WHERE parent_id = rollups.dir_id AND kind = 0), 0)`)
+	if err != nil {
+		return fmt.Errorf("failed to compute directory slack: %w", err)
+	}
+
+	var maxDepth int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(depth), 0) FROM dirs`).Scan(&maxDepth); err != nil {
+		return err
+	}
+	for depth := maxDepth - 1; depth >= 0; depth-- {
+		_, err := tx.Exec(`
+			UPDATE rollups SET
+				total_slack = total_slack + COALESCE((SELECT SUM(r.total_slack) FROM dirs d JOIN rollups r ON r.dir_id = d.id WHERE d.parent_id = rollups.dir_id), 0),
+				total_sparse = total_sparse + COALESCE((SELECT SUM(r.total_sparse) FROM dirs d JOIN rollups r ON r.dir_id = d.id WHERE d.parent_id = rollups.dir_id), 0)
+			WHERE dir_id IN (SELECT id FROM dirs WHERE depth = ?)`, depth)
+		if err != nil {
+			return fmt.Errorf("failed to roll up slack: %w", err)
+		}
+	}
+	return nil
+}
+
+// migrateTo2 rebuilds dirs without the path column. Directories without a
+// parent keep their path as their name; everything else already has the
+// name its path ends with. Run VACUUM afterwards to return the space.
+func migrateTo2(tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE dirs RENAME TO dirs_v1`,
+		dirsTableDDL,
+		`INSERT INTO dirs (id, name, parent_id, depth, timed_out, kind)
+		 SELECT id, CASE WHEN COALESCE(parent_id, 0) = 0 THEN path ELSE name END,
+		        COALESCE(parent_id, 0), depth, timed_out, kind
+		 FROM dirs_v1`,
+		`DROP TABLE dirs_v1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to rebuild dirs: %w", err)
+		}
+	}
+	return nil
+}
+
+func txHasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	var n int
+	err := tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = ?`, table), column).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	return n > 0, nil
+}
+
+// Forget drops everything cached about db. Call it when closing a
+// database that long-running processes will not open again.
+func Forget(db *sql.DB) {
+	forgetSchema(db)
+}
+
+// forgetSchema drops cached column, version and path lookups for db.
+func forgetSchema(db *sql.DB) {
+	dbColumns.Range(func(k, _ any) bool {
+		if k.(columnKey).db == db {
+			dbColumns.Delete(k)
+		}
+		return true
+	})
+	dbSchemaChecks.Delete(db)
+	dbDirCaches.Delete(db)
+	dbPathCaches.Delete(db)
+}
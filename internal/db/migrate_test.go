@@ -0,0 +1,84 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMigrateUnversionedSnapshot(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	// The layout written before schema versioning: no efficiency rollups,
+	// no dir kinds, no schema_info.
+	for _, ddl := range []string{
+		`CREATE TABLE dirs (id INTEGER PRIMARY KEY, path TEXT UNIQUE NOT NULL, name TEXT NOT NULL, parent_id INTEGER, depth INTEGER NOT NULL)`,
+		`CREATE TABLE entries (id INTEGER PRIMARY KEY, parent_id INTEGER NOT NULL, name TEXT NOT NULL, kind INTEGER NOT NULL, size INTEGER NOT NULL, blocks INTEGER NOT NULL, mtime INTEGER NOT NULL, dev_id INTEGER NOT NULL, inode INTEGER NOT NULL)`,
+		`CREATE TABLE rollups (dir_id INTEGER PRIMARY KEY, total_size INTEGER NOT NULL, total_blocks INTEGER NOT NULL, total_files INTEGER NOT NULL, total_dirs INTEGER NOT NULL)`,
+		`CREATE TABLE scan_meta (id INTEGER PRIMARY KEY CHECK (id = 1), root_path TEXT NOT NULL, start_time INTEGER NOT NULL, end_time INTEGER, total_size INTEGER DEFAULT 0, total_blocks INTEGER DEFAULT 0, file_count INTEGER DEFAULT 0, dir_count INTEGER DEFAULT 0, error_count INTEGER DEFAULT 0)`,
+		`INSERT INTO dirs VALUES (1, '/data', 'data', 0, 0), (2, '/data/tiny', 'tiny', 1, 1)`,
+		`INSERT INTO entries (parent_id, name, kind, size, blocks, mtime, dev_id, inode) VALUES
+			(1, 'vm.img', 0, 10000, 4096, 0, 1, 1),
+			(2, 'a', 0, 10, 4096, 0, 1, 2),
+			(2, 'b', 0, 20, 4096, 0, 1, 3)`,
+		`INSERT INTO rollups VALUES (1, 10030, 12288, 3, 1), (2, 30, 8192, 2, 0)`,
+		`INSERT INTO scan_meta (id, root_path, start_time) VALUES (1, '/data', 0)`,
+	} {
+		if _, err := database.Exec(ddl); err != nil {
+			t.Fatalf("build old snapshot: %v", err)
+		}
+	}
+
+	if err := CheckSchema(database); err != nil {
+		t.Fatalf("unversioned snapshot should be readable: %v", err)
+	}
+
+	from, err := Migrate(database)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if from != 0 {
+		t.Fatalf("migrated from v%d, want v0", from)
+	}
+	if v, _ := Version(database); v != SchemaVersion {
+		t.Fatalf("version after migrate = %d, want %d", v, SchemaVersion)
+	}
+
+	root, err := GetRollup(database, "/data")
+	if err != nil {
+		t.Fatalf("get rollup: %v", err)
+	}
+	if root.TotalSlack != 4086+4076 || root.TotalSparse != 10000-4096 {
+		t.Fatalf("root slack=%d sparse=%d after backfill", root.TotalSlack, root.TotalSparse)
+	}
+
+	if hasColumn(database, "dirs", "path") {
+		t.Fatalf("dirs.path survived the migration")
+	}
+	if parent, err := ParentPath(database, "/data/tiny"); err != nil || parent != "/data" {
+		t.Fatalf("parent of /data/tiny = %q, %v; want /data", parent, err)
+	}
+
+	if from, err := Migrate(database); err != nil || from != SchemaVersion {
+		t.Fatalf("second migrate = v%d, %v; want no-op", from, err)
+	}
+
+	// A snapshot from a future dug that older readers cannot understand
+	if _, err := database.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion+1)); err != nil {
+		t.Fatalf("bump version: %v", err)
+	}
+	if err := SetSchemaInfo(database, InfoMinReader, fmt.Sprint(SchemaVersion+1)); err != nil {
+		t.Fatalf("set min reader: %v", err)
+	}
+	forgetSchema(database)
+	var tooNew *ErrSchemaTooNew
+	if err := CheckSchema(database); !errors.As(err, &tooNew) {
+		t.Fatalf("CheckSchema = %v, want ErrSchemaTooNew", err)
+	}
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+
+	"github.com/michaelscutari/dug/internal/pathutil"
+)
+
+// Directories store only their name and parent: a directory's path is its
+// parent's path joined with its name. A directory without a parent holds
+// its absolute path as its name, and the roots under a multi-root
+// snapshot's synthetic top are named by their path relative to it, so a
+// name may span several path components.
+//
+// Snapshots before schema v2 also store the full path in dirs.path, which
+// is used directly when present.
+
+// hasDirPaths reports whether the snapshot stores full paths in dirs.path.
+func hasDirPaths(db *sql.DB) bool {
+	return hasColumn(db, "dirs", "path")
+}
+
+// lookupDir returns the ID of the directory at path, or sql.ErrNoRows.
+func lookupDir(db *sql.DB, path string) (int64, error) {
+	return lookupDirContext(context.Background(), db, path)
+}
+
+func lookupDirContext(ctx context.Context, db *sql.DB, path string) (int64, error) {
+	path = pathutil.Normalize(path)
+	cache := getDirCache(db)
+	if id, ok := cache.Get(path); ok {
+		return id, nil
+	}
+
+	var id int64
+	var err error
+	if hasDirPaths(db) {
+		err = db.QueryRowContext(ctx, `SELECT id FROM dirs WHERE path = ?`, path).Scan(&id)
+	} else {
+		id, err = walkDir(ctx, db, path)
+	}
+	if err != nil {
+		return 0, err
+	}
+	cache.Set(path, id)
+	return id, nil
+}
+
+// walkDir resolves path one name at a time, starting from its nearest
+// cached ancestor or else from the root directory. Every directory passed
+// on the way is cached, so siblings resolve with a single query.
+func walkDir(ctx context.Context, db *sql.DB, path string) (int64, error) {
+	cache := getDirCache(db)
+
+	var id int64
+	base, found := filepath.Dir(path), false
+	for {
+		if cached, ok := cache.Get(base); ok {
+			id, found = cached, true
+			break
+		}
+		parent := filepath.Dir(base)
+		if parent == base {
+			break
+		}
+		base = parent
+	}
+	if !found {
+		if err := db.QueryRowContext(ctx, `SELECT id, name FROM dirs WHERE parent_id = 0 ORDER BY id LIMIT 1`).Scan(&id, &base); err != nil {
+			return 0, err
+		}
+		if !pathutil.IsWithin(path, base) {
+			return 0, sql.ErrNoRows
+		}
+		cache.Set(base, id)
+	}
+
+	rest := strings.TrimPrefix(path[len(base):], "/")
+	for rest != "" {
+		name, _, _ := strings.Cut(rest, "/")
+		var child int64
+		err := db.QueryRowContext(ctx, `SELECT id FROM dirs WHERE parent_id = ? AND name = ?`, id, name).Scan(&child)
+		if err == sql.ErrNoRows {
+			name, child, err = multiNameChild(ctx, db, id, rest)
+		}
+		if err != nil {
+			return 0, err
+		}
+		base, id = filepath.Join(base, name), child
+		rest = strings.TrimPrefix(rest[len(name):], "/")
+		cache.Set(base, id)
+	}
+	return id, nil
+}
+
+// multiNameChild finds the child of parent whose multi-component name
+// (a root under a synthetic top) is the longest leading part of rest.
+func multiNameChild(ctx context.Context, db *sql.DB, parent int64, rest string) (string, int64, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, name FROM dirs WHERE parent_id = ? AND instr(name, '/') > 0`, parent)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rows.Close()
+
+	var best string
+	var bestID int64
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return "", 0, err
+		}
+		if len(name) > len(best) && pathutil.IsWithin(rest, name) {
+			best, bestID = name, id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", 0, err
+	}
+	if best == "" {
+		return "", 0, sql.ErrNoRows
+	}
+	return best, bestID, nil
+}
+
+// DirPath returns the absolute path of the directory with the given ID,
+// climbing parents until it reaches the root or a cached ancestor.
+func DirPath(db *sql.DB, id int64) (string, error) {
+	cache := getPathCache(db)
+	if path, ok := cache.Get(id); ok {
+		return path, nil
+	}
+
+	if hasDirPaths(db) {
+		var path string
+		if err := db.QueryRow(`SELECT path FROM dirs WHERE id = ?`, id).Scan(&path); err != nil {
+			return "", err
+		}
+		cache.Set(id, path)
+		return path, nil
+	}
+
+	var names []string
+	var ids []int64
+	var path string
+	for cur := id; ; {
+		if cached, ok := cache.Get(cur); ok {
+			path = cached
+			break
+		}
+		var name string
+		var parent int64
+		if err := db.QueryRow(`SELECT name, parent_id FROM dirs WHERE id = ?`, cur).Scan(&name, &parent); err != nil {
+			return "", err
+		}
+		names = append(names, name)
+		ids = append(ids, cur)
+		if parent == 0 {
+			break
+		}
+		cur = parent
+	}
+
+	dirs := getDirCache(db)
+	for i := len(names) - 1; i >= 0; i-- {
+		if path == "" {
+			path = names[i]
+		} else {
+			path = filepath.Join(path, names[i])
+		}
+		cache.Set(ids[i], path)
+		dirs.Set(path, ids[i])
+	}
+	return path, nil
+}
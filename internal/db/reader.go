@@ -1,8 +1,10 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/michaelscutari/dug/internal/entry"
@@ -21,62 +23,130 @@ type DisplayEntry struct {
 	TotalBlocks int64 // Disk usage (rollup)
 	TotalFiles  int64
 	TotalDirs   int64
+	Key         int64 // position key for Cursor; set only by the LoadChildren family
 }
 
 // LoadChildren loads child entries for a directory with rollup data.
 func LoadChildren(db *sql.DB, parentPath, sortBy string, limit int) ([]DisplayEntry, error) {
+	return loadChildren(context.Background(), db, parentPath, sortBy, Cursor{}, 0, limit)
+}
+
+// LoadChildrenPage loads limit child entries of a directory starting at
+// offset in sort order. Ties sort by name so pages never overlap.
+func LoadChildrenPage(db *sql.DB, parentPath, sortBy string, offset, limit int) ([]DisplayEntry, error) {
+	return LoadChildrenPageContext(context.Background(), db, parentPath, sortBy, offset, limit)
+}
+
+// LoadChildrenPageContext is LoadChildrenPage with a context that cancels
+// its queries.
+func LoadChildrenPageContext(ctx context.Context, db *sql.DB, parentPath, sortBy string, offset, limit int) ([]DisplayEntry, error) {
+	return loadChildren(ctx, db, parentPath, sortBy, Cursor{}, offset, limit)
+}
+
+// LoadChildrenAfter loads limit child entries of a directory that sort
+// after the cursor, which must have been taken in the same order. Passing
+// the last entry's Cursor pages through a listing of any size.
+func LoadChildrenAfter(db *sql.DB, parentPath, sortBy string, after Cursor, limit int) ([]DisplayEntry, error) {
+	return LoadChildrenAfterContext(context.Background(), db, parentPath, sortBy, after, limit)
+}
+
+// LoadChildrenAfterContext is LoadChildrenAfter with a context that cancels
+// its queries.
+func LoadChildrenAfterContext(ctx context.Context, db *sql.DB, parentPath, sortBy string, after Cursor, limit int) ([]DisplayEntry, error) {
+	if order := childOrderFor(sortBy); !after.IsZero() && after.Sort != order.name {
+		return nil, fmt.Errorf("cursor is for sort %q, not %q", after.Sort, order.name)
+	}
+	return loadChildren(ctx, db, parentPath, sortBy, after, 0, limit)
+}
+
+func loadChildren(ctx context.Context, db *sql.DB, parentPath, sortBy string, after Cursor, offset, limit int) ([]DisplayEntry, error) {
+	if err := CheckSchema(db); err != nil {
+		return nil, err
+	}
 	parentPath = pathutil.Normalize(parentPath)
-	orderClause := "total_size DESC"
-	switch sortBy {
-	case "name":
-		orderClause = "name ASC"
-	case "files":
-		orderClause = "total_files DESC"
-	case "size":
-		orderClause = "total_size DESC"
-	case "blocks", "disk":
-		orderClause = "total_blocks DESC"
-	}
-
-	query := fmt.Sprintf(`
-		SELECT d.path, d.name, ? as kind, 0 as size, 0 as blocks, 0 as mtime,
-		       COALESCE(r.total_size, 0) as total_size,
-		       COALESCE(r.total_blocks, 0) as total_blocks,
-		       COALESCE(r.total_files, 0) as total_files,
-		       COALESCE(r.total_dirs, 0) as total_dirs
-		FROM dirs d
-		LEFT JOIN rollups r ON r.dir_id = d.id
-		WHERE d.parent_id = ?
-
-		UNION ALL
-
-		SELECT (pd.path || '/' || e.name) as path, e.name, e.kind, e.size, e.blocks, e.mtime,
-		       e.size as total_size,
-		       e.blocks as total_blocks,
-		       CASE WHEN e.kind = 0 THEN 1 ELSE 0 END as total_files,
-		       0 as total_dirs
-		FROM entries e
-		JOIN dirs pd ON pd.id = e.parent_id
-		WHERE e.parent_id = ?
-		ORDER BY %s
-		LIMIT ?
-	`, orderClause)
-
-	var parentID int64
-	cache := getDirCache(db)
-	if cache != nil {
-		if cachedID, ok := cache.Get(parentPath); ok {
-			parentID = cachedID
-		} else if err := db.QueryRow(`SELECT id FROM dirs WHERE path = ?`, parentPath).Scan(&parentID); err != nil {
-			return nil, fmt.Errorf("parent not found: %w", err)
-		} else {
-			cache.Set(parentPath, parentID)
-		}
-	} else if err := db.QueryRow(`SELECT id FROM dirs WHERE path = ?`, parentPath).Scan(&parentID); err != nil {
+	order := childOrderFor(sortBy)
+
+	parentID, err := lookupDirContext(ctx, db, parentPath)
+	if err != nil {
 		return nil, fmt.Errorf("parent not found: %w", err)
 	}
 
-	rows, err := db.Query(query, entry.KindDir, parentID, parentID, limit)
+	// Directories and files are paged separately, each in index order, and
+	// the two pages merged, so the listing is never sorted as a whole. A
+	// page at offset needs that many more rows of each.
+	n := offset + limit
+	if limit < 0 {
+		n = -1
+	}
+	var pages [][]DisplayEntry
+	for _, q := range childQueries(db, parentID, order, after, n) {
+		page, err := queryChildren(ctx, db, q)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	entries := mergeChildren(order, pages[0], pages[1])
+
+	if offset >= len(entries) {
+		return nil, nil
+	}
+	entries = entries[offset:]
+	if limit >= 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+	for i := range entries {
+		entries[i].Path = filepath.Join(parentPath, entries[i].Name)
+	}
+	return entries, nil
+}
+
+// childQuery is a query for one page of a directory's children.
+type childQuery struct {
+	query string
+	args  []any
+}
+
+// childQueries returns the queries for the first limit subdirectories and
+// the first limit files of the directory parentID that sort after the
+// cursor. Directories are keyed by their negated ID, since the two ID
+// ranges overlap.
+func childQueries(db *sql.DB, parentID int64, order childOrder, after Cursor, limit int) []childQuery {
+	dirs := "dir_listing"
+	if !hasTable(db, "dir_listing") {
+		dirs = "(" + dirListing(db) + ")"
+	}
+
+	where := "1"
+	var whereArgs []any
+	if !after.IsZero() {
+		where, whereArgs = order.after(after)
+	}
+	args := func() []any {
+		return append(append([]any{parentID}, whereArgs...), limit)
+	}
+
+	return []childQuery{
+		{fmt.Sprintf(`
+			SELECT -dir_id AS key, name, kind, size, blocks, mtime, total_size, total_blocks, total_files, total_dirs
+			FROM %s
+			WHERE parent_id = ? AND %s
+			ORDER BY %s
+			LIMIT ?
+		`, dirs, where, order.orderBy("dir_id DESC")), args()},
+		{fmt.Sprintf(`
+			SELECT id AS key, name, kind, size, blocks, mtime,
+			       size AS total_size, blocks AS total_blocks, %s AS total_files, 0 AS total_dirs
+			FROM entries
+			WHERE parent_id = ? AND %s AND %s
+			ORDER BY %s
+			LIMIT ?
+		`, entryFilesExpr, listedEntry(db, "entries"), where, order.orderBy("key")), args()},
+	}
+}
+
+func queryChildren(ctx context.Context, db *sql.DB, q childQuery) ([]DisplayEntry, error) {
+	rows, err := db.QueryContext(ctx, q.query, q.args...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -86,45 +156,73 @@ func LoadChildren(db *sql.DB, parentPath, sortBy string, limit int) ([]DisplayEn
 	for rows.Next() {
 		var e DisplayEntry
 		var mtime int64
-		if err := rows.Scan(&e.Path, &e.Name, &e.Kind, &e.Size, &e.Blocks, &mtime, &e.TotalSize, &e.TotalBlocks, &e.TotalFiles, &e.TotalDirs); err != nil {
+		if err := rows.Scan(&e.Key, &e.Name, &e.Kind, &e.Size, &e.Blocks, &mtime, &e.TotalSize, &e.TotalBlocks, &e.TotalFiles, &e.TotalDirs); err != nil {
 			return nil, fmt.Errorf("scan failed: %w", err)
 		}
 		e.ModTime = time.Unix(mtime, 0)
 		entries = append(entries, e)
 	}
-
 	return entries, rows.Err()
 }
 
-// GetRollup retrieves rollup data for a specific path.
-func GetRollup(db *sql.DB, path string) (*entry.Rollup, error) {
-	path = pathutil.Normalize(path)
-	var r entry.Rollup
-	var dirID int64
-	cache := getDirCache(db)
-	if cache != nil {
-		if cachedID, ok := cache.Get(path); ok {
-			dirID = cachedID
-		} else if err := db.QueryRow(`SELECT id FROM dirs WHERE path = ?`, path).Scan(&dirID); err != nil {
-			if err == sql.ErrNoRows {
-				return nil, nil
-			}
-			return nil, err
+// mergeChildren merges two listings sorted in order into one.
+func mergeChildren(order childOrder, a, b []DisplayEntry) []DisplayEntry {
+	merged := make([]DisplayEntry, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if order.less(b[0], a[0]) {
+			merged, b = append(merged, b[0]), b[1:]
 		} else {
-			cache.Set(path, dirID)
-		}
-	} else if err := db.QueryRow(`SELECT id FROM dirs WHERE path = ?`, path).Scan(&dirID); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+			merged, a = append(merged, a[0]), a[1:]
 		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
+
+// CountChildren returns how many entries the directory at path holds
+// directly.
+func CountChildren(db *sql.DB, path string) (int64, error) {
+	if err := CheckSchema(db); err != nil {
+		return 0, err
+	}
+	id, err := lookupDir(db, path)
+	if err != nil {
+		return 0, fmt.Errorf("parent not found: %w", err)
+	}
+	var n int64
+	err = db.QueryRow(`SELECT (SELECT COUNT(*) FROM dirs WHERE parent_id = ?) + (SELECT COUNT(*) FROM entries WHERE parent_id = ? AND `+listedEntry(db, "entries")+`)`,
+		id, id).Scan(&n)
+	return n, err
+}
+
+// GetRollup retrieves rollup data for a specific path.
+func GetRollup(db *sql.DB, path string) (*entry.Rollup, error) {
+	return GetRollupContext(context.Background(), db, path)
+}
+
+// GetRollupContext is GetRollup with a context that cancels its queries.
+func GetRollupContext(ctx context.Context, db *sql.DB, path string) (*entry.Rollup, error) {
+	if err := CheckSchema(db); err != nil {
 		return nil, err
 	}
-	r.DirID = dirID
+	dirID, err := lookupDirContext(ctx, db, path)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := entry.Rollup{DirID: dirID}
 
-	err := db.QueryRow(`
-		SELECT total_size, total_blocks, total_files, total_dirs
+	// Snapshots from before efficiency tracking have no slack columns
+	efficiency := "0, 0"
+	if hasColumn(db, "rollups", "total_slack") {
+		efficiency = "total_slack, total_sparse"
+	}
+	err = db.QueryRowContext(ctx, `
+		SELECT total_size, total_blocks, total_files, total_dirs, `+efficiency+`
 		FROM rollups WHERE dir_id = ?
-	`, dirID).Scan(&r.TotalSize, &r.TotalBlocks, &r.TotalFiles, &r.TotalDirs)
+	`, dirID).Scan(&r.TotalSize, &r.TotalBlocks, &r.TotalFiles, &r.TotalDirs, &r.TotalSlack, &r.TotalSparse)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -136,12 +234,44 @@ func GetRollup(db *sql.DB, path string) (*entry.Rollup, error) {
 	return &r, nil
 }
 
+// ParentPath returns the path of the directory holding path, following
+// parent_id rather than trimming the path. The two differ for roots under a
+// multi-root snapshot's synthetic top. Returns "" when path has no parent.
+func ParentPath(db *sql.DB, path string) (string, error) {
+	if err := CheckSchema(db); err != nil {
+		return "", err
+	}
+	id, err := lookupDir(db, path)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var parent int64
+	if err := db.QueryRow(`SELECT COALESCE(parent_id, 0) FROM dirs WHERE id = ?`, id).Scan(&parent); err != nil {
+		return "", err
+	}
+	if parent == 0 {
+		return "", nil
+	}
+	return DirPath(db, parent)
+}
+
 // GetScanMeta retrieves scan metadata.
 func GetScanMeta(db *sql.DB) (*entry.ScanMeta, error) {
+	return GetScanMetaContext(context.Background(), db)
+}
+
+// GetScanMetaContext is GetScanMeta with a context that cancels its query.
+func GetScanMetaContext(ctx context.Context, db *sql.DB) (*entry.ScanMeta, error) {
+	if err := CheckSchema(db); err != nil {
+		return nil, err
+	}
 	var m entry.ScanMeta
 	var startTime, endTime int64
 
-	err := db.QueryRow(`
+	err := db.QueryRowContext(ctx, `
 		SELECT root_path, start_time, COALESCE(end_time, 0), total_size, total_blocks, file_count, dir_count, error_count
 		FROM scan_meta WHERE id = 1
 	`).Scan(&m.RootPath, &startTime, &endTime, &m.TotalSize, &m.TotalBlocks, &m.FileCount, &m.DirCount, &m.ErrorCount)
@@ -157,3 +287,158 @@ func GetScanMeta(db *sql.DB) (*entry.ScanMeta, error) {
 
 	return &m, nil
 }
+
+// ScanRoot holds the totals of one root of a multi-root or merged snapshot.
+type ScanRoot struct {
+	Path   string
+	Size   int64
+	Blocks int64
+	Files  int64
+	Dirs   int64
+}
+
+// LoadScanRoots returns the snapshot's roots by path. Snapshots from
+// before multi-root scans have none.
+func LoadScanRoots(db *sql.DB) ([]ScanRoot, error) {
+	return LoadScanRootsContext(context.Background(), db)
+}
+
+// LoadScanRootsContext is LoadScanRoots with a context that cancels its
+// query.
+func LoadScanRootsContext(ctx context.Context, db *sql.DB) ([]ScanRoot, error) {
+	if err := CheckSchema(db); err != nil {
+		return nil, err
+	}
+	if !hasTable(db, "scan_roots") {
+		return nil, nil
+	}
+	rows, err := db.QueryContext(ctx, `SELECT path, total_size, total_blocks, file_count, dir_count FROM scan_roots ORDER BY path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roots []ScanRoot
+	for rows.Next() {
+		var r ScanRoot
+		if err := rows.Scan(&r.Path, &r.Size, &r.Blocks, &r.Files, &r.Dirs); err != nil {
+			return nil, err
+		}
+		roots = append(roots, r)
+	}
+	return roots, rows.Err()
+}
+
+// LoadErrors returns limit sampled scan errors starting at offset, in the
+// order they were recorded.
+func LoadErrors(db *sql.DB, offset, limit int) ([]entry.ScanError, error) {
+	return LoadErrorsContext(context.Background(), db, offset, limit)
+}
+
+// LoadErrorsContext is LoadErrors with a context that cancels its query.
+func LoadErrorsContext(ctx context.Context, db *sql.DB, offset, limit int) ([]entry.ScanError, error) {
+	if err := CheckSchema(db); err != nil {
+		return nil, err
+	}
+	// Snapshots from before readdir timeouts have no timed_out column
+	timedOut := "0"
+	if hasColumn(db, "scan_errors", "timed_out") {
+		timedOut = "timed_out"
+	}
+	rows, err := db.QueryContext(ctx, `SELECT path, message, `+timedOut+` FROM scan_errors ORDER BY id LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var errs []entry.ScanError
+	for rows.Next() {
+		var e entry.ScanError
+		if err := rows.Scan(&e.Path, &e.Message, &e.TimedOut); err != nil {
+			return nil, err
+		}
+		errs = append(errs, e)
+	}
+	return errs, rows.Err()
+}
+
+// DirEfficiency summarizes how well the files directly in a directory use
+// their disk blocks.
+type DirEfficiency struct {
+	Path   string
+	Files  int64
+	Size   int64 // Apparent size
+	Blocks int64 // Disk usage
+	Slack  int64 // Disk usage beyond apparent size
+	Sparse int64 // Apparent size not backed by disk
+}
+
+// Efficiency classifies the directory's files as a whole.
+func (d DirEfficiency) Efficiency() entry.Efficiency {
+	return entry.Classify(d.Size, d.Blocks)
+}
+
+// LoadEfficiency ranks the directories under path by the slack of the files
+// directly inside them, or by sparse savings when sortBy is "sparse". Only
+// a directory's own files count, so a folder of tiny files ranks above the
+// ancestors that merely contain it.
+func LoadEfficiency(db *sql.DB, path, sortBy string, limit int) ([]DirEfficiency, error) {
+	if err := CheckSchema(db); err != nil {
+		return nil, err
+	}
+	dirID, err := lookupDir(db, path)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	order := "slack DESC"
+	if sortBy == "sparse" {
+		order = "sparse DESC"
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`
+		WITH RECURSIVE tree(id) AS (
+			SELECT ?
+			UNION ALL
+			SELECT d.id FROM dirs d JOIN tree t ON d.parent_id = t.id
+		)
+		SELECT e.parent_id, COUNT(*), SUM(e.size), SUM(e.blocks),
+		       SUM(MAX(e.blocks - e.size, 0)) AS slack,
+		       SUM(MAX(e.size - e.blocks, 0)) AS sparse
+		FROM entries e
+		WHERE e.kind = ? AND e.parent_id IN (SELECT id FROM tree)
+		GROUP BY e.parent_id
+		ORDER BY %s, e.parent_id
+		LIMIT ?
+	`, order), dirID, entry.KindFile, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dirs []DirEfficiency
+	var ids []int64
+	for rows.Next() {
+		var d DirEfficiency
+		var id int64
+		if err := rows.Scan(&id, &d.Files, &d.Size, &d.Blocks, &d.Slack, &d.Sparse); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i, id := range ids {
+		if dirs[i].Path, err = DirPath(db, id); err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
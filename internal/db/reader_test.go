@@ -1,8 +1,12 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/michaelscutari/dug/internal/entry"
@@ -22,10 +26,13 @@ func TestLoadChildrenSortsFilesAndDirsBySize(t *testing.T) {
 	}
 
 	insertDir := func(id int64, path string, parentID int64, depth int) {
-		name := filepath.Base(path)
+		name := path
+		if parentID != 0 {
+			name = filepath.Base(path)
+		}
 		_, err := database.Exec(
-			`INSERT INTO dirs (id, path, name, parent_id, depth) VALUES (?, ?, ?, ?, ?)`,
-			id, path, name, parentID, depth,
+			`INSERT INTO dirs (id, name, parent_id, depth) VALUES (?, ?, ?, ?)`,
+			id, name, parentID, depth,
 		)
 		if err != nil {
 			t.Fatalf("insert %s: %v", path, err)
@@ -68,3 +75,333 @@ func TestLoadChildrenSortsFilesAndDirsBySize(t *testing.T) {
 		t.Fatalf("expected largest item first, got %s", children[0].Name)
 	}
 }
+
+func TestLoadEfficiencyRanksOwnFiles(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+
+	if err := InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+
+	dirs := []struct {
+		id, parent int64
+		path       string
+	}{
+		{1, 0, "/data"},
+		{2, 1, "/data/tiny"},
+		{3, 1, "/data/vm"},
+		{4, 0, "/database"}, // shares a prefix with /data but lies outside it
+	}
+	for _, d := range dirs {
+		name := d.path
+		if d.parent != 0 {
+			name = filepath.Base(d.path)
+		}
+		if _, err := database.Exec(`INSERT INTO dirs (id, name, parent_id, depth) VALUES (?, ?, ?, 0)`,
+			d.id, name, d.parent); err != nil {
+			t.Fatalf("insert %s: %v", d.path, err)
+		}
+	}
+	files := []struct {
+		parent       int64
+		size, blocks int64
+	}{
+		{1, 4096, 4096},
+		{2, 10, 4096}, {2, 20, 4096}, {2, 30, 4096},
+		{3, 1 << 30, 1 << 20},
+		{4, 1, 1 << 20},
+	}
+	for i, f := range files {
+		if _, err := database.Exec(`INSERT INTO entries (parent_id, name, kind, size, blocks, mtime, dev_id, inode) VALUES (?, ?, ?, ?, ?, 0, 0, 0)`,
+			f.parent, fmt.Sprintf("f%d", i), entry.KindFile, f.size, f.blocks); err != nil {
+			t.Fatalf("insert entry: %v", err)
+		}
+	}
+
+	bySlack, err := LoadEfficiency(database, "/data", "slack", 10)
+	if err != nil {
+		t.Fatalf("load efficiency: %v", err)
+	}
+	if len(bySlack) != 3 || bySlack[0].Path != "/data/tiny" || bySlack[0].Slack != 3*4096-60 {
+		t.Fatalf("expected /data/tiny first with its slack, got %+v", bySlack)
+	}
+	if bySlack[0].Efficiency() != entry.EfficiencyOverhead {
+		t.Fatalf("expected overhead, got %v", bySlack[0].Efficiency())
+	}
+
+	bySparse, err := LoadEfficiency(database, "/data", "sparse", 1)
+	if err != nil {
+		t.Fatalf("load efficiency: %v", err)
+	}
+	if len(bySparse) != 1 || bySparse[0].Path != "/data/vm" || bySparse[0].Efficiency() != entry.EfficiencySparse {
+		t.Fatalf("expected sparse /data/vm, got %+v", bySparse)
+	}
+}
+
+func TestLoadChildrenAfterPagesWholeListing(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	if _, err := database.Exec(`INSERT INTO dirs (id, name, parent_id, depth) VALUES (1, '/data', 0, 0), (2, 'sub', 1, 1), (3, 'zzz', 1, 1)`); err != nil {
+		t.Fatalf("insert dirs: %v", err)
+	}
+	if _, err := database.Exec(`INSERT INTO rollups (dir_id, total_size, total_blocks, total_files, total_dirs) VALUES (2, 30, 4096, 3, 0), (3, 0, 0, 0, 0)`); err != nil {
+		t.Fatalf("insert rollups: %v", err)
+	}
+	// Many ties on size, including with directory 2, so pages must break
+	// them consistently
+	for i := range 23 {
+		if _, err := database.Exec(`INSERT INTO entries (parent_id, name, kind, size, blocks, mtime, dev_id, inode) VALUES (1, ?, 0, ?, 4096, 0, 1, ?)`,
+			fmt.Sprintf("f%02d", i), (i%3)*15, i); err != nil {
+			t.Fatalf("insert entry: %v", err)
+		}
+	}
+
+	// Page both from dirs and rollups and from the listing indexes
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			if err := BuildIndexes(database); err != nil {
+				t.Fatalf("build indexes: %v", err)
+			}
+		}
+		checkPagedListing(t, database)
+	}
+
+	bySize, err := LoadChildren(database, "/data", "size", 1)
+	if err != nil {
+		t.Fatalf("load children: %v", err)
+	}
+	if _, err := LoadChildrenAfter(database, "/data", "name", bySize[0].Cursor("size"), 4); err == nil {
+		t.Fatalf("expected an error for a cursor from another sort")
+	}
+	if n, err := CountChildren(database, "/data"); err != nil || n != 25 {
+		t.Fatalf("count children = %d, %v; want 25", n, err)
+	}
+}
+
+// checkPagedListing pages through /data in every order and checks the
+// pages add up to the whole listing.
+func checkPagedListing(t *testing.T, database *sql.DB) {
+	t.Helper()
+	for _, sortBy := range []string{"size", "disk", "name", "files"} {
+		all, err := LoadChildren(database, "/data", sortBy, 100)
+		if err != nil {
+			t.Fatalf("load children: %v", err)
+		}
+		if len(all) != 25 {
+			t.Fatalf("%s: got %d children, want 25", sortBy, len(all))
+		}
+
+		var paged []string
+		var after Cursor
+		for {
+			page, err := LoadChildrenAfter(database, "/data", sortBy, after, 4)
+			if err != nil {
+				t.Fatalf("%s: load page: %v", sortBy, err)
+			}
+			for _, e := range page {
+				paged = append(paged, e.Name)
+			}
+			if len(page) < 4 {
+				break
+			}
+			// Cursors survive a round trip through their token
+			if after, err = ParseCursor(page[len(page)-1].Cursor(sortBy).String()); err != nil {
+				t.Fatalf("parse cursor: %v", err)
+			}
+		}
+		var want []string
+		for _, e := range all {
+			want = append(want, e.Name)
+		}
+		if fmt.Sprint(paged) != fmt.Sprint(want) {
+			t.Fatalf("%s: paged %v, want %v", sortBy, paged, want)
+		}
+
+		for offset := 0; offset < len(all); offset += 4 {
+			page, err := LoadChildrenPage(database, "/data", sortBy, offset, 4)
+			if err != nil {
+				t.Fatalf("%s: load page at %d: %v", sortBy, offset, err)
+			}
+			wantPage := all[offset:min(offset+4, len(all))]
+			if fmt.Sprint(page) != fmt.Sprint(wantPage) {
+				t.Fatalf("%s: page at %d is %v, want %v", sortBy, offset, page, wantPage)
+			}
+		}
+	}
+}
+
+func TestChildQueriesUseListingIndexes(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	if _, err := database.Exec(`INSERT INTO dirs (id, name, parent_id, depth) VALUES (1, '/data', 0, 0), (2, 'sub', 1, 1)`); err != nil {
+		t.Fatalf("insert dirs: %v", err)
+	}
+	if err := BuildIndexes(database); err != nil {
+		t.Fatalf("build indexes: %v", err)
+	}
+
+	cursor := Cursor{Value: 10, Name: "m", Key: 5}
+	for _, sortBy := range []string{"size", "disk", "name", "files"} {
+		order := childOrderFor(sortBy)
+		for _, after := range []Cursor{{}, cursor} {
+			for _, q := range childQueries(database, 1, order, after, 100) {
+				rows, err := database.Query("EXPLAIN QUERY PLAN "+q.query, q.args...)
+				if err != nil {
+					t.Fatalf("%s: explain: %v", sortBy, err)
+				}
+				var plan []string
+				for rows.Next() {
+					var id, parent, notUsed int
+					var detail string
+					if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+						t.Fatalf("%s: scan plan: %v", sortBy, err)
+					}
+					plan = append(plan, detail)
+				}
+				rows.Close()
+				if got := strings.Join(plan, "; "); strings.Contains(got, "TEMP B-TREE") || !strings.Contains(got, "USING") {
+					t.Errorf("%s: listing is not read in index order: %s", sortBy, got)
+				}
+			}
+		}
+	}
+}
+
+func TestLoadChildrenListsArchivesOnce(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	stmts := []string{
+		fmt.Sprintf(`INSERT INTO dirs (id, name, parent_id, depth, kind) VALUES (1, '/data', 0, 0, %d), (2, 'a.tar', 1, 1, %d)`, entry.KindDir, entry.KindArchive),
+		fmt.Sprintf(`INSERT INTO entries (parent_id, name, kind, size, blocks, mtime, dev_id, inode) VALUES
+			(1, 'a.tar', %d, 100, 8, 50, 1, 1),
+			(1, 'b.txt', %d, 10, 8, 0, 1, 2),
+			(2, 'member', %d, 900, 0, 0, 0, 0)`, entry.KindFile, entry.KindFile, entry.KindArchiveMember),
+		`INSERT INTO rollups (dir_id, total_size, total_blocks, total_files, total_dirs) VALUES (1, 110, 16, 2, 0), (2, 900, 0, 1, 0)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := database.Exec(stmt); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			if err := BuildIndexes(database); err != nil {
+				t.Fatalf("build indexes: %v", err)
+			}
+		}
+		children, err := LoadChildren(database, "/data", "size", 10)
+		if err != nil {
+			t.Fatalf("load children: %v", err)
+		}
+		if len(children) != 2 {
+			t.Fatalf("indexed=%v: got %d children, want the archive and b.txt: %+v", indexed, len(children), children)
+		}
+		a := children[0]
+		if a.Name != "a.tar" || a.Kind != entry.KindArchive || a.Size != 100 || a.TotalSize != 100 || a.TotalBlocks != 8 || a.TotalFiles != 1 || a.ModTime.Unix() != 50 {
+			t.Fatalf("indexed=%v: archive row %+v, want the file's size, disk usage and mtime", indexed, a)
+		}
+		if n, err := CountChildren(database, "/data"); err != nil || n != 2 {
+			t.Fatalf("indexed=%v: count children = %d, %v; want 2", indexed, n, err)
+		}
+	}
+
+	// Inside, the archive still reports its members' totals
+	if r, err := GetRollup(database, "/data/a.tar"); err != nil || r == nil || r.TotalSize != 900 {
+		t.Fatalf("archive rollup = %+v, %v; want 900 bytes of members", r, err)
+	}
+}
+
+func TestContextVariantsStopOnCancel(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	if _, err := database.Exec(`INSERT INTO dirs (id, name, parent_id, depth) VALUES (1, '/data', 0, 0), (2, 'sub', 1, 1)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := database.Exec(`INSERT INTO rollups (dir_id, total_size, total_blocks, total_files, total_dirs) VALUES (1, 0, 0, 0, 1), (2, 0, 0, 0, 0)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	// Warm the schema check and path cache, so only the queries themselves
+	// can notice the cancellation
+	if _, err := LoadChildren(database, "/data", "size", 10); err != nil {
+		t.Fatalf("load children: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := map[string]func() error{
+		"LoadChildrenPageContext": func() error {
+			_, err := LoadChildrenPageContext(ctx, database, "/data", "size", 0, 10)
+			return err
+		},
+		"LoadChildrenAfterContext": func() error {
+			_, err := LoadChildrenAfterContext(ctx, database, "/data", "size", Cursor{}, 10)
+			return err
+		},
+		"GetRollupContext": func() error {
+			_, err := GetRollupContext(ctx, database, "/data")
+			return err
+		},
+		"GetScanMetaContext": func() error {
+			_, err := GetScanMetaContext(ctx, database)
+			return err
+		},
+		"LoadScanRootsContext": func() error {
+			_, err := LoadScanRootsContext(ctx, database)
+			return err
+		},
+		"LoadErrorsContext": func() error {
+			_, err := LoadErrorsContext(ctx, database, 0, 10)
+			return err
+		},
+		"VersionContext": func() error {
+			_, err := VersionContext(ctx, database)
+			return err
+		},
+		"SchemaInfoContext": func() error {
+			_, err := SchemaInfoContext(ctx, database)
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want context.Canceled", name, err)
+		}
+	}
+}
@@ -3,16 +3,27 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/entry"
 )
 
+// dirsTableDDL stores each directory's name under its parent rather than
+// its full path (see paths.go). The (parent_id, name) constraint is the
+// index for both path lookups and listing children.
 const dirsTableDDL = `
 CREATE TABLE IF NOT EXISTS dirs (
     id INTEGER PRIMARY KEY,
-    path TEXT UNIQUE NOT NULL,
     name TEXT NOT NULL,
-    parent_id INTEGER,
-    depth INTEGER NOT NULL
+    parent_id INTEGER NOT NULL DEFAULT 0,
+    depth INTEGER NOT NULL,
+    timed_out INTEGER NOT NULL DEFAULT 0,
+    kind INTEGER NOT NULL DEFAULT 1,
+    UNIQUE (parent_id, name)
 );
 `
 
@@ -36,7 +47,9 @@ CREATE TABLE IF NOT EXISTS rollups (
     total_size INTEGER NOT NULL,
     total_blocks INTEGER NOT NULL,
     total_files INTEGER NOT NULL,
-    total_dirs INTEGER NOT NULL
+    total_dirs INTEGER NOT NULL,
+    total_slack INTEGER NOT NULL DEFAULT 0,
+    total_sparse INTEGER NOT NULL DEFAULT 0
 );
 `
 
@@ -50,7 +63,23 @@ CREATE TABLE IF NOT EXISTS scan_meta (
     total_blocks INTEGER DEFAULT 0,
     file_count INTEGER DEFAULT 0,
     dir_count INTEGER DEFAULT 0,
-    error_count INTEGER DEFAULT 0
+    error_count INTEGER DEFAULT 0,
+    memory_limit INTEGER DEFAULT 0,
+    peak_rss INTEGER DEFAULT 0,
+    shard_index INTEGER DEFAULT 0,
+    shard_count INTEGER DEFAULT 0
+);
+`
+
+const scanRootsTableDDL = `
+CREATE TABLE IF NOT EXISTS scan_roots (
+    dir_id INTEGER PRIMARY KEY,
+    path TEXT NOT NULL,
+    dev_id INTEGER NOT NULL DEFAULT 0,
+    total_size INTEGER DEFAULT 0,
+    total_blocks INTEGER DEFAULT 0,
+    file_count INTEGER DEFAULT 0,
+    dir_count INTEGER DEFAULT 0
 );
 `
 
@@ -58,35 +87,121 @@ const scanErrorsTableDDL = `
 CREATE TABLE IF NOT EXISTS scan_errors (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     path TEXT NOT NULL,
-    message TEXT NOT NULL
+    message TEXT NOT NULL,
+    timed_out INTEGER NOT NULL DEFAULT 0
+);
+`
+
+const scanConcurrencyTableDDL = `
+CREATE TABLE IF NOT EXISTS scan_concurrency (
+    time INTEGER NOT NULL,
+    workers INTEGER NOT NULL,
+    ops_per_sec REAL NOT NULL,
+    avg_latency_us INTEGER NOT NULL
+);
+`
+
+const scanEventsTableDDL = `
+CREATE TABLE IF NOT EXISTS scan_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    time INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    detail TEXT NOT NULL DEFAULT ''
+);
+`
+
+const sourcesTableDDL = `
+CREATE TABLE IF NOT EXISTS sources (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    path TEXT NOT NULL,
+    root_path TEXT NOT NULL,
+    start_time INTEGER NOT NULL,
+    end_time INTEGER,
+    total_size INTEGER DEFAULT 0,
+    total_blocks INTEGER DEFAULT 0,
+    file_count INTEGER DEFAULT 0,
+    dir_count INTEGER DEFAULT 0,
+    error_count INTEGER DEFAULT 0,
+    shard_index INTEGER DEFAULT 0,
+    shard_count INTEGER DEFAULT 0
 );
 `
 
-const dirsPathIndexDDL = `CREATE UNIQUE INDEX IF NOT EXISTS idx_dirs_path ON dirs(path);`
+// dirsParentIndexDDL indexes the v1 dirs layout, which lacks the
+// (parent_id, name) constraint. Only migrations use it.
 const dirsParentIndexDDL = `CREATE INDEX IF NOT EXISTS idx_dirs_parent ON dirs(parent_id);`
 const entriesParentIndexDDL = `CREATE INDEX IF NOT EXISTS idx_entries_parent ON entries(parent_id);`
 const rollupsSizeIndexDDL = `CREATE INDEX IF NOT EXISTS idx_rollups_size ON rollups(total_size DESC);`
 const rollupsBlocksIndexDDL = `CREATE INDEX IF NOT EXISTS idx_rollups_blocks ON rollups(total_blocks DESC);`
-const entriesParentSizeIndexDDL = `CREATE INDEX IF NOT EXISTS idx_entries_parent_size ON entries(parent_id, size DESC);`
-const entriesParentBlocksIndexDDL = `CREATE INDEX IF NOT EXISTS idx_entries_parent_blocks ON entries(parent_id, blocks DESC);`
 
-// InitSchema creates all tables in the database.
-func InitSchema(db *sql.DB) error {
-	ddls := []string{
-		dirsTableDDL,
-		entriesTableDDL,
-		rollupsTableDDL,
-		scanMetaTableDDL,
-		scanErrorsTableDDL,
-	}
+// The entries listing indexes end in name and, implicitly, id, so pages of
+// a directory's files come out of them already in listing order.
+const entriesParentSizeIndexDDL = `CREATE INDEX IF NOT EXISTS idx_entries_parent_size ON entries(parent_id, size DESC, name);`
+const entriesParentBlocksIndexDDL = `CREATE INDEX IF NOT EXISTS idx_entries_parent_blocks ON entries(parent_id, blocks DESC, name);`
+const entriesParentNameIndexDDL = `CREATE INDEX IF NOT EXISTS idx_entries_parent_name ON entries(parent_id, name);`
+
+// entryFilesExpr counts an entry as a file. Listings sorted by file count
+// must use it verbatim to match idx_entries_parent_files.
+var entryFilesExpr = fmt.Sprintf("(kind IN (%d, %d))", entry.KindFile, entry.KindArchiveMember)
+
+var entriesParentFilesIndexDDL = `CREATE INDEX IF NOT EXISTS idx_entries_parent_files ON entries(parent_id, ` + entryFilesExpr + ` DESC, name);`
 
-	for _, ddl := range ddls {
+// dirListingTableDDL copies each directory's rollup next to its parent and
+// name, which rollups lacks, so a directory's subdirectories can be paged
+// in index order too. BuildIndexes fills it from dirListing once rollups
+// are complete; snapshots without it are listed from dirListing directly.
+const dirListingTableDDL = `
+CREATE TABLE IF NOT EXISTS dir_listing (
+    dir_id INTEGER PRIMARY KEY,
+    parent_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    kind INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    blocks INTEGER NOT NULL,
+    mtime INTEGER NOT NULL,
+    total_size INTEGER NOT NULL,
+    total_blocks INTEGER NOT NULL,
+    total_files INTEGER NOT NULL,
+    total_dirs INTEGER NOT NULL
+);
+`
+
+// dirListingIndexDDLs index dir_listing for each listing order. Ties end
+// in descending dir_id, the ascending order of directories' keys.
+var dirListingIndexDDLs = []string{
+	`CREATE INDEX IF NOT EXISTS idx_dir_listing_name ON dir_listing(parent_id, name, dir_id DESC);`,
+	`CREATE INDEX IF NOT EXISTS idx_dir_listing_size ON dir_listing(parent_id, total_size DESC, name, dir_id DESC);`,
+	`CREATE INDEX IF NOT EXISTS idx_dir_listing_blocks ON dir_listing(parent_id, total_blocks DESC, name, dir_id DESC);`,
+	`CREATE INDEX IF NOT EXISTS idx_dir_listing_files ON dir_listing(parent_id, total_files DESC, name, dir_id DESC);`,
+}
+
+// tableDDLs creates every table of the current schema.
+var tableDDLs = []string{
+	dirsTableDDL,
+	entriesTableDDL,
+	rollupsTableDDL,
+	scanMetaTableDDL,
+	scanRootsTableDDL,
+	scanErrorsTableDDL,
+	scanConcurrencyTableDDL,
+	scanEventsTableDDL,
+	sourcesTableDDL,
+	schemaInfoTableDDL,
+}
+
+// InitSchema creates all tables in the database and stamps it with the
+// current schema version.
+func InitSchema(db *sql.DB) error {
+	for _, ddl := range tableDDLs {
 		if _, err := db.Exec(ddl); err != nil {
 			return fmt.Errorf("failed to execute DDL: %w", err)
 		}
 	}
 
-	return nil
+	if err := writeVersion(db); err != nil {
+		return err
+	}
+	return setInfo(db, InfoCreatedAt, strconv.FormatInt(time.Now().Unix(), 10))
 }
 
 // ApplyWritePragmas configures SQLite for optimal write performance during ingestion.
@@ -108,6 +223,51 @@ func ApplyWritePragmas(db *sql.DB) error {
 	return nil
 }
 
+// ApplyCachePragmas sizes the page cache and mmap window, overriding the
+// defaults from ApplyWritePragmas when a memory budget is in force.
+func ApplyCachePragmas(db *sql.DB, cacheBytes, mmapBytes int64) error {
+	pragmas := []string{
+		fmt.Sprintf("PRAGMA cache_size = -%d", max(cacheBytes/1024, 1024)), // negative = KiB
+		fmt.Sprintf("PRAGMA mmap_size = %d", mmapBytes),
+	}
+
+	for _, pragma := range pragmas {
+		if _, err := db.Exec(pragma); err != nil {
+			return fmt.Errorf("failed to apply pragma %q: %w", pragma, err)
+		}
+	}
+
+	return nil
+}
+
+// ReadOnlyURI returns a SQLite URI that opens path read-only. Building it
+// as a URL escapes characters such as '#' and '?', which would otherwise
+// end the file name.
+func ReadOnlyURI(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", OmitHost: true, Path: abs, RawQuery: "mode=ro"}).String(), nil
+}
+
+// OpenReadOnly opens the database at path read-only. Unlike sql.Open, it
+// fails if the file does not exist.
+func OpenReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	uri, err := ReadOnlyURI(path)
+	if err != nil {
+		return nil, err
+	}
+	database, err := sql.Open("sqlite", uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return database, nil
+}
+
 // ApplyReadPragmas configures SQLite for optimal read performance.
 func ApplyReadPragmas(db *sql.DB) error {
 	pragmas := []string{
@@ -158,13 +318,13 @@ func ApplyIndexPragmas(db *sql.DB, diskTemp bool, tmpDir string) error {
 // BuildIndexes creates indexes after the initial data load for better performance.
 func BuildIndexes(db *sql.DB) error {
 	indexes := []string{
-		dirsPathIndexDDL,
-		dirsParentIndexDDL,
 		entriesParentIndexDDL,
 		rollupsSizeIndexDDL,
 		rollupsBlocksIndexDDL,
 		entriesParentSizeIndexDDL,
 		entriesParentBlocksIndexDDL,
+		entriesParentNameIndexDDL,
+		entriesParentFilesIndexDDL,
 	}
 
 	for _, idx := range indexes {
@@ -173,6 +333,22 @@ func BuildIndexes(db *sql.DB) error {
 		}
 	}
 
+	return buildDirListing(db)
+}
+
+// buildDirListing fills dir_listing and indexes it.
+func buildDirListing(db *sql.DB) error {
+	stmts := []string{
+		dirListingTableDDL,
+		`DELETE FROM dir_listing`,
+		`INSERT INTO dir_listing (dir_id, parent_id, name, kind, size, blocks, mtime, total_size, total_blocks, total_files, total_dirs)` +
+			dirListing(db),
+	}
+	for _, stmt := range append(stmts, dirListingIndexDDLs...) {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to build directory listing: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+// nameIndexTableDDL is a contentless trigram index over every name in the
+// snapshot. Entries use their own ID as rowid and directories their
+// negated ID, since the two ranges overlap.
+const nameIndexTableDDL = `
+CREATE VIRTUAL TABLE IF NOT EXISTS name_index USING fts5(
+    name,
+    content = '',
+    tokenize = 'trigram'
+);
+`
+
+// minTrigram is the shortest literal the trigram index can look up.
+const minTrigram = 3
+
+// BuildSearchIndex indexes the names of all files and directories for
+// Search. The directory without a parent is left out, since its name is an
+// absolute path rather than a name.
+func BuildSearchIndex(db *sql.DB) error {
+	stmts := []string{
+		nameIndexTableDDL,
+		`INSERT INTO name_index (rowid, name) SELECT id, name FROM entries`,
+		`INSERT INTO name_index (rowid, name) SELECT -id, name FROM dirs WHERE parent_id != 0`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+	}
+	return nil
+}
+
+// HasSearchIndex reports whether the snapshot was built with a name index.
+func HasSearchIndex(db *sql.DB) bool {
+	return hasTable(db, "name_index")
+}
+
+// Search finds files and directories anywhere in the snapshot whose name
+// contains pattern, ignoring ASCII case, largest first. With a '*' or '?'
+// wildcard the pattern must match the whole name instead, as in a shell
+// glob. Snapshots built with a search index answer from it; others are
+// scanned in full.
+func Search(db *sql.DB, pattern string, limit int) ([]DisplayEntry, error) {
+	if err := CheckSchema(db); err != nil {
+		return nil, err
+	}
+	if pattern == "" {
+		return nil, nil
+	}
+	like := likePattern(pattern)
+
+	dirs, files := "dirs d", "entries e"
+	var args []any
+	if literal := longestLiteral(pattern); utf8.RuneCountInString(literal) >= minTrigram && HasSearchIndex(db) {
+		hits := `SELECT rowid FROM name_index WHERE name_index MATCH ?`
+		dirs = "dirs d JOIN (" + hits + ") h ON d.id = -h.rowid"
+		files = "entries e JOIN (" + hits + ") h ON e.id = h.rowid"
+		phrase := `"` + strings.ReplaceAll(literal, `"`, `""`) + `"`
+		args = []any{phrase, like, phrase, like, limit}
+	} else {
+		args = []any{like, like, limit}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT d.id, 1, d.name, %s, 0, 0, 0,
+		       COALESCE(r.total_size, 0), COALESCE(r.total_blocks, 0),
+		       COALESCE(r.total_files, 0), COALESCE(r.total_dirs, 0)
+		FROM %s
+		LEFT JOIN rollups r ON r.dir_id = d.id
+		WHERE d.parent_id != 0 AND d.name LIKE ? ESCAPE '\'
+
+		UNION ALL
+
+		SELECT e.parent_id, 0, e.name, e.kind, e.size, e.blocks, e.mtime,
+		       e.size, e.blocks, CASE WHEN e.kind IN (%d, %d) THEN 1 ELSE 0 END, 0
+		FROM %s
+		WHERE e.name LIKE ? ESCAPE '\'
+		ORDER BY 8 DESC
+		LIMIT ?
+	`, dirKind(db, "d"), dirs, entry.KindFile, entry.KindArchiveMember, files)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	defer rows.Close()
+
+	// Paths are resolved once the rows are read, since the database may
+	// allow a single connection
+	var results []DisplayEntry
+	var ids []int64
+	var isDir []bool
+	for rows.Next() {
+		var e DisplayEntry
+		var id, mtime int64
+		var dir bool
+		if err := rows.Scan(&id, &dir, &e.Name, &e.Kind, &e.Size, &e.Blocks, &mtime,
+			&e.TotalSize, &e.TotalBlocks, &e.TotalFiles, &e.TotalDirs); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		e.ModTime = time.Unix(mtime, 0)
+		results = append(results, e)
+		ids = append(ids, id)
+		isDir = append(isDir, dir)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range results {
+		path, err := DirPath(db, ids[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", results[i].Name, err)
+		}
+		if !isDir[i] {
+			path = filepath.Join(path, results[i].Name)
+		}
+		results[i].Path = path
+	}
+	return results, nil
+}
+
+// likePattern turns a search pattern into a LIKE pattern with '\' as the
+// escape character.
+func likePattern(pattern string) string {
+	var b strings.Builder
+	wildcard := strings.ContainsAny(pattern, "*?")
+	if !wildcard {
+		b.WriteByte('%')
+	}
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		case '%', '_', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if !wildcard {
+		b.WriteByte('%')
+	}
+	return b.String()
+}
+
+// longestLiteral returns the longest run of pattern without wildcards,
+// which every match must contain.
+func longestLiteral(pattern string) string {
+	var best string
+	for _, part := range strings.FieldsFunc(pattern, func(r rune) bool { return r == '*' || r == '?' }) {
+		if len(part) > len(best) {
+			best = part
+		}
+	}
+	return best
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func TestSearchMatchesNamesWithAndWithoutIndex(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO dirs (id, name, parent_id, depth) VALUES (1, '/data', 0, 0), (2, 'runs', 1, 1), (3, 'Checkpoints', 2, 2)`,
+		`INSERT INTO rollups (dir_id, total_size, total_blocks, total_files, total_dirs) VALUES (3, 500, 512, 2, 0)`,
+		`INSERT INTO entries (parent_id, name, kind, size, blocks, mtime, dev_id, inode) VALUES
+			(3, 'model_checkpoint_01.pt', 0, 300, 512, 0, 1, 1),
+			(3, 'notes.txt', 0, 200, 512, 0, 1, 2),
+			(2, 'checkpoint%.log', 0, 10, 512, 0, 1, 3)`,
+	} {
+		if _, err := database.Exec(stmt); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	paths := func(pattern string) []string {
+		t.Helper()
+		results, err := Search(database, pattern, 10)
+		if err != nil {
+			t.Fatalf("search %q: %v", pattern, err)
+		}
+		var out []string
+		for _, r := range results {
+			out = append(out, r.Path)
+		}
+		return out
+	}
+	check := func(pattern string, want ...string) {
+		t.Helper()
+		if got := paths(pattern); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("search %q = %v, want %v", pattern, got, want)
+		}
+	}
+
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			if err := BuildSearchIndex(database); err != nil {
+				t.Fatalf("build search index: %v", err)
+			}
+		}
+		check("checkpoint", "/data/runs/Checkpoints", "/data/runs/Checkpoints/model_checkpoint_01.pt", "/data/runs/checkpoint%.log")
+		check("*.pt", "/data/runs/Checkpoints/model_checkpoint_01.pt")
+		check("point%", "/data/runs/checkpoint%.log")
+		check("data")
+	}
+}
@@ -0,0 +1,297 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+// verifySamples is how many offending directories a failed check names.
+const verifySamples = 5
+
+// Problem is an inconsistency found by Verify.
+type Problem struct {
+	Check   string // the check that failed
+	Message string
+}
+
+// VerifyChecks names the checks Verify runs, in order.
+var VerifyChecks = []string{"integrity", "entries", "dirs", "rollups", "scan_meta"}
+
+// Verify checks a snapshot for corruption and for broken dug invariants:
+// SQLite's own integrity check, entries and directories whose parent is
+// missing, directories without a rollup, rollups that differ from the sum
+// of their files and subdirectories, and scan_meta totals that differ from
+// the top directory's rollup. Rollups are not checked in summary snapshots,
+// which no longer hold what they add up. Quick uses PRAGMA quick_check,
+// which skips verifying that indexes match their tables.
+//
+// A check that cannot run, as on a truncated file, is reported as a
+// Problem. The error is only for snapshots this build cannot read.
+func Verify(db *sql.DB, quick bool) ([]Problem, error) {
+	if err := CheckSchema(db); err != nil {
+		return nil, err
+	}
+
+	checks := []func(*sql.DB) ([]string, error){
+		func(db *sql.DB) ([]string, error) { return verifyIntegrity(db, quick) },
+		verifyEntries,
+		verifyDirs,
+		verifyRollups,
+		verifyScanMeta,
+	}
+	var problems []Problem
+	for i, check := range checks {
+		msgs, err := check(db)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("check failed: %v", err))
+		}
+		for _, msg := range msgs {
+			problems = append(problems, Problem{Check: VerifyChecks[i], Message: msg})
+		}
+	}
+	return problems, nil
+}
+
+func verifyIntegrity(db *sql.DB, quick bool) ([]string, error) {
+	pragma := "PRAGMA integrity_check"
+	if quick {
+		pragma = "PRAGMA quick_check"
+	}
+	rows, err := db.Query(pragma)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return msgs, err
+		}
+		if msg != "ok" {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, rows.Err()
+}
+
+func verifyEntries(db *sql.DB) ([]string, error) {
+	var n int64
+	err := db.QueryRow(`SELECT COUNT(*) FROM entries e WHERE NOT EXISTS (SELECT 1 FROM dirs d WHERE d.id = e.parent_id)`).Scan(&n)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("%d entries belong to directories that do not exist", n)}, nil
+}
+
+func verifyDirs(db *sql.DB) ([]string, error) {
+	var msgs []string
+
+	var orphans int64
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM dirs d
+		WHERE COALESCE(d.parent_id, 0) != 0 AND NOT EXISTS (SELECT 1 FROM dirs p WHERE p.id = d.parent_id)
+	`).Scan(&orphans)
+	if err != nil {
+		return nil, err
+	}
+	if orphans > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d directories have a parent that does not exist", orphans))
+	}
+
+	ids, n, err := sampleIDs(db, `
+		SELECT d.id FROM dirs d
+		WHERE NOT EXISTS (SELECT 1 FROM rollups r WHERE r.dir_id = d.id)
+	`)
+	if err != nil {
+		return msgs, err
+	}
+	if n > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d directories have no rollup%s", n, examples(db, ids)))
+	}
+	return msgs, nil
+}
+
+// verifyRollups compares every rollup with what the scan adds up for it:
+// the directory's own files and archive members, plus each subdirectory's
+// rollup and the subdirectory itself. An archive's listing is rolled up on
+// its own and not into the directory holding the archive.
+func verifyRollups(db *sql.DB) ([]string, error) {
+	// Summaries keep rollups for what they dropped, so cannot add them up
+	if _, ok := SummaryDepth(db); ok {
+		return nil, nil
+	}
+
+	// Snapshots from before efficiency tracking have no slack columns
+	slack := hasColumn(db, "rollups", "total_slack")
+	ownSlack, kidSlack, slackMismatch := "0, 0", "0, 0", ""
+	if slack {
+		ownSlack = fmt.Sprintf(`
+			SUM(CASE WHEN kind = %[1]d THEN MAX(blocks - size, 0) ELSE 0 END),
+			SUM(CASE WHEN kind = %[1]d THEN MAX(size - blocks, 0) ELSE 0 END)`, entry.KindFile)
+		kidSlack = "SUM(r.total_slack), SUM(r.total_sparse)"
+		slackMismatch = `
+			OR r.total_slack != COALESCE(o.slack, 0) + COALESCE(k.slack, 0)
+			OR r.total_sparse != COALESCE(o.sparse, 0) + COALESCE(k.sparse, 0)`
+	}
+
+	query := fmt.Sprintf(`
+		WITH own(id, size, blocks, files, slack, sparse) AS (
+			SELECT parent_id,
+			       SUM(CASE WHEN kind IN (%[1]d, %[2]d) THEN size ELSE 0 END),
+			       SUM(CASE WHEN kind IN (%[1]d, %[2]d) THEN blocks ELSE 0 END),
+			       SUM(kind IN (%[1]d, %[2]d)),
+			       %[3]s
+			FROM entries GROUP BY parent_id
+		),
+		kids(id, size, blocks, files, dirs, slack, sparse) AS (
+			SELECT d.parent_id, SUM(r.total_size), SUM(r.total_blocks), SUM(r.total_files),
+			       SUM(r.total_dirs + 1), %[4]s
+			FROM dirs d
+			JOIN dirs p ON p.id = d.parent_id
+			JOIN rollups r ON r.dir_id = d.id
+			WHERE NOT (%[5]s = %[6]d AND %[7]s != %[6]d)
+			GROUP BY d.parent_id
+		)
+		SELECT d.id FROM dirs d
+		JOIN rollups r ON r.dir_id = d.id
+		LEFT JOIN own o ON o.id = d.id
+		LEFT JOIN kids k ON k.id = d.id
+		WHERE r.total_size != COALESCE(o.size, 0) + COALESCE(k.size, 0)
+		   OR r.total_blocks != COALESCE(o.blocks, 0) + COALESCE(k.blocks, 0)
+		   OR r.total_files != COALESCE(o.files, 0) + COALESCE(k.files, 0)
+		   OR r.total_dirs != COALESCE(k.dirs, 0)
+		   %[8]s
+	`, entry.KindFile, entry.KindArchiveMember, ownSlack, kidSlack, dirKind(db, "d"), entry.KindArchive, dirKind(db, "p"), slackMismatch)
+
+	ids, n, err := sampleIDs(db, query)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("%d rollups differ from the sum of their contents%s", n, examples(db, ids))}, nil
+}
+
+// verifyScanMeta checks that the scan finished and that its totals match
+// the top directory's rollup. The top directory counts itself unless it is
+// the synthetic parent of several roots.
+func verifyScanMeta(db *sql.DB) ([]string, error) {
+	var endTime sql.NullInt64
+	var size, blocks, files, dirs int64
+	err := db.QueryRow(`SELECT end_time, total_size, total_blocks, file_count, dir_count FROM scan_meta WHERE id = 1`).
+		Scan(&endTime, &size, &blocks, &files, &dirs)
+	if err == sql.ErrNoRows {
+		return []string{"scan_meta is empty"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msgs []string
+	if !endTime.Valid || endTime.Int64 == 0 {
+		msgs = append(msgs, "scan never finished: scan_meta has no end time")
+	}
+
+	rows, err := db.Query(`SELECT id FROM dirs WHERE COALESCE(parent_id, 0) = 0`)
+	if err != nil {
+		return msgs, err
+	}
+	var tops []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return msgs, err
+		}
+		tops = append(tops, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return msgs, err
+	}
+	if len(tops) != 1 {
+		return append(msgs, fmt.Sprintf("expected one top directory, found %d", len(tops))), nil
+	}
+	top := tops[0]
+
+	var r entry.Rollup
+	err = db.QueryRow(`SELECT total_size, total_blocks, total_files, total_dirs FROM rollups WHERE dir_id = ?`, top).
+		Scan(&r.TotalSize, &r.TotalBlocks, &r.TotalFiles, &r.TotalDirs)
+	if err == sql.ErrNoRows {
+		return msgs, nil // reported by the dirs check
+	}
+	if err != nil {
+		return msgs, err
+	}
+
+	wantDirs := r.TotalDirs + 1
+	if hasTable(db, "scan_roots") {
+		var roots, isRoot int64
+		err := db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(dir_id = ?), 0) FROM scan_roots`, top).Scan(&roots, &isRoot)
+		if err != nil {
+			return msgs, err
+		}
+		if roots > 0 && isRoot == 0 {
+			wantDirs = r.TotalDirs
+		}
+	}
+
+	var diffs []string
+	for _, c := range []struct {
+		name      string
+		meta, top int64
+	}{
+		{"size", size, r.TotalSize},
+		{"blocks", blocks, r.TotalBlocks},
+		{"files", files, r.TotalFiles},
+		{"dirs", dirs, wantDirs},
+	} {
+		if c.meta != c.top {
+			diffs = append(diffs, fmt.Sprintf("%s %d vs %d", c.name, c.meta, c.top))
+		}
+	}
+	if len(diffs) > 0 {
+		msgs = append(msgs, "scan_meta totals differ from the top directory's rollup: "+strings.Join(diffs, ", "))
+	}
+	return msgs, nil
+}
+
+// sampleIDs runs a query selecting directory IDs and returns how many rows
+// it found along with the first few.
+func sampleIDs(db *sql.DB, query string) ([]int64, int64, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var n int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		if len(ids) < verifySamples {
+			ids = append(ids, id)
+		}
+		n++
+	}
+	return ids, n, rows.Err()
+}
+
+// examples names the directories with the given IDs, falling back to the
+// ID for directories whose path cannot be resolved.
+func examples(db *sql.DB, ids []int64) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		path, err := DirPath(db, id)
+		if err != nil {
+			path = fmt.Sprintf("dir %d", id)
+		}
+		names[i] = path
+	}
+	return ", e.g. " + strings.Join(names, ", ")
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestVerifyFindsBrokenRollups(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO dirs (id, name, parent_id, depth) VALUES (1, '/', 0, 0), (2, 'data', 1, 1)`,
+		`INSERT INTO rollups (dir_id, total_size, total_blocks, total_files, total_dirs, total_slack) VALUES (1, 3072, 8192, 2, 1, 5120), (2, 2048, 4096, 1, 0, 2048)`,
+		`INSERT INTO entries (parent_id, name, kind, size, blocks, mtime, dev_id, inode) VALUES (1, 'a.txt', 0, 1024, 4096, 0, 1, 1), (2, 'b.bin', 0, 2048, 4096, 0, 1, 2)`,
+		`INSERT INTO scan_meta (id, root_path, start_time, end_time, total_size, total_blocks, file_count, dir_count, error_count)
+			VALUES (1, '/', 1, 2, 3072, 8192, 2, 2, 0)`,
+	} {
+		if _, err := database.Exec(stmt); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	problems, err := Verify(database, false)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("consistent snapshot has problems: %+v", problems)
+	}
+
+	if _, err := database.Exec(`UPDATE rollups SET total_size = 1 WHERE dir_id = 2`); err != nil {
+		t.Fatalf("corrupt rollup: %v", err)
+	}
+	if _, err := database.Exec(`UPDATE scan_meta SET end_time = NULL`); err != nil {
+		t.Fatalf("clear end time: %v", err)
+	}
+	problems, err = Verify(database, true)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	failed := make(map[string]bool)
+	for _, p := range problems {
+		failed[p.Check] = true
+	}
+	if !failed["rollups"] || !failed["scan_meta"] || failed["integrity"] || failed["entries"] || failed["dirs"] {
+		t.Fatalf("problems = %+v", problems)
+	}
+}
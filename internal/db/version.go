@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// SchemaVersion is the layout this build writes, stored in PRAGMA
+// user_version. Snapshots from before versioning read as version 0.
+//
+// Versions only add tables and columns unless noted, so a snapshot stays
+// readable by builds that know an older version: it records the oldest
+// version a reader must know as min_reader_version in schema_info.
+//
+//	0  unversioned; columns vary with the dug release that wrote it
+//	1  schema_info; every column up to rollups.total_slack/total_sparse
+//	2  dirs drops path for (parent_id, name); needs a v2 reader
+const SchemaVersion = 2
+
+// minReaderVersion is the oldest schema a reader of this build's snapshots
+// must understand.
+const minReaderVersion = 2
+
+const schemaInfoTableDDL = `
+CREATE TABLE IF NOT EXISTS schema_info (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+`
+
+// Keys in schema_info.
+const (
+	InfoSchemaVersion = "schema_version"
+	InfoMinReader     = "min_reader_version"
+	InfoDugVersion    = "dug_version"
+	InfoCreatedAt     = "created_at"
+	InfoScanOptions   = "scan_options"
+	InfoMigratedFrom  = "migrated_from"
+	InfoMigratedAt    = "migrated_at"
+	InfoSummaryDepth  = "summary_depth"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// writeVersion stamps the current schema version.
+func writeVersion(db execer) error {
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+	for key, value := range map[string]string{
+		InfoSchemaVersion: strconv.Itoa(SchemaVersion),
+		InfoMinReader:     strconv.Itoa(minReaderVersion),
+	} {
+		if err := setInfo(db, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSchemaInfo records a key in the snapshot's schema_info table.
+func SetSchemaInfo(db *sql.DB, key, value string) error {
+	return setInfo(db, key, value)
+}
+
+func setInfo(db execer, key, value string) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO schema_info (key, value) VALUES (?, ?)`, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to record %s: %w", key, err)
+	}
+	return nil
+}
+
+// SchemaInfo returns the snapshot's schema_info entries, empty for
+// snapshots from before versioning.
+func SchemaInfo(db *sql.DB) (map[string]string, error) {
+	return SchemaInfoContext(context.Background(), db)
+}
+
+// SchemaInfoContext is SchemaInfo with a context that cancels its query.
+func SchemaInfoContext(ctx context.Context, db *sql.DB) (map[string]string, error) {
+	info := make(map[string]string)
+	rows, err := db.QueryContext(ctx, `SELECT key, value FROM schema_info`)
+	if err != nil {
+		if !hasTable(db, "schema_info") {
+			return info, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		info[key] = value
+	}
+	return info, rows.Err()
+}
+
+// Version returns the snapshot's schema version from PRAGMA user_version.
+func Version(db *sql.DB) (int, error) {
+	return VersionContext(context.Background(), db)
+}
+
+// VersionContext is Version with a context that cancels its query.
+func VersionContext(ctx context.Context, db *sql.DB) (int, error) {
+	var v int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&v); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return v, nil
+}
+
+// ErrSchemaTooNew is returned by readers for snapshots that need a newer
+// dug to read.
+type ErrSchemaTooNew struct {
+	Version   int // the snapshot's schema version
+	MinReader int // the oldest schema a reader must know
+}
+
+func (e *ErrSchemaTooNew) Error() string {
+	return fmt.Sprintf("snapshot schema v%d needs a dug that reads schema v%d or later (this one reads up to v%d); upgrade dug",
+		e.Version, e.MinReader, SchemaVersion)
+}
+
+var dbSchemaChecks sync.Map // map[*sql.DB]error
+
+// CheckSchema verifies this build can read the snapshot. Older snapshots
+// are accepted, since readers adapt to the columns they find; snapshots
+// whose min_reader_version is newer than SchemaVersion are refused with an
+// *ErrSchemaTooNew. The result is cached per database.
+func CheckSchema(db *sql.DB) error {
+	if v, ok := dbSchemaChecks.Load(db); ok {
+		if v == nil {
+			return nil
+		}
+		return v.(error)
+	}
+
+	err := checkSchema(db)
+	if err == nil {
+		dbSchemaChecks.Store(db, nil)
+		return nil
+	}
+	var tooNew *ErrSchemaTooNew
+	if errors.As(err, &tooNew) {
+		dbSchemaChecks.Store(db, err)
+	}
+	return err
+}
+
+func checkSchema(db *sql.DB) error {
+	version, err := Version(db)
+	if err != nil {
+		return err
+	}
+	if version <= SchemaVersion {
+		return nil
+	}
+
+	// Newer snapshots say how old a reader may be
+	minReader := version
+	info, err := SchemaInfo(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema info: %w", err)
+	}
+	if v, err := strconv.Atoi(info[InfoMinReader]); err == nil {
+		minReader = v
+	}
+	if minReader > SchemaVersion {
+		return &ErrSchemaTooNew{Version: version, MinReader: minReader}
+	}
+	return nil
+}
+
+// hasTable reports whether the database has the named table.
+func hasTable(db *sql.DB, table string) bool {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n)
+	return err == nil && n > 0
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/michaelscutari/dug/internal/entry"
+	"modernc.org/sqlite"
+)
+
+// Helper views and functions for ad hoc SQL over a snapshot. The views are
+// TEMP, so they work on read-only snapshots and never change the file, and
+// exist only on the connection that created them.
+
+// HelperViews describes the views CreateHelperViews adds.
+var HelperViews = []struct{ Name, Description string }{
+	{"dir_paths", "id and full path of every directory"},
+	{"dir_tree", "every directory with its path, kind and rollup totals"},
+	{"files", "every non-directory entry with its full path and its directory's"},
+}
+
+// HelperFunctions describes the functions RegisterFunctions adds.
+var HelperFunctions = []struct{ Name, Description string }{
+	{"human_bytes(n)", "n bytes as a readable size, e.g. 1.2 GB"},
+	{"age_days(t)", "days since the Unix time t, e.g. an mtime"},
+	{"kind_name(k)", "an entry kind as file, dir, symlink, archive, member or other"},
+}
+
+var (
+	registerOnce sync.Once
+	registerErr  error
+)
+
+// RegisterFunctions registers the helper SQL functions with the SQLite
+// driver. They are available on connections opened afterwards.
+func RegisterFunctions() error {
+	registerOnce.Do(func() {
+		registerErr = registerFunctions()
+	})
+	return registerErr
+}
+
+func registerFunctions() error {
+	if err := sqlite.RegisterDeterministicScalarFunction("human_bytes", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		n, ok := args[0].(int64)
+		if !ok {
+			if f, isFloat := args[0].(float64); isFloat {
+				n, ok = int64(f), true
+			}
+		}
+		if !ok || n < 0 {
+			return nil, nil
+		}
+		return humanize.Bytes(uint64(n)), nil
+	}); err != nil {
+		return fmt.Errorf("failed to register human_bytes: %w", err)
+	}
+
+	if err := sqlite.RegisterScalarFunction("age_days", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		t, ok := args[0].(int64)
+		if !ok {
+			return nil, nil
+		}
+		return time.Since(time.Unix(t, 0)).Hours() / 24, nil
+	}); err != nil {
+		return fmt.Errorf("failed to register age_days: %w", err)
+	}
+
+	if err := sqlite.RegisterDeterministicScalarFunction("kind_name", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		k, ok := args[0].(int64)
+		if !ok {
+			return nil, nil
+		}
+		return entry.Kind(k).String(), nil
+	}); err != nil {
+		return fmt.Errorf("failed to register kind_name: %w", err)
+	}
+	return nil
+}
+
+// CreateHelperViews adds the helper views to the connection. Since TEMP
+// views belong to one connection, db should be limited to a single open
+// connection. Call it after ApplyReadPragmas, since changing temp_store
+// drops TEMP views.
+func CreateHelperViews(db *sql.DB) error {
+	if err := CheckSchema(db); err != nil {
+		return err
+	}
+
+	// query_only forbids even TEMP views, so lift it while they are made
+	var queryOnly bool
+	if err := db.QueryRow(`PRAGMA query_only`).Scan(&queryOnly); err != nil {
+		return fmt.Errorf("failed to read query_only: %w", err)
+	}
+	if queryOnly {
+		if _, err := db.Exec(`PRAGMA query_only = OFF`); err != nil {
+			return err
+		}
+		defer db.Exec(`PRAGMA query_only = ON`)
+	}
+
+	// Before schema v2 every directory stores its full path
+	dirPaths := `SELECT id, path FROM main.dirs`
+	if !hasDirPaths(db) {
+		dirPaths = `
+			WITH RECURSIVE p(id, path) AS (
+				SELECT id, name FROM main.dirs WHERE parent_id = 0
+				UNION ALL
+				SELECT d.id, CASE WHEN p.path = '/' THEN '/' ELSE p.path || '/' END || d.name
+				FROM main.dirs d JOIN p ON d.parent_id = p.id
+			)
+			SELECT id, path FROM p`
+	}
+
+	stmts := []string{
+		`CREATE TEMP VIEW IF NOT EXISTS dir_paths AS ` + dirPaths,
+		`CREATE TEMP VIEW IF NOT EXISTS dir_tree AS
+			SELECT d.id, p.path, d.name, d.parent_id, d.depth, ` + dirKind(db, "d") + ` AS kind,
+			       COALESCE(r.total_size, 0) AS total_size,
+			       COALESCE(r.total_blocks, 0) AS total_blocks,
+			       COALESCE(r.total_files, 0) AS total_files,
+			       COALESCE(r.total_dirs, 0) AS total_dirs
+			FROM main.dirs d
+			JOIN dir_paths p ON p.id = d.id
+			LEFT JOIN main.rollups r ON r.dir_id = d.id`,
+		`CREATE TEMP VIEW IF NOT EXISTS files AS
+			SELECT e.id, CASE WHEN p.path = '/' THEN '/' ELSE p.path || '/' END || e.name AS path,
+			       e.name, p.path AS dir, e.parent_id, e.kind, e.size, e.blocks, e.mtime
+			FROM main.entries e
+			JOIN dir_paths p ON p.id = e.parent_id`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create helper views: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestHelperViewsResolvePaths(t *testing.T) {
+	if err := RegisterFunctions(); err != nil {
+		t.Fatalf("register functions: %v", err)
+	}
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO dirs (id, name, parent_id, depth) VALUES (1, '/', 0, 0), (2, 'data', 1, 1), (3, 'runs', 2, 2)`,
+		`INSERT INTO rollups (dir_id, total_size, total_blocks, total_files, total_dirs) VALUES (3, 2048, 4096, 1, 0)`,
+		`INSERT INTO entries (parent_id, name, kind, size, blocks, mtime, dev_id, inode) VALUES (3, 'model.pt', 0, 2048, 4096, 0, 1, 1)`,
+	} {
+		if _, err := database.Exec(stmt); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if err := ApplyReadPragmas(database); err != nil {
+		t.Fatalf("read pragmas: %v", err)
+	}
+	if err := CreateHelperViews(database); err != nil {
+		t.Fatalf("create views: %v", err)
+	}
+
+	var path, dir, size, kind string
+	if err := database.QueryRow(`SELECT path, dir, human_bytes(size), kind_name(kind) FROM files`).Scan(&path, &dir, &size, &kind); err != nil {
+		t.Fatalf("query files: %v", err)
+	}
+	if path != "/data/runs/model.pt" || dir != "/data/runs" || size != "2.0 kB" || kind != "file" {
+		t.Fatalf("files row = %q %q %q %q", path, dir, size, kind)
+	}
+
+	var total int64
+	if err := database.QueryRow(`SELECT total_size FROM dir_tree WHERE path = '/data/runs'`).Scan(&total); err != nil || total != 2048 {
+		t.Fatalf("dir_tree total = %d, %v", total, err)
+	}
+
+	// The views leave the connection read-only
+	if _, err := database.Exec(`DELETE FROM entries`); err == nil {
+		t.Fatalf("expected query_only to refuse writes")
+	}
+}
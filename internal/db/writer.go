@@ -9,14 +9,16 @@ import (
 	"time"
 
 	"github.com/michaelscutari/dug/internal/entry"
+	"github.com/michaelscutari/dug/internal/throttle"
 )
 
 // DEBUG: Controlled by scan verbosity.
 
-const insertDirSQL = `INSERT OR REPLACE INTO dirs (id, path, name, parent_id, depth) VALUES (?, ?, ?, ?, ?)`
+const insertDirSQL = `INSERT OR REPLACE INTO dirs (id, name, parent_id, depth, kind) VALUES (?, ?, ?, ?, ?)`
 const insertEntrySQL = `INSERT OR REPLACE INTO entries (parent_id, name, kind, size, blocks, mtime, dev_id, inode) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
-const insertRollupSQL = `INSERT OR REPLACE INTO rollups (dir_id, total_size, total_blocks, total_files, total_dirs) VALUES (?, ?, ?, ?, ?)`
-const insertErrorSQL = `INSERT INTO scan_errors (path, message) VALUES (?, ?)`
+const insertRollupSQL = `INSERT OR REPLACE INTO rollups (dir_id, total_size, total_blocks, total_files, total_dirs, total_slack, total_sparse) VALUES (?, ?, ?, ?, ?, ?, ?)`
+const insertErrorSQL = `INSERT INTO scan_errors (path, message, timed_out) VALUES (?, ?, ?)`
+const markTimedOutSQL = `UPDATE dirs SET timed_out = 1 WHERE id = ?`
 
 const maxErrorsSampled = 1000
 
@@ -39,6 +41,9 @@ type Ingester struct {
 	errorCount  int64
 	errorCapped bool
 
+	// Directories reported as timed out whose rows have not been marked yet.
+	timedOutDirs []int64
+
 	// Progress tracking (atomic)
 	fileCount  int64
 	dirCount   int64
@@ -49,6 +54,8 @@ type Ingester struct {
 	rollupStmt *sql.Stmt
 	errorStmt  *sql.Stmt
 
+	gate *throttle.Gate
+
 	debug bool
 }
 
@@ -80,6 +87,11 @@ func NewIngester(db *sql.DB, entryCh <-chan entry.Entry, dirCh <-chan entry.Dir,
 	}
 }
 
+// SetGate makes the ingester quiesce while gate is paused.
+func (ing *Ingester) SetGate(gate *throttle.Gate) {
+	ing.gate = gate
+}
+
 // Run consumes entries from the channel and batches them to the database.
 // It returns when the entry channel is closed.
 func (ing *Ingester) Run(ctx context.Context) error {
@@ -122,6 +134,12 @@ func (ing *Ingester) Run(ctx context.Context) error {
 	errorCh := ing.errorCh
 
 	for entryCh != nil || dirCh != nil || rollupCh != nil || errorCh != nil {
+		if ing.gate.Paused() {
+			if err := ing.quiesce(ctx); err != nil {
+				return err
+			}
+		}
+
 		loopCount++
 		if ing.debug && loopCount%10000 == 0 {
 			fmt.Fprintf(os.Stderr, "[INGESTER] LOOP#%d batchLen=%d files=%d dirs=%d\n",
@@ -160,7 +178,9 @@ func (ing *Ingester) Run(ctx context.Context) error {
 				dirCh = nil
 				continue
 			}
-			atomic.AddInt64(&ing.dirCount, 1)
+			if !d.Archive {
+				atomic.AddInt64(&ing.dirCount, 1)
+			}
 			ing.dirBatch = append(ing.dirBatch, d)
 			if len(ing.dirBatch) >= ing.batchSize {
 				if err := ing.flushDirs(); err != nil {
@@ -192,8 +212,11 @@ func (ing *Ingester) Run(ctx context.Context) error {
 					ing.cancelFunc() // Signal scan to stop
 				}
 			}
-			// Only sample first N errors to bound memory
-			if !ing.errorCapped {
+			if e.TimedOut && e.DirID != 0 {
+				ing.timedOutDirs = append(ing.timedOutDirs, e.DirID)
+			}
+			// Only sample first N errors to bound memory; timeouts are always kept
+			if !ing.errorCapped || e.TimedOut {
 				ing.errorBatch = append(ing.errorBatch, e)
 				if len(ing.errorBatch) >= maxErrorsSampled {
 					ing.errorCapped = true
@@ -219,10 +242,34 @@ func (ing *Ingester) Run(ctx context.Context) error {
 	return ing.flush()
 }
 
+// quiesce flushes all pending batches and checkpoints the WAL so the
+// database file is consistent on its own, then waits for the gate to reopen.
+func (ing *Ingester) quiesce(ctx context.Context) error {
+	if err := ing.flush(); err != nil {
+		return err
+	}
+	if _, err := ing.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil && ing.debug {
+		fmt.Fprintf(os.Stderr, "[INGESTER] CHECKPOINT-ERR %v\n", err)
+	}
+	if ing.debug {
+		fmt.Fprintf(os.Stderr, "[INGESTER] PAUSED files=%d dirs=%d\n",
+			atomic.LoadInt64(&ing.fileCount), atomic.LoadInt64(&ing.dirCount))
+	}
+	// A cancelled context is handled by the main loop
+	ing.gate.Wait(ctx)
+	if ing.debug {
+		fmt.Fprintf(os.Stderr, "[INGESTER] RESUMED\n")
+	}
+	return nil
+}
+
 func (ing *Ingester) flush() error {
 	if err := ing.flushDirs(); err != nil {
 		return err
 	}
+	if err := ing.flushTimeouts(); err != nil {
+		return err
+	}
 	if err := ing.flushEntries(); err != nil {
 		return err
 	}
@@ -282,7 +329,7 @@ func (ing *Ingester) flushRollups() error {
 
 	stmt := tx.Stmt(ing.rollupStmt)
 	for _, r := range ing.rollupBatch {
-		_, err := stmt.Exec(r.DirID, r.TotalSize, r.TotalBlocks, r.TotalFiles, r.TotalDirs)
+		_, err := stmt.Exec(r.DirID, r.TotalSize, r.TotalBlocks, r.TotalFiles, r.TotalDirs, r.TotalSlack, r.TotalSparse)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to insert rollup %d: %w", r.DirID, err)
@@ -324,7 +371,7 @@ func (ing *Ingester) flushErrors() error {
 
 	stmt := tx.Stmt(ing.errorStmt)
 	for _, e := range ing.errorBatch {
-		_, err := stmt.Exec(e.Path, e.Message)
+		_, err := stmt.Exec(e.Path, e.Message, e.TimedOut)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to insert error for %q: %w", e.Path, err)
@@ -351,7 +398,11 @@ func (ing *Ingester) flushDirs() error {
 
 	stmt := tx.Stmt(ing.dirStmt)
 	for _, d := range ing.dirBatch {
-		_, err := stmt.Exec(d.ID, d.Path, d.Name, d.ParentID, d.Depth)
+		kind := entry.KindDir
+		if d.Archive {
+			kind = entry.KindArchive
+		}
+		_, err := stmt.Exec(d.ID, d.Name, d.ParentID, d.Depth, kind)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to insert dir %q: %w", d.Path, err)
@@ -365,3 +416,24 @@ func (ing *Ingester) flushDirs() error {
 	ing.dirBatch = ing.dirBatch[:0]
 	return nil
 }
+
+// flushTimeouts marks timed-out directories in the dirs table. A directory
+// whose row has not been flushed yet stays pending until a later flush.
+func (ing *Ingester) flushTimeouts() error {
+	if len(ing.timedOutDirs) == 0 {
+		return nil
+	}
+
+	pending := ing.timedOutDirs[:0]
+	for _, id := range ing.timedOutDirs {
+		res, err := ing.db.Exec(markTimedOutSQL, id)
+		if err != nil {
+			return fmt.Errorf("failed to mark dir %d timed out: %w", id, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			pending = append(pending, id)
+		}
+	}
+	ing.timedOutDirs = pending
+	return nil
+}
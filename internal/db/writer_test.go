@@ -56,3 +56,45 @@ func TestIngesterCancelsOnMaxErrors(t *testing.T) {
 		t.Fatalf("expected error count 1, got %d", ing.ErrorCount())
 	}
 }
+
+func TestIngesterMarksTimedOutDirs(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+
+	entryCh := make(chan entry.Entry)
+	dirCh := make(chan entry.Dir, 1)
+	rollupCh := make(chan entry.Rollup)
+	errorCh := make(chan entry.ScanError, 1)
+
+	// The timeout arrives before the directory row; it must still be applied.
+	errorCh <- entry.ScanError{Path: "/root/slow", Message: "readdir timed out", DirID: 2, TimedOut: true}
+	close(errorCh)
+	dirCh <- entry.Dir{ID: 2, Path: "/root/slow", Name: "slow", ParentID: 1, Depth: 1}
+	close(dirCh)
+	close(entryCh)
+	close(rollupCh)
+
+	ing := NewIngester(database, entryCh, dirCh, rollupCh, errorCh, 10, 10, 0, false, nil)
+	if err := ing.Run(context.Background()); err != nil {
+		t.Fatalf("ingester error: %v", err)
+	}
+
+	var dirFlag, errFlag int
+	if err := database.QueryRow(`SELECT timed_out FROM dirs WHERE id = 2`).Scan(&dirFlag); err != nil {
+		t.Fatalf("query dir: %v", err)
+	}
+	if err := database.QueryRow(`SELECT timed_out FROM scan_errors`).Scan(&errFlag); err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	if dirFlag != 1 || errFlag != 1 {
+		t.Fatalf("expected dir and error flagged, got dir=%d error=%d", dirFlag, errFlag)
+	}
+}
@@ -0,0 +1,278 @@
+// Package dupes finds duplicate files in a snapshot. Only files sharing a
+// size with another file are read: first a partial hash of their head and
+// tail, then a full hash of those whose partial hashes collide.
+package dupes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/entry"
+	"github.com/michaelscutari/dug/internal/throttle"
+)
+
+// Options configures a duplicate search.
+type Options struct {
+	Workers int
+	MinSize int64
+
+	// Limiter admits each file opened; Bandwidth admits each ChunkSize
+	// bytes read. Either may be nil for no limit.
+	Limiter   *throttle.Limiter
+	Bandwidth *throttle.Limiter
+
+	// StageFunc is called as each hashing stage starts with the number of
+	// files it will read.
+	StageFunc func(stage string, files int)
+}
+
+// DefaultOptions returns sensible defaults for a duplicate search.
+func DefaultOptions() *Options {
+	return &Options{
+		Workers: 4,
+		MinSize: 1,
+	}
+}
+
+// WithWorkers sets how many files are hashed at once.
+func (o *Options) WithWorkers(n int) *Options {
+	o.Workers = n
+	return o
+}
+
+// WithMinSize skips files smaller than n bytes.
+func (o *Options) WithMinSize(n int64) *Options {
+	o.MinSize = n
+	return o
+}
+
+// WithLimiter sets the files-per-second limiter.
+func (o *Options) WithLimiter(l *throttle.Limiter) *Options {
+	o.Limiter = l
+	return o
+}
+
+// WithBandwidth sets the limiter taking one token per ChunkSize bytes read.
+func (o *Options) WithBandwidth(l *throttle.Limiter) *Options {
+	o.Bandwidth = l
+	return o
+}
+
+// WithStageFunc sets the stage callback.
+func (o *Options) WithStageFunc(f func(stage string, files int)) *Options {
+	o.StageFunc = f
+	return o
+}
+
+// File is one candidate: a distinct (dev, inode) with the paths linking to it.
+type File struct {
+	EntryID int64
+	Path    string
+	Size    int64
+	Blocks  int64
+	MTime   int64
+	DevID   int64
+	Inode   int64
+
+	// Links counts other paths in the snapshot that are hardlinks to this
+	// file. They share its storage, so they are neither duplicates nor
+	// reclaimable.
+	Links int
+
+	partial string
+	full    string
+}
+
+// Set is a group of files with identical content.
+type Set struct {
+	Size        int64
+	Hash        string
+	Files       []File
+	Reclaimable int64 // disk usage freed by keeping one copy
+}
+
+// Report is the result of a duplicate search.
+type Report struct {
+	Sets        []Set
+	Reclaimable int64
+	Candidates  int // files sharing a size with another file
+	Hashed      int // files read in this run, once per stage; the rest came from file_hashes
+	Skipped     int // files unreadable or changed since the scan
+}
+
+// Find searches the snapshot in database for duplicate files and stores the
+// hashes it computes in the file_hashes table, so later runs only hash
+// files they haven't seen. Hashes finished before ctx is canceled are kept.
+func Find(ctx context.Context, database *sql.DB, opts *Options) (*Report, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if _, err := database.Exec(fileHashesTableDDL); err != nil {
+		return nil, fmt.Errorf("failed to create file_hashes table: %w", err)
+	}
+
+	groups, err := loadCandidates(ctx, database, opts.MinSize)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	for _, g := range groups {
+		report.Candidates += len(g)
+	}
+	if err := loadHashes(ctx, database, groups); err != nil {
+		return nil, err
+	}
+
+	h := &hasher{opts: opts, report: report}
+
+	// Partial hashes separate most same-size files cheaply
+	var need []*File
+	for _, g := range groups {
+		for i := range g {
+			if g[i].partial == "" {
+				need = append(need, &g[i])
+			}
+		}
+	}
+	h.stage("partial", len(need))
+	runErr := h.run(ctx, need, partialHash)
+	if err := storeHashes(context.WithoutCancel(ctx), database, need); err != nil {
+		return nil, err
+	}
+	if runErr != nil {
+		return nil, runErr
+	}
+	groups = regroup(groups, func(f *File) string { return f.partial })
+
+	// Full hashes settle the rest
+	need = need[:0]
+	for _, g := range groups {
+		for i := range g {
+			if g[i].full == "" {
+				need = append(need, &g[i])
+			}
+		}
+	}
+	h.stage("full", len(need))
+	runErr = h.run(ctx, need, fullHash)
+	if err := storeHashes(context.WithoutCancel(ctx), database, need); err != nil {
+		return nil, err
+	}
+	if runErr != nil {
+		return nil, runErr
+	}
+	groups = regroup(groups, func(f *File) string { return f.full })
+
+	for _, g := range groups {
+		set := Set{Size: g[0].Size, Hash: g[0].full, Files: g}
+		sort.Slice(set.Files, func(i, j int) bool { return set.Files[i].Path < set.Files[j].Path })
+		for _, f := range g[1:] {
+			set.Reclaimable += f.Blocks
+		}
+		report.Reclaimable += set.Reclaimable
+		report.Sets = append(report.Sets, set)
+	}
+	sort.Slice(report.Sets, func(i, j int) bool {
+		if report.Sets[i].Reclaimable != report.Sets[j].Reclaimable {
+			return report.Sets[i].Reclaimable > report.Sets[j].Reclaimable
+		}
+		return report.Sets[i].Files[0].Path < report.Sets[j].Files[0].Path
+	})
+	return report, nil
+}
+
+// loadCandidates returns regular files grouped by size, keeping only sizes
+// shared by at least two distinct (dev, inode) pairs. Hardlinks collapse
+// onto one File.
+func loadCandidates(ctx context.Context, database *sql.DB, minSize int64) ([][]File, error) {
+	rows, err := database.QueryContext(ctx, `
+		SELECT e.id, e.parent_id, e.name, e.size, e.blocks, e.mtime, e.dev_id, e.inode
+		FROM entries e
+		WHERE e.kind = ? AND e.size >= ? AND e.size IN (
+			SELECT size FROM entries WHERE kind = ? AND size >= ?
+			GROUP BY size HAVING COUNT(DISTINCT dev_id || ':' || inode) > 1
+		)
+		ORDER BY e.size DESC, e.dev_id, e.inode, e.parent_id, e.name`,
+		entry.KindFile, max(minSize, 1), entry.KindFile, max(minSize, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query candidates: %w", err)
+	}
+	defer rows.Close()
+
+	// Paths are resolved once the rows are read, since the database may
+	// allow a single connection
+	type candidate struct {
+		file  File
+		dirID int64
+		name  string
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		f := &c.file
+		if err := rows.Scan(&f.EntryID, &c.dirID, &c.name, &f.Size, &f.Blocks, &f.MTime, &f.DevID, &f.Inode); err != nil {
+			return nil, fmt.Errorf("failed to read candidate: %w", err)
+		}
+		candidates = append(candidates, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read candidates: %w", err)
+	}
+	rows.Close()
+
+	var groups [][]File
+	var cur []File
+	for _, c := range candidates {
+		f := c.file
+		if len(cur) > 0 && cur[0].Size != f.Size {
+			groups = appendGroup(groups, cur)
+			cur = nil
+		}
+		if n := len(cur); n > 0 && cur[n-1].DevID == f.DevID && cur[n-1].Inode == f.Inode {
+			cur[n-1].Links++
+			continue
+		}
+		dir, err := db.DirPath(database, c.dirID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve directory of %s: %w", c.name, err)
+		}
+		f.Path = filepath.Join(dir, c.name)
+		cur = append(cur, f)
+	}
+	return appendGroup(groups, cur), nil
+}
+
+func appendGroup(groups [][]File, g []File) [][]File {
+	if len(g) > 1 {
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// regroup splits each group by key, dropping files without one (skipped)
+// and groups left with a single file.
+func regroup(groups [][]File, key func(*File) string) [][]File {
+	var out [][]File
+	for _, g := range groups {
+		byKey := make(map[string][]File)
+		var order []string
+		for i := range g {
+			k := key(&g[i])
+			if k == "" {
+				continue
+			}
+			if _, ok := byKey[k]; !ok {
+				order = append(order, k)
+			}
+			byKey[k] = append(byKey[k], g[i])
+		}
+		for _, k := range order {
+			out = appendGroup(out, byKey[k])
+		}
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package dupes
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/scan"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	root := t.TempDir()
+	write := func(name string, data []byte) {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	big := bytes.Repeat([]byte("genome"), 20000)
+	write("a/ref.fa", big)
+	write("b/ref.fa", big)
+	if err := os.Link(filepath.Join(root, "a/ref.fa"), filepath.Join(root, "a/ref-link.fa")); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	// Same size, head and tail as ref.fa but a different middle
+	other := bytes.Clone(big)
+	other[len(other)/2] = 'X'
+	write("c/ref.fa", other)
+	write("small1", []byte("hello"))
+	write("small2", []byte("hello"))
+	write("unique", []byte("world!"))
+
+	database, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "snap.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+	if err := db.InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	if err := scan.NewScanner(scan.DefaultOptions().WithWorkers(2)).Run(context.Background(), root, database); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	report, err := Find(context.Background(), database, DefaultOptions())
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if len(report.Sets) != 2 {
+		t.Fatalf("expected 2 duplicate sets, got %+v", report.Sets)
+	}
+	set := report.Sets[0]
+	if set.Size != int64(len(big)) || len(set.Files) != 2 {
+		t.Fatalf("unexpected largest set: %+v", set)
+	}
+	if set.Files[0].Links != 1 || set.Files[1].Path != filepath.Join(root, "b/ref.fa") {
+		t.Fatalf("expected a/ref.fa with one hardlink and b/ref.fa, got %+v", set.Files)
+	}
+	if set.Reclaimable != set.Files[1].Blocks {
+		t.Fatalf("expected one copy reclaimable, got %d", set.Reclaimable)
+	}
+
+	// A second run reuses the stored hashes
+	again, err := Find(context.Background(), database, DefaultOptions())
+	if err != nil {
+		t.Fatalf("find again: %v", err)
+	}
+	if again.Hashed != 0 || len(again.Sets) != 2 {
+		t.Fatalf("expected cached result, hashed %d files and found %d sets", again.Hashed, len(again.Sets))
+	}
+}
@@ -0,0 +1,251 @@
+package dupes
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// EdgeSize is how much of each end of a file the partial hash reads.
+	// Files up to twice this size are hashed whole in the partial stage.
+	EdgeSize = 16 << 10
+
+	// ChunkSize is the read size of full hashes and the unit of the
+	// bandwidth limiter.
+	ChunkSize = 1 << 20
+)
+
+const fileHashesTableDDL = `
+CREATE TABLE IF NOT EXISTS file_hashes (
+    entry_id INTEGER PRIMARY KEY,
+    partial_hash TEXT NOT NULL,
+    full_hash TEXT,
+    hashed_at INTEGER NOT NULL
+);
+`
+
+// errChanged means the file on disk no longer matches the snapshot.
+var errChanged = errors.New("file changed since scan")
+
+// hasher runs hash functions over files with bounded concurrency.
+type hasher struct {
+	opts    *Options
+	report  *Report
+	hashed  atomic.Int64
+	skipped atomic.Int64
+}
+
+func (h *hasher) stage(name string, files int) {
+	if h.opts.StageFunc != nil {
+		h.opts.StageFunc(name, files)
+	}
+}
+
+// run hashes files with opts.Workers goroutines. A file that can't be
+// hashed is counted as skipped and keeps an empty hash, which drops it
+// from its group.
+func (h *hasher) run(ctx context.Context, files []*File, fn func(ctx context.Context, h *hasher, f *File) error) error {
+	workers := max(h.opts.Workers, 1)
+	work := make(chan *File)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range work {
+				if err := fn(ctx, h, f); err != nil {
+					if ctx.Err() == nil {
+						h.skipped.Add(1)
+					}
+					continue
+				}
+				h.hashed.Add(1)
+			}
+		}()
+	}
+
+feed:
+	for _, f := range files {
+		select {
+		case work <- f:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	h.report.Hashed = int(h.hashed.Load())
+	h.report.Skipped = int(h.skipped.Load())
+	return ctx.Err()
+}
+
+// open opens f after checking it still matches the snapshot.
+func (h *hasher) open(ctx context.Context, f *File) (*os.File, error) {
+	if err := h.opts.Limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	fd, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	if !st.Mode().IsRegular() || st.Size() != f.Size || st.ModTime().Unix() != f.MTime {
+		fd.Close()
+		return nil, errChanged
+	}
+	return fd, nil
+}
+
+// partialHash hashes the first and last EdgeSize bytes, along with the
+// size. Small files are read whole, so their partial hash is also their
+// full hash.
+func partialHash(ctx context.Context, h *hasher, f *File) error {
+	fd, err := h.open(ctx, f)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if f.Size <= 2*EdgeSize {
+		sum, err := h.hashAll(ctx, fd)
+		if err != nil {
+			return err
+		}
+		f.partial, f.full = sum, sum
+		return nil
+	}
+
+	if err := h.opts.Bandwidth.Wait(ctx); err != nil {
+		return err
+	}
+	buf := make([]byte, 2*EdgeSize)
+	if _, err := fd.ReadAt(buf[:EdgeSize], 0); err != nil {
+		return err
+	}
+	if _, err := fd.ReadAt(buf[EdgeSize:], f.Size-EdgeSize); err != nil {
+		return err
+	}
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%d:", f.Size)
+	sum.Write(buf)
+	f.partial = hex.EncodeToString(sum.Sum(nil))
+	return nil
+}
+
+// fullHash hashes the whole file.
+func fullHash(ctx context.Context, h *hasher, f *File) error {
+	fd, err := h.open(ctx, f)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	sum, err := h.hashAll(ctx, fd)
+	if err != nil {
+		return err
+	}
+	f.full = sum
+	return nil
+}
+
+// hashAll reads r to the end in ChunkSize pieces, taking one bandwidth
+// token per piece.
+func (h *hasher) hashAll(ctx context.Context, r io.Reader) (string, error) {
+	sum := sha256.New()
+	buf := make([]byte, ChunkSize)
+	for {
+		if err := h.opts.Bandwidth.Wait(ctx); err != nil {
+			return "", err
+		}
+		n, err := io.ReadFull(r, buf)
+		sum.Write(buf[:n])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return hex.EncodeToString(sum.Sum(nil)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// loadHashes fills in hashes stored by earlier runs.
+func loadHashes(ctx context.Context, database *sql.DB, groups [][]File) error {
+	rows, err := database.QueryContext(ctx, `SELECT entry_id, partial_hash, COALESCE(full_hash, '') FROM file_hashes`)
+	if err != nil {
+		return fmt.Errorf("failed to load stored hashes: %w", err)
+	}
+	defer rows.Close()
+
+	type hashes struct{ partial, full string }
+	stored := make(map[int64]hashes)
+	for rows.Next() {
+		var id int64
+		var hs hashes
+		if err := rows.Scan(&id, &hs.partial, &hs.full); err != nil {
+			return fmt.Errorf("failed to load stored hashes: %w", err)
+		}
+		stored[id] = hs
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load stored hashes: %w", err)
+	}
+
+	for _, g := range groups {
+		for i := range g {
+			if hs, ok := stored[g[i].EntryID]; ok {
+				g[i].partial, g[i].full = hs.partial, hs.full
+			}
+		}
+	}
+	return nil
+}
+
+// storeHashes records the hashes computed for files.
+func storeHashes(ctx context.Context, database *sql.DB, files []*File) error {
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO file_hashes (entry_id, partial_hash, full_hash, hashed_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(entry_id) DO UPDATE SET
+			partial_hash = excluded.partial_hash,
+			full_hash = COALESCE(excluded.full_hash, file_hashes.full_hash),
+			hashed_at = excluded.hashed_at`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare hash insert: %w", err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	for _, f := range files {
+		if f.partial == "" {
+			continue
+		}
+		full := sql.NullString{String: f.full, Valid: f.full != ""}
+		if _, err := stmt.ExecContext(ctx, f.EntryID, f.partial, full, now); err != nil {
+			return fmt.Errorf("failed to store hash: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit hashes: %w", err)
+	}
+	return nil
+}
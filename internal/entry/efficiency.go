@@ -0,0 +1,54 @@
+package entry
+
+// Efficiency classifies how a file's disk usage compares to its apparent size.
+type Efficiency uint8
+
+const (
+	// EfficiencyNormal files use about as much disk as their size.
+	EfficiencyNormal Efficiency = 0
+	// EfficiencySparse files use less than half their size on disk, from
+	// holes (VM images, core dumps) or filesystem compression.
+	EfficiencySparse Efficiency = 1
+	// EfficiencyOverhead files use more than twice their size on disk,
+	// typically small files rounded up to large filesystem blocks.
+	EfficiencyOverhead Efficiency = 2
+)
+
+func (e Efficiency) String() string {
+	switch e {
+	case EfficiencySparse:
+		return "sparse"
+	case EfficiencyOverhead:
+		return "overhead"
+	default:
+		return "normal"
+	}
+}
+
+// Classify returns the efficiency of size apparent bytes stored in blocks
+// bytes of disk. It applies equally to one file or to a directory's totals.
+func Classify(size, blocks int64) Efficiency {
+	switch {
+	case size > 0 && blocks*2 < size:
+		return EfficiencySparse
+	case blocks > 2*size && blocks > 0:
+		return EfficiencyOverhead
+	default:
+		return EfficiencyNormal
+	}
+}
+
+// Slack is the disk usage beyond a file's apparent size.
+func Slack(size, blocks int64) int64 {
+	return max(blocks-size, 0)
+}
+
+// SparseSavings is the apparent size not backed by disk.
+func SparseSavings(size, blocks int64) int64 {
+	return max(size-blocks, 0)
+}
+
+// Efficiency classifies the entry's disk usage against its size.
+func (e Entry) Efficiency() Efficiency {
+	return Classify(e.Size, e.Blocks)
+}
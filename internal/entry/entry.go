@@ -13,6 +13,12 @@ const (
 	KindDir     Kind = 1
 	KindSymlink Kind = 2
 	KindOther   Kind = 3
+
+	// KindArchive marks a virtual directory listing the contents of a tar or
+	// zip archive (the archive itself, or a directory inside it).
+	KindArchive Kind = 4
+	// KindArchiveMember marks a file listed from inside an archive.
+	KindArchiveMember Kind = 5
 )
 
 func (k Kind) String() string {
@@ -23,11 +29,20 @@ func (k Kind) String() string {
 		return "dir"
 	case KindSymlink:
 		return "symlink"
+	case KindArchive:
+		return "archive"
+	case KindArchiveMember:
+		return "member"
 	default:
 		return "other"
 	}
 }
 
+// IsContainer reports whether entries of this kind can be browsed into.
+func (k Kind) IsContainer() bool {
+	return k == KindDir || k == KindArchive
+}
+
 // KindFromMode derives the Kind from an os.FileMode.
 func KindFromMode(mode os.FileMode) Kind {
 	switch {
@@ -61,12 +76,17 @@ type Dir struct {
 	Name     string
 	ParentID int64
 	Depth    int
+	// Archive marks a virtual directory listed from inside an archive.
+	Archive bool
 }
 
 // ScanError represents an error encountered during scanning.
 type ScanError struct {
 	Path    string
 	Message string
+	// DirID identifies the directory left incomplete when TimedOut is set.
+	DirID    int64
+	TimedOut bool
 }
 
 // Rollup represents aggregated statistics for a directory.
@@ -76,6 +96,8 @@ type Rollup struct {
 	TotalBlocks int64 // Disk usage
 	TotalFiles  int64
 	TotalDirs   int64
+	TotalSlack  int64 // Disk usage beyond files' apparent size
+	TotalSparse int64 // Apparent size not backed by disk
 }
 
 // ScanMeta holds metadata about a scan.
@@ -0,0 +1,520 @@
+// Package merge combines snapshot databases into one.
+package merge
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/pathutil"
+)
+
+// sourceMeta is the scan_meta row of one input snapshot.
+type sourceMeta struct {
+	path        string
+	root        string
+	startTime   int64
+	endTime     int64
+	totalSize   int64
+	totalBlocks int64
+	fileCount   int64
+	dirCount    int64
+	errorCount  int64
+	memoryLimit int64
+	peakRSS     int64
+	shardIndex  int64
+	shardCount  int64
+}
+
+// rootTotals accumulates the rollups of the inputs' root directories.
+type rootTotals struct {
+	size, blocks, files, dirs, slack, sparse int64
+}
+
+// mergedRoot is an input's root directory as placed in the merged snapshot.
+type mergedRoot struct {
+	dirID int64
+	path  string
+	devID int64
+}
+
+// merger carries state across the inputs of one Merge.
+type merger struct {
+	conn *sql.Conn
+
+	// shards is set when the inputs are shards of one root, whose root
+	// directories fold into rootID. Otherwise each input's root becomes a
+	// child of the synthetic top directory topID (0 for a single input).
+	shards bool
+	rootID int64
+	topID  int64
+	top    string
+
+	totals rootTotals
+	roots  []mergedRoot
+}
+
+// Merge copies the input snapshots into database, whose schema must already
+// exist. Directory IDs are offset per input so they never collide.
+//
+// Shards written by `dug scan --shard` must all be present; their root
+// directories become one and the root rollup is the sum of theirs. Any
+// other inputs must have disjoint roots, which are placed under a synthetic
+// top directory at their common ancestor, like a multi-root scan. Either
+// way each input's scan_meta is kept in the sources table.
+func Merge(ctx context.Context, database *sql.DB, inputs []string) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("no snapshots to merge")
+	}
+
+	// ATTACH is per connection, so everything runs on one
+	conn, err := database.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	metas := make([]sourceMeta, len(inputs))
+	for i, path := range inputs {
+		if metas[i], err = readMeta(ctx, conn, path); err != nil {
+			return err
+		}
+	}
+
+	m := &merger{conn: conn}
+	if m.shards, err = checkInputs(metas); err != nil {
+		return err
+	}
+	if !m.shards && len(metas) > 1 {
+		if err := m.createTop(ctx, metas); err != nil {
+			return err
+		}
+	}
+
+	for i, meta := range metas {
+		if err := m.copySnapshot(ctx, meta, i == 0); err != nil {
+			return err
+		}
+	}
+	return m.finish(ctx, metas)
+}
+
+// checkInputs reports whether the inputs are shards to stitch at a shared
+// root, and rejects sets that would count the same files twice or silently
+// miss some: incomplete shard sets and overlapping roots.
+func checkInputs(metas []sourceMeta) (bool, error) {
+	first := metas[0]
+	if first.shardCount < 2 {
+		for i, a := range metas {
+			if a.shardCount > 1 {
+				return false, fmt.Errorf("%s is a shard and can only be merged with its sibling shards", a.path)
+			}
+			for _, b := range metas[:i] {
+				if pathutil.IsWithin(a.root, b.root) || pathutil.IsWithin(b.root, a.root) {
+					return false, fmt.Errorf("%s (%s) overlaps %s (%s)", a.path, a.root, b.path, b.root)
+				}
+			}
+		}
+		return false, nil
+	}
+
+	seen := make(map[int64]string, len(metas))
+	for _, m := range metas {
+		if m.root != first.root {
+			return false, fmt.Errorf("%s has root %s, expected %s", m.path, m.root, first.root)
+		}
+		if m.shardCount != first.shardCount {
+			return false, fmt.Errorf("%s is shard %d/%d, expected a shard of %d", m.path, m.shardIndex, m.shardCount, first.shardCount)
+		}
+		if prev, ok := seen[m.shardIndex]; ok {
+			return false, fmt.Errorf("shard %d appears twice: %s and %s", m.shardIndex, prev, m.path)
+		}
+		seen[m.shardIndex] = m.path
+	}
+
+	var missing []string
+	for i := int64(0); i < first.shardCount; i++ {
+		if _, ok := seen[i]; !ok {
+			missing = append(missing, fmt.Sprint(i))
+		}
+	}
+	if len(missing) > 0 {
+		return false, fmt.Errorf("missing shards %s of %d", strings.Join(missing, ", "), first.shardCount)
+	}
+	return true, nil
+}
+
+// readMeta reads an input's scan_meta. Columns added in later versions read
+// as zero for older snapshots.
+func readMeta(ctx context.Context, conn *sql.Conn, path string) (sourceMeta, error) {
+	if err := attach(ctx, conn, path); err != nil {
+		return sourceMeta{}, err
+	}
+	defer detach(ctx, conn)
+
+	// Merging copies columns it knows, which would silently drop newer ones
+	var version int
+	if err := conn.QueryRowContext(ctx, `PRAGMA src.user_version`).Scan(&version); err != nil {
+		return sourceMeta{}, fmt.Errorf("failed to read schema version of %s: %w", path, err)
+	}
+	if version > db.SchemaVersion {
+		return sourceMeta{}, fmt.Errorf("%s has schema v%d, newer than this dug (v%d); upgrade dug to merge it", path, version, db.SchemaVersion)
+	}
+
+	cols, err := columns(ctx, conn, "src", "scan_meta")
+	if err != nil {
+		return sourceMeta{}, err
+	}
+	if len(cols) == 0 {
+		return sourceMeta{}, fmt.Errorf("%s is not a dug snapshot (no scan_meta)", path)
+	}
+	col := func(name string) string {
+		if cols[name] {
+			return "COALESCE(" + name + ", 0)"
+		}
+		return "0"
+	}
+
+	m := sourceMeta{path: path}
+	query := fmt.Sprintf(`SELECT root_path, start_time, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s FROM src.scan_meta WHERE id = 1`,
+		col("end_time"), col("total_size"), col("total_blocks"), col("file_count"), col("dir_count"),
+		col("error_count"), col("memory_limit"), col("peak_rss"), col("shard_index"), col("shard_count"))
+	err = conn.QueryRowContext(ctx, query).Scan(&m.root, &m.startTime, &m.endTime, &m.totalSize, &m.totalBlocks,
+		&m.fileCount, &m.dirCount, &m.errorCount, &m.memoryLimit, &m.peakRSS, &m.shardIndex, &m.shardCount)
+	if err != nil {
+		return sourceMeta{}, fmt.Errorf("failed to read scan metadata from %s: %w", path, err)
+	}
+	if m.endTime == 0 {
+		return sourceMeta{}, fmt.Errorf("%s is from a scan that never finished", path)
+	}
+	return m, nil
+}
+
+// createTop inserts the synthetic top directory the inputs' roots hang off.
+func (m *merger) createTop(ctx context.Context, metas []sourceMeta) error {
+	roots := make([]string, len(metas))
+	for i, meta := range metas {
+		roots[i] = meta.root
+	}
+	m.top = pathutil.CommonAncestor(roots)
+	m.topID = 1
+
+	_, err := m.conn.ExecContext(ctx, `INSERT INTO dirs (id, name, parent_id, depth) VALUES (?, ?, 0, 0)`, m.topID, m.top)
+	if err != nil {
+		return fmt.Errorf("failed to create top directory: %w", err)
+	}
+	return nil
+}
+
+// copySnapshot appends one input in a single transaction.
+func (m *merger) copySnapshot(ctx context.Context, meta sourceMeta, first bool) error {
+	if err := attach(ctx, m.conn, meta.path); err != nil {
+		return err
+	}
+	defer detach(ctx, m.conn)
+
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin merge transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var offset, srcRoot int64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM main.dirs`).Scan(&offset); err != nil {
+		return fmt.Errorf("failed to read directory IDs: %w", err)
+	}
+	err = tx.QueryRowContext(ctx, `SELECT id FROM src.dirs WHERE parent_id IS NULL OR parent_id = 0 ORDER BY id LIMIT 1`).Scan(&srcRoot)
+	if err != nil {
+		return fmt.Errorf("failed to find root directory in %s: %w", meta.path, err)
+	}
+
+	rollupCols, err := columns(ctx, tx, "src", "rollups")
+	if err != nil {
+		return err
+	}
+	efficiency := "0, 0"
+	if rollupCols["total_slack"] {
+		efficiency = "total_slack, total_sparse"
+	}
+	var r rootTotals
+	err = tx.QueryRowContext(ctx, `SELECT total_size, total_blocks, total_files, total_dirs, `+efficiency+` FROM src.rollups WHERE dir_id = ?`, srcRoot).
+		Scan(&r.size, &r.blocks, &r.files, &r.dirs, &r.slack, &r.sparse)
+	if err != nil {
+		return fmt.Errorf("failed to read root rollup from %s: %w", meta.path, err)
+	}
+
+	// Directory IDs shift by offset. Zero and NULL mean "no parent": the
+	// input's root, which hangs off the synthetic top when there is one.
+	// Shards' roots all map onto the first shard's.
+	dstRoot := srcRoot + offset
+	if m.shards {
+		if first {
+			m.rootID = dstRoot
+		}
+		dstRoot = m.rootID
+	}
+	remap := func(col string) string {
+		return fmt.Sprintf("CASE WHEN %[1]s = %[2]d THEN %[3]d WHEN %[1]s IS NULL OR %[1]s = 0 THEN %[4]d ELSE %[1]s + %[5]d END",
+			col, srcRoot, dstRoot, m.topID, offset)
+	}
+	// The root is named by its absolute path, or by its path relative to
+	// the synthetic top; older inputs name it by its base name
+	depth := "depth"
+	rootName := meta.root
+	if m.topID != 0 {
+		depth = "depth + 1"
+		rootName, _ = filepath.Rel(m.top, meta.root)
+	}
+	name := fmt.Sprintf("CASE WHEN id = %d THEN %s ELSE name END", srcRoot, quote(rootName))
+
+	copies := []tableCopy{
+		{table: "dirs", exprs: map[string]string{"id": remap("id"), "parent_id": remap("parent_id"), "depth": depth, "name": name}},
+		{table: "entries", skip: "id", exprs: map[string]string{"parent_id": remap("parent_id")}},
+		{table: "rollups", exprs: map[string]string{"dir_id": remap("dir_id")}},
+		{table: "scan_errors", skip: "id"},
+		{table: "scan_events", skip: "id"},
+		{table: "scan_concurrency"},
+	}
+	switch {
+	case m.shards:
+		// Only the first shard's root row is kept, and the shared root's
+		// rollup is written once all shards are in
+		if !first {
+			copies[0].where = fmt.Sprintf("id != %d", srcRoot)
+		}
+		copies[2].where = fmt.Sprintf("dir_id != %d", srcRoot)
+		copies = append(copies, tableCopy{table: "scan_roots", exprs: map[string]string{"dir_id": remap("dir_id")}, orIgnore: true})
+	case m.topID == 0:
+		copies = append(copies, tableCopy{table: "scan_roots"})
+	}
+	for _, c := range copies {
+		if err := c.run(ctx, tx); err != nil {
+			return fmt.Errorf("failed to merge %s from %s: %w", c.table, meta.path, err)
+		}
+	}
+
+	if m.topID != 0 {
+		r.dirs++ // the input's root is a directory of the top
+		m.roots = append(m.roots, mergedRoot{dirID: dstRoot, path: meta.root, devID: rootDev(ctx, tx, meta.root)})
+	}
+	m.totals.size += r.size
+	m.totals.blocks += r.blocks
+	m.totals.files += r.files
+	m.totals.dirs += r.dirs
+	m.totals.slack += r.slack
+	m.totals.sparse += r.sparse
+
+	if err := copySources(ctx, tx, meta); err != nil {
+		return fmt.Errorf("failed to record source %s: %w", meta.path, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit merge of %s: %w", meta.path, err)
+	}
+	return nil
+}
+
+// copySources records where an input came from. An input that is itself a
+// merge passes on its own sources rather than one row for itself.
+func copySources(ctx context.Context, tx *sql.Tx, meta sourceMeta) error {
+	cols, err := columns(ctx, tx, "src", "sources")
+	if err != nil {
+		return err
+	}
+	if len(cols) > 0 {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM src.sources`).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return tableCopy{table: "sources", skip: "id"}.run(ctx, tx)
+		}
+	}
+
+	// latest.db is a moving symlink; record the snapshot it pointed at
+	abs, err := filepath.EvalSymlinks(meta.path)
+	if err == nil {
+		abs, err = filepath.Abs(abs)
+	}
+	if err != nil {
+		abs = meta.path
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO main.sources (path, root_path, start_time, end_time, total_size, total_blocks, file_count, dir_count, error_count, shard_index, shard_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		abs, meta.root, meta.startTime, meta.endTime, meta.totalSize, meta.totalBlocks,
+		meta.fileCount, meta.dirCount, meta.errorCount, meta.shardIndex, meta.shardCount)
+	return err
+}
+
+// quote returns s as an SQL string literal.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// rootDev returns the device of root as recorded by the input, or 0 for
+// snapshots that predate scan_roots.
+func rootDev(ctx context.Context, tx *sql.Tx, root string) int64 {
+	var dev int64
+	tx.QueryRowContext(ctx, `SELECT dev_id FROM src.scan_roots WHERE path = ?`, root).Scan(&dev)
+	return dev
+}
+
+// tableCopy copies the columns a table has in both databases, rewriting
+// some through SQL expressions.
+type tableCopy struct {
+	table    string
+	skip     string            // column left to its default (autoincrement IDs)
+	exprs    map[string]string // column -> expression over the source row
+	where    string
+	orIgnore bool
+}
+
+func (c tableCopy) run(ctx context.Context, tx *sql.Tx) error {
+	dst, err := columns(ctx, tx, "main", c.table)
+	if err != nil {
+		return err
+	}
+	src, err := columns(ctx, tx, "src", c.table)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range dst {
+		if src[name] && name != c.skip {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil // table predates this input's version
+	}
+	sort.Strings(names)
+
+	selects := make([]string, len(names))
+	for i, name := range names {
+		selects[i] = name
+		if expr, ok := c.exprs[name]; ok {
+			selects[i] = expr
+		}
+	}
+
+	verb := "INSERT"
+	if c.orIgnore {
+		verb = "INSERT OR IGNORE"
+	}
+	query := fmt.Sprintf("%s INTO main.%s (%s) SELECT %s FROM src.%s", verb, c.table, strings.Join(names, ", "), strings.Join(selects, ", "), c.table)
+	if c.where != "" {
+		query += " WHERE " + c.where
+	}
+	_, err = tx.ExecContext(ctx, query)
+	return err
+}
+
+// finish writes the rollup of the stitched root or synthetic top, per-root
+// totals, and scan_meta summed over the inputs.
+func (m *merger) finish(ctx context.Context, metas []sourceMeta) error {
+	if id := max(m.rootID, m.topID); id != 0 {
+		_, err := m.conn.ExecContext(ctx,
+			`INSERT INTO rollups (dir_id, total_size, total_blocks, total_files, total_dirs, total_slack, total_sparse) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, m.totals.size, m.totals.blocks, m.totals.files, m.totals.dirs, m.totals.slack, m.totals.sparse)
+		if err != nil {
+			return fmt.Errorf("failed to write root rollup: %w", err)
+		}
+	}
+
+	for _, r := range m.roots {
+		_, err := m.conn.ExecContext(ctx, `INSERT INTO scan_roots (dir_id, path, dev_id) VALUES (?, ?, ?)`, r.dirID, r.path, r.devID)
+		if err != nil {
+			return fmt.Errorf("failed to record root %s: %w", r.path, err)
+		}
+	}
+	_, err := m.conn.ExecContext(ctx, `
+		UPDATE scan_roots SET
+			total_size = COALESCE((SELECT total_size FROM rollups WHERE dir_id = scan_roots.dir_id), 0),
+			total_blocks = COALESCE((SELECT total_blocks FROM rollups WHERE dir_id = scan_roots.dir_id), 0),
+			file_count = COALESCE((SELECT total_files FROM rollups WHERE dir_id = scan_roots.dir_id), 0),
+			dir_count = COALESCE((SELECT total_dirs FROM rollups WHERE dir_id = scan_roots.dir_id), 0) + 1`)
+	if err != nil {
+		return fmt.Errorf("failed to refresh root totals: %w", err)
+	}
+
+	merged := sourceMeta{root: metas[0].root, startTime: metas[0].startTime}
+	if m.topID != 0 {
+		merged.root = m.top
+	}
+	for _, meta := range metas {
+		merged.startTime = min(merged.startTime, meta.startTime)
+		merged.endTime = max(merged.endTime, meta.endTime)
+		merged.totalSize += meta.totalSize
+		merged.totalBlocks += meta.totalBlocks
+		merged.fileCount += meta.fileCount
+		merged.dirCount += meta.dirCount
+		merged.errorCount += meta.errorCount
+		merged.memoryLimit = max(merged.memoryLimit, meta.memoryLimit)
+		merged.peakRSS = max(merged.peakRSS, meta.peakRSS)
+	}
+	if m.shards {
+		// Every shard counted the shared root directory once
+		merged.dirCount -= int64(len(metas) - 1)
+	}
+	if m.topID != 0 {
+		// An input's own synthetic top counted as no directory, but under
+		// the merged top it is one
+		merged.dirCount = m.totals.dirs
+	}
+
+	_, err = m.conn.ExecContext(ctx, `
+		INSERT INTO scan_meta (id, root_path, start_time, end_time, total_size, total_blocks, file_count, dir_count, error_count, memory_limit, peak_rss)
+		VALUES (1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		merged.root, merged.startTime, merged.endTime, merged.totalSize, merged.totalBlocks,
+		merged.fileCount, merged.dirCount, merged.errorCount, merged.memoryLimit, merged.peakRSS)
+	if err != nil {
+		return fmt.Errorf("failed to write scan metadata: %w", err)
+	}
+	return nil
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// columns returns the column names of schema.table, empty if it doesn't exist.
+func columns(ctx context.Context, q querier, schema, table string) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("PRAGMA %s.table_info(%s)", schema, table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s.%s: %w", schema, table, err)
+	}
+	defer rows.Close()
+
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
+
+func attach(ctx context.Context, conn *sql.Conn, path string) error {
+	uri, err := db.ReadOnlyURI(path)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS src`, uri); err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return nil
+}
+
+func detach(ctx context.Context, conn *sql.Conn) {
+	conn.ExecContext(ctx, `DETACH DATABASE src`)
+}
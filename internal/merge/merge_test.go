@@ -0,0 +1,134 @@
+package merge
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/scan"
+	"github.com/michaelscutari/dug/internal/source"
+
+	_ "modernc.org/sqlite"
+)
+
+func openDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	database, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	database.SetMaxOpenConns(1)
+	if err := db.InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	return database
+}
+
+func TestMergeShards(t *testing.T) {
+	mem := source.NewMemory()
+	mem.AddFile("/data/top.txt", source.Stat{Size: 1, Blocks: 4096})
+	for i := 0; i < 8; i++ {
+		mem.AddFile(fmt.Sprintf("/data/d%d/f.txt", i), source.Stat{Size: int64(10 * (i + 1)), Blocks: 4096})
+		mem.AddFile(fmt.Sprintf("/data/d%d/sub/g.txt", i), source.Stat{Size: 5, Blocks: 4096})
+	}
+
+	// '#' would end the file name in a bare SQLite URI
+	dir := filepath.Join(t.TempDir(), "scans #1")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	const shards = 3
+	var inputs []string
+	for i := 0; i < shards; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("shard-%d.db", i))
+		opts := scan.DefaultOptions().WithWorkers(2).WithSource(mem).WithShard(i, shards)
+		if err := scan.NewScanner(opts).Run(context.Background(), "/data", openDB(t, path)); err != nil {
+			t.Fatalf("scan shard %d: %v", i, err)
+		}
+		inputs = append(inputs, path)
+	}
+
+	merged := openDB(t, filepath.Join(dir, "merged.db"))
+	if err := Merge(context.Background(), merged, inputs); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+
+	root, err := db.GetRollup(merged, "/data")
+	if err != nil || root == nil {
+		t.Fatalf("root rollup: %v", err)
+	}
+	if root.TotalSize != 1+360+40 || root.TotalFiles != 17 || root.TotalDirs != 16 {
+		t.Fatalf("unexpected root rollup: %+v", root)
+	}
+
+	var dirCount, orphans int64
+	merged.QueryRow(`SELECT dir_count FROM scan_meta WHERE id = 1`).Scan(&dirCount)
+	if dirCount != 17 {
+		t.Fatalf("expected 17 dirs in scan_meta, got %d", dirCount)
+	}
+	merged.QueryRow(`SELECT COUNT(*) FROM entries e LEFT JOIN dirs d ON d.id = e.parent_id WHERE d.id IS NULL`).Scan(&orphans)
+	if orphans != 0 {
+		t.Fatalf("%d entries lost their parent directory", orphans)
+	}
+
+	children, err := db.LoadChildren(merged, "/data", "name", 20)
+	if err != nil || len(children) != 9 {
+		t.Fatalf("expected 9 children of /data, got %d (%v)", len(children), err)
+	}
+
+	if err := Merge(context.Background(), openDB(t, filepath.Join(dir, "partial.db")), inputs[:2]); err == nil {
+		t.Fatalf("expected error for a missing shard")
+	}
+}
+
+func TestMergeSeparateRoots(t *testing.T) {
+	mem := source.NewMemory()
+	mem.AddFile("/srv/nfs1/a.txt", source.Stat{Size: 10, Blocks: 4096})
+	mem.AddFile("/srv/nfs1/sub/b.txt", source.Stat{Size: 20, Blocks: 4096})
+	mem.AddFile("/srv/nfs2/c.txt", source.Stat{Size: 30, Blocks: 4096})
+
+	dir := t.TempDir()
+	var inputs []string
+	for _, root := range []string{"/srv/nfs1", "/srv/nfs2"} {
+		path := filepath.Join(dir, filepath.Base(root)+".db")
+		opts := scan.DefaultOptions().WithWorkers(2).WithSource(mem)
+		if err := scan.NewScanner(opts).Run(context.Background(), root, openDB(t, path)); err != nil {
+			t.Fatalf("scan %s: %v", root, err)
+		}
+		inputs = append(inputs, path)
+	}
+
+	merged := openDB(t, filepath.Join(dir, "merged.db"))
+	if err := Merge(context.Background(), merged, inputs); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+
+	top, err := db.GetRollup(merged, "/srv")
+	if err != nil || top == nil {
+		t.Fatalf("top rollup: %v", err)
+	}
+	if top.TotalSize != 60 || top.TotalFiles != 3 || top.TotalDirs != 3 {
+		t.Fatalf("unexpected top rollup: %+v", top)
+	}
+
+	var size, sources int64
+	if err := merged.QueryRow(`SELECT total_size FROM scan_roots WHERE path = '/srv/nfs1'`).Scan(&size); err != nil || size != 30 {
+		t.Fatalf("expected /srv/nfs1 root total 30, got %d (%v)", size, err)
+	}
+	merged.QueryRow(`SELECT COUNT(*) FROM sources`).Scan(&sources)
+	if sources != 2 {
+		t.Fatalf("expected 2 sources, got %d", sources)
+	}
+	if parent, err := db.ParentPath(merged, "/srv/nfs2"); err != nil || parent != "/srv" {
+		t.Fatalf("expected parent /srv for /srv/nfs2, got %q (%v)", parent, err)
+	}
+
+	if err := Merge(context.Background(), openDB(t, filepath.Join(dir, "dup.db")), []string{inputs[0], inputs[0]}); err == nil {
+		t.Fatalf("expected error for overlapping roots")
+	}
+}
@@ -11,3 +11,33 @@ func Normalize(path string) string {
 	}
 	return filepath.Clean(path)
 }
+
+// IsWithin reports whether path is dir or lies below it. Both must be
+// normalized.
+func IsWithin(path, dir string) bool {
+	if path == dir {
+		return true
+	}
+	if dir == string(filepath.Separator) {
+		return filepath.IsAbs(path)
+	}
+	return len(path) > len(dir) && path[:len(dir)] == dir && path[len(dir)] == filepath.Separator
+}
+
+// CommonAncestor returns the deepest directory containing every path. All
+// paths must be absolute and normalized.
+func CommonAncestor(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	ancestor := filepath.Dir(paths[0])
+	if len(paths) == 1 {
+		return ancestor
+	}
+	for _, p := range paths[1:] {
+		for !IsWithin(p, ancestor) {
+			ancestor = filepath.Dir(ancestor)
+		}
+	}
+	return ancestor
+}
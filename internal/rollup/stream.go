@@ -3,6 +3,7 @@ package rollup
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/michaelscutari/dug/internal/entry"
 )
@@ -14,7 +15,12 @@ type DirResult struct {
 	FileSize   int64
 	FileBlocks int64
 	FileCount  int64
+	FileSlack  int64
+	FileSparse int64
 	ChildCount int
+	// Detached rollups are completed on their own and never added to the
+	// parent, e.g. the virtual listing of an archive's contents.
+	Detached bool
 }
 
 // Aggregator computes rollups during scan using directory results.
@@ -25,6 +31,9 @@ type Aggregator struct {
 	expected  map[int64]int
 	completed map[int64]int
 	orphans   map[int64]*orphanAgg
+	detached  map[int64]struct{}
+
+	pending atomic.Int64
 }
 
 type orphanAgg struct {
@@ -45,6 +54,7 @@ func NewAggregator(roots []int64) *Aggregator {
 		expected:  make(map[int64]int),
 		completed: make(map[int64]int),
 		orphans:   make(map[int64]*orphanAgg),
+		detached:  make(map[int64]struct{}),
 	}
 }
 
@@ -66,10 +76,18 @@ func (a *Aggregator) Run(ctx context.Context, in <-chan DirResult, out chan<- en
 			if err := a.handleResult(ctx, res, out); err != nil {
 				return err
 			}
+			a.pending.Store(int64(len(a.partial) + len(a.orphans)))
 		}
 	}
 }
 
+// Pending returns how many directories the aggregator is holding state for,
+// either waiting on children or waiting on their parent's result. Safe for
+// concurrent use.
+func (a *Aggregator) Pending() int64 {
+	return a.pending.Load()
+}
+
 func (a *Aggregator) handleResult(ctx context.Context, res DirResult, out chan<- entry.Rollup) error {
 	dirID := res.DirID
 	parentID := res.ParentID
@@ -78,17 +96,24 @@ func (a *Aggregator) handleResult(ctx context.Context, res DirResult, out chan<-
 		TotalSize:   res.FileSize,
 		TotalBlocks: res.FileBlocks,
 		TotalFiles:  res.FileCount,
+		TotalSlack:  res.FileSlack,
+		TotalSparse: res.FileSparse,
 	}
 
 	a.partial[dirID] = rollup
 	a.parents[dirID] = parentID
 	a.expected[dirID] = res.ChildCount
+	if res.Detached {
+		a.detached[dirID] = struct{}{}
+	}
 
 	if orphan, ok := a.orphans[dirID]; ok {
 		rollup.TotalSize += orphan.total.TotalSize
 		rollup.TotalBlocks += orphan.total.TotalBlocks
 		rollup.TotalFiles += orphan.total.TotalFiles
 		rollup.TotalDirs += orphan.total.TotalDirs
+		rollup.TotalSlack += orphan.total.TotalSlack
+		rollup.TotalSparse += orphan.total.TotalSparse
 		a.completed[dirID] += orphan.count
 		delete(a.orphans, dirID)
 	}
@@ -119,6 +144,10 @@ func (a *Aggregator) markComplete(ctx context.Context, dirID int64, out chan<- e
 		if _, isRoot := a.roots[dirID]; isRoot || parentID == 0 {
 			return nil
 		}
+		if _, ok := a.detached[dirID]; ok {
+			delete(a.detached, dirID)
+			return nil
+		}
 
 		if parentRollup, ok := a.partial[parentID]; ok {
 			a.addChildRollup(parentRollup, rollup)
@@ -140,6 +169,8 @@ func (a *Aggregator) addChildRollup(parent, child *entry.Rollup) {
 	parent.TotalBlocks += child.TotalBlocks
 	parent.TotalFiles += child.TotalFiles
 	parent.TotalDirs += child.TotalDirs + 1
+	parent.TotalSlack += child.TotalSlack
+	parent.TotalSparse += child.TotalSparse
 }
 
 func (a *Aggregator) addOrphan(parentID int64, child *entry.Rollup) {
@@ -152,5 +183,7 @@ func (a *Aggregator) addOrphan(parentID int64, child *entry.Rollup) {
 	agg.total.TotalBlocks += child.TotalBlocks
 	agg.total.TotalFiles += child.TotalFiles
 	agg.total.TotalDirs += child.TotalDirs + 1
+	agg.total.TotalSlack += child.TotalSlack
+	agg.total.TotalSparse += child.TotalSparse
 	agg.count++
 }
@@ -68,3 +68,35 @@ func TestAggregatorStreamingRollups(t *testing.T) {
 		t.Fatalf("unexpected empty rollup: %+v", empty)
 	}
 }
+
+func TestAggregatorDetachedRollupsStayOutOfParent(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan DirResult, 4)
+	out := make(chan entry.Rollup, 4)
+
+	agg := NewAggregator([]int64{1})
+	done := make(chan error, 1)
+	go func() {
+		done <- agg.Run(ctx, in, out)
+	}()
+
+	// Dir 2 is an archive listing under the root; the root does not count it.
+	in <- DirResult{DirID: 2, ParentID: 1, FileSize: 1000, FileCount: 3, ChildCount: 0, Detached: true}
+	in <- DirResult{DirID: 1, ParentID: 0, FileSize: 10, FileBlocks: 10, FileCount: 1, ChildCount: 0}
+	close(in)
+
+	rollups := make(map[int64]entry.Rollup)
+	for r := range out {
+		rollups[r.DirID] = r
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("aggregator error: %v", err)
+	}
+
+	if root := rollups[1]; root.TotalSize != 10 || root.TotalFiles != 1 || root.TotalDirs != 0 {
+		t.Fatalf("unexpected root rollup: %+v", root)
+	}
+	if archive := rollups[2]; archive.TotalSize != 1000 || archive.TotalFiles != 3 {
+		t.Fatalf("unexpected archive rollup: %+v", archive)
+	}
+}
@@ -0,0 +1,165 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/dustin/go-humanize"
+	"github.com/michaelscutari/dug/internal/budget"
+	"github.com/michaelscutari/dug/internal/entry"
+	"github.com/michaelscutari/dug/internal/rollup"
+	"github.com/michaelscutari/dug/internal/source"
+)
+
+// descendArchive lists the members of a tar or zip archive as a virtual
+// directory tree at the archive's own path. The tree's rollups are detached,
+// so member sizes never count towards on-disk usage. It does nothing when
+// the source cannot read file contents.
+func (w *Worker) descendArchive(ctx context.Context, work dirWork, archivePath, name string, size int64) {
+	opener, ok := w.src.(source.Opener)
+	if !ok {
+		return
+	}
+	if err := w.opts.Limiter.Wait(ctx); err != nil {
+		return
+	}
+
+	// An abandoned read stops at its next member once readCtx is canceled
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	listing, err := callWithRelease(ctx, w.opts.ReadDirTimeout, func() (archiveListing, error) {
+		return w.readArchive(readCtx, opener, archivePath, name, size)
+	}, w.releaseArchive)
+	if err == nil {
+		defer w.releaseArchive(listing)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if errors.Is(err, errTimedOut) {
+		w.reportTimeout(ctx, archivePath, 0, fmt.Sprintf("archive listing timed out after %s", w.opts.ReadDirTimeout))
+		return
+	}
+	if err != nil {
+		w.sendError(archivePath, fmt.Sprintf("archive: %v", err))
+		return
+	}
+
+	tree := listing.tree
+	if w.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "[W%d] ARCHIVE members=%d path=%s\n", w.id, tree.Len(), archivePath)
+	}
+	rootID := atomic.AddInt64(w.dirIDSeq, 1)
+	w.emitArchiveDir(ctx, tree, archivePath, name, rootID, work.dirID, work.depth+1, true)
+}
+
+// archiveListing is an archive's member tree and the bytes of
+// ArchiveMemory it holds.
+type archiveListing struct {
+	tree  *source.Memory
+	bytes int64
+}
+
+// readArchive builds the member tree of the archive at archivePath.
+// Members may arrive in any order, so the whole tree is held until it is
+// emitted. The read fails once all workers' listings together would exceed
+// ArchiveMemory.
+func (w *Worker) readArchive(ctx context.Context, opener source.Opener, archivePath, name string, size int64) (archiveListing, error) {
+	f, err := opener.Open(archivePath)
+	if err != nil {
+		return archiveListing{}, err
+	}
+	defer f.Close()
+
+	l := archiveListing{tree: source.NewMemory()}
+	l.tree.AddDir(archivePath, source.Stat{})
+	err = source.ReadArchive(name, f, size, func(m source.ArchiveMember) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := budget.ArchiveMemberBytes + 2*int64(len(m.Path))
+		l.bytes += n
+		if total := atomic.AddInt64(w.archiveMem, n); w.opts.ArchiveMemory > 0 && total > w.opts.ArchiveMemory {
+			return fmt.Errorf("listing exceeds the archive memory budget of %s", humanize.IBytes(uint64(w.opts.ArchiveMemory)))
+		}
+		memberPath := archivePath + "/" + m.Path
+		st := source.Stat{Kind: entry.KindArchiveMember, Size: m.Size, ModTime: m.ModTime}
+		if m.Kind == entry.KindDir {
+			return l.tree.AddDir(memberPath, st)
+		}
+		return l.tree.AddFile(memberPath, st)
+	})
+	if err != nil {
+		w.releaseArchive(l)
+		return archiveListing{}, err
+	}
+	return l, nil
+}
+
+// releaseArchive returns a listing's bytes to ArchiveMemory.
+func (w *Worker) releaseArchive(l archiveListing) {
+	atomic.AddInt64(w.archiveMem, -l.bytes)
+}
+
+func (w *Worker) emitArchiveDir(ctx context.Context, tree *source.Memory, dirPath, name string, dirID, parentID int64, depth int, root bool) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	select {
+	case w.dirCh <- entry.Dir{ID: dirID, Path: dirPath, Name: name, ParentID: parentID, Depth: depth, Archive: true}:
+	case <-ctx.Done():
+		return
+	}
+
+	children, _ := tree.ReadDir(dirPath)
+	var size, count int64
+	var subdirs int
+	for _, c := range children {
+		childPath := dirPath + "/" + c.Name
+		st, _ := tree.Lstat(childPath)
+		if st.Kind == entry.KindDir {
+			subdirs++
+			w.emitArchiveDir(ctx, tree, childPath, c.Name, atomic.AddInt64(w.dirIDSeq, 1), dirID, depth+1, false)
+			continue
+		}
+		size += st.Size
+		count++
+		e := entry.Entry{
+			ParentID: dirID,
+			Name:     c.Name,
+			Kind:     entry.KindArchiveMember,
+			Size:     st.Size,
+			ModTime:  st.ModTime,
+		}
+		select {
+		case w.entryCh <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	select {
+	case w.dirResCh <- rollup.DirResult{
+		DirID:      dirID,
+		ParentID:   parentID,
+		FileSize:   size,
+		FileCount:  count,
+		ChildCount: subdirs,
+		Detached:   root,
+	}:
+	case <-ctx.Done():
+	}
+}
+
+// sendError reports a non-fatal error, dropping it if the channel is full
+// (errors are sampled anyway).
+func (w *Worker) sendError(path, msg string) {
+	select {
+	case w.errorCh <- entry.ScanError{Path: path, Message: msg}:
+	default:
+	}
+}
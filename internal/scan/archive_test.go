@@ -0,0 +1,112 @@
+package scan
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/entry"
+	"github.com/michaelscutari/dug/internal/source"
+)
+
+// openerSource is a Memory tree whose files have contents.
+type openerSource struct {
+	*source.Memory
+	contents map[string][]byte
+}
+
+type bytesFile struct{ *bytes.Reader }
+
+func (bytesFile) Close() error { return nil }
+
+func (s openerSource) Open(path string) (source.File, error) {
+	return bytesFile{bytes.NewReader(s.contents[path])}, nil
+}
+
+func TestScannerDescendsArchives(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"docs/a.txt", "docs/b.txt", "c.txt"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 300}); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		tw.Write(make([]byte, 300))
+	}
+	tw.Close()
+
+	for _, tc := range []struct {
+		name     string
+		memory   int64
+		descends bool
+	}{
+		{"unlimited", 0, true},
+		{"too large", 512, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			database, err := sql.Open("sqlite", ":memory:")
+			if err != nil {
+				t.Fatalf("open db: %v", err)
+			}
+			defer database.Close()
+			database.SetMaxOpenConns(1)
+			if err := db.InitSchema(database); err != nil {
+				t.Fatalf("init schema: %v", err)
+			}
+
+			mem := source.NewMemory()
+			mem.AddFile("/data/x.tar", source.Stat{Size: int64(buf.Len()), Blocks: 8192})
+			mem.AddFile("/data/y.txt", source.Stat{Size: 10, Blocks: 4096})
+			src := openerSource{Memory: mem, contents: map[string][]byte{"/data/x.tar": buf.Bytes()}}
+
+			opts := DefaultOptions().WithWorkers(2).WithSource(src).WithDescendArchives(true).WithArchiveMemory(tc.memory)
+			if err := NewScanner(opts).Run(context.Background(), "/data", database); err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+
+			// The archive's members never count towards on-disk totals
+			root, err := db.GetRollup(database, "/data")
+			if err != nil || root == nil {
+				t.Fatalf("root rollup: %v", err)
+			}
+			if root.TotalSize != int64(buf.Len())+10 || root.TotalFiles != 2 {
+				t.Fatalf("unexpected root rollup: %+v", root)
+			}
+
+			children, err := db.LoadChildren(database, "/data", "name", 10)
+			if err != nil {
+				t.Fatalf("load children: %v", err)
+			}
+			if len(children) != 2 || children[0].Name != "x.tar" {
+				t.Fatalf("archive should be listed once: %+v", children)
+			}
+			archive := children[0]
+			if archive.TotalSize != int64(buf.Len()) {
+				t.Fatalf("archive row has size %d, want the file's %d", archive.TotalSize, buf.Len())
+			}
+
+			var errs int
+			database.QueryRow(`SELECT COUNT(*) FROM scan_errors WHERE path = '/data/x.tar'`).Scan(&errs)
+			if !tc.descends {
+				if archive.Kind != entry.KindFile || errs != 1 {
+					t.Fatalf("oversized archive: kind %s with %d errors, want a plain file and a scan error", archive.Kind, errs)
+				}
+				return
+			}
+			if archive.Kind != entry.KindArchive || errs != 0 {
+				t.Fatalf("archive: kind %s with %d errors", archive.Kind, errs)
+			}
+			members, err := db.GetRollup(database, "/data/x.tar")
+			if err != nil || members == nil || members.TotalSize != 900 || members.TotalFiles != 3 || members.TotalDirs != 1 {
+				t.Fatalf("archive rollup = %+v, %v; want 3 members of 900 bytes in 1 directory", members, err)
+			}
+			docs, err := db.LoadChildren(database, "/data/x.tar/docs", "name", 10)
+			if err != nil || len(docs) != 2 || !strings.HasSuffix(docs[1].Path, "docs/b.txt") {
+				t.Fatalf("docs = %+v, %v", docs, err)
+			}
+		})
+	}
+}
@@ -1,12 +1,30 @@
 package scan
 
-import "regexp"
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/budget"
+	"github.com/michaelscutari/dug/internal/entry"
+	"github.com/michaelscutari/dug/internal/source"
+	"github.com/michaelscutari/dug/internal/throttle"
+)
 
 // ScanOptions configures the scanning behavior.
 type ScanOptions struct {
-	// Workers is the number of concurrent directory processors.
+	// Workers is the number of concurrent directory processors. With
+	// AutoWorkers it is the size of the pool the tuner draws from.
 	Workers int
 
+	// AutoWorkers lets the scanner vary the active worker count between
+	// MinWorkers and MaxWorkers based on observed filesystem latency.
+	AutoWorkers bool
+	MinWorkers  int
+	MaxWorkers  int
+
 	// Xdev prevents crossing filesystem boundaries.
 	Xdev bool
 
@@ -25,6 +43,62 @@ type ScanOptions struct {
 
 	// Verbose enables debug logging for scan internals.
 	Verbose bool
+
+	// ReadDirTimeout bounds a single directory read. Zero means no deadline.
+	ReadDirTimeout time.Duration
+
+	// StatTimeout bounds a single lstat call. Zero means no deadline.
+	StatTimeout time.Duration
+
+	// Limiter caps readdir and lstat calls across all workers. Nil means unlimited.
+	Limiter *throttle.Limiter
+
+	// Gate pauses workers and the ingester while closed. Nil means never paused.
+	Gate *throttle.Gate
+
+	// Source supplies listings and metadata. Nil means the local filesystem.
+	Source source.Source
+
+	// DescendArchives lists the members of tar and zip files as virtual
+	// directories.
+	DescendArchives bool
+
+	// ArchiveMemory caps the bytes of archive listings held by all workers
+	// at once. An archive whose listing would not fit is skipped and
+	// reported. Zero means unlimited.
+	ArchiveMemory int64
+
+	// QueueMemory caps the bytes of pending directory work held in worker
+	// stacks. Beyond it, work spills to segment files under SpillDir. Zero
+	// keeps everything in memory.
+	QueueMemory int64
+
+	// SpillDir is where spilled directory work is written. Empty means the
+	// system temp directory.
+	SpillDir string
+
+	// ChannelMemory scales the pipeline channel capacities down to fit this
+	// many bytes. Zero uses the default capacities.
+	ChannelMemory int64
+
+	// MaxPendingDirs is how many incomplete directories the rollup
+	// aggregator may hold before workers stop sharing new subdirectories and
+	// finish their own subtrees first. Zero means unlimited.
+	MaxPendingDirs int
+
+	// MemoryLimit is the overall budget the options were derived from,
+	// recorded in scan_meta. Zero means none was set.
+	MemoryLimit int64
+
+	// ShardIndex and ShardCount restrict the scan to one slice of the root's
+	// top-level subtrees so several processes can split the work. A count
+	// below 2 scans everything.
+	ShardIndex int
+	ShardCount int
+
+	// Warn receives problems that do not stop the scan. Nil prints them to
+	// stderr.
+	Warn func(error)
 }
 
 // DefaultOptions returns sensible defaults for scanning.
@@ -37,6 +111,8 @@ func DefaultOptions() *ScanOptions {
 		BatchSize:       10000,
 		FlushIntervalMs: 1000,
 		Verbose:         false,
+		QueueMemory:     64 << 20,
+		ArchiveMemory:   256 << 20,
 	}
 	// Exclude NFS snapshot directories by default
 	opts.AddExcludePattern(`/\.snapshot(/|$)`)
@@ -49,6 +125,15 @@ func (o *ScanOptions) WithWorkers(n int) *ScanOptions {
 	return o
 }
 
+// WithAutoWorkers enables adaptive concurrency between min and max workers.
+func (o *ScanOptions) WithAutoWorkers(min, max int) *ScanOptions {
+	o.AutoWorkers = true
+	o.MinWorkers = min
+	o.MaxWorkers = max
+	o.Workers = max
+	return o
+}
+
 // WithXdev sets cross-device behavior.
 func (o *ScanOptions) WithXdev(xdev bool) *ScanOptions {
 	o.Xdev = xdev
@@ -67,6 +152,161 @@ func (o *ScanOptions) WithVerbose(verbose bool) *ScanOptions {
 	return o
 }
 
+// WithReadDirTimeout sets the per-directory read deadline.
+func (o *ScanOptions) WithReadDirTimeout(d time.Duration) *ScanOptions {
+	o.ReadDirTimeout = d
+	return o
+}
+
+// WithStatTimeout sets the per-entry lstat deadline.
+func (o *ScanOptions) WithStatTimeout(d time.Duration) *ScanOptions {
+	o.StatTimeout = d
+	return o
+}
+
+// WithLimiter sets the shared I/O rate limiter.
+func (o *ScanOptions) WithLimiter(l *throttle.Limiter) *ScanOptions {
+	o.Limiter = l
+	return o
+}
+
+// WithGate sets the pause gate.
+func (o *ScanOptions) WithGate(g *throttle.Gate) *ScanOptions {
+	o.Gate = g
+	return o
+}
+
+// WithSource sets the filesystem source to scan.
+func (o *ScanOptions) WithSource(src source.Source) *ScanOptions {
+	o.Source = src
+	return o
+}
+
+// WithDescendArchives enables or disables listing archive members.
+func (o *ScanOptions) WithDescendArchives(descend bool) *ScanOptions {
+	o.DescendArchives = descend
+	return o
+}
+
+// WithArchiveMemory sets the in-memory budget for archive listings.
+func (o *ScanOptions) WithArchiveMemory(bytes int64) *ScanOptions {
+	o.ArchiveMemory = bytes
+	return o
+}
+
+// WithQueueMemory sets the in-memory budget for pending directory work.
+func (o *ScanOptions) WithQueueMemory(bytes int64) *ScanOptions {
+	o.QueueMemory = bytes
+	return o
+}
+
+// WithSpillDir sets where directory work beyond the budget is written.
+func (o *ScanOptions) WithSpillDir(dir string) *ScanOptions {
+	o.SpillDir = dir
+	return o
+}
+
+// WithBudget applies the scanner's shares of a memory budget.
+func (o *ScanOptions) WithBudget(b budget.Budget) *ScanOptions {
+	o.MemoryLimit = b.Limit
+	o.ChannelMemory = b.Channels
+	o.QueueMemory = b.QueueMemory
+	o.ArchiveMemory = b.ArchiveMemory
+	o.MaxPendingDirs = b.MaxPendingDirs
+	return o
+}
+
+// WithShard restricts the scan to shard index of count.
+func (o *ScanOptions) WithShard(index, count int) *ScanOptions {
+	o.ShardIndex = index
+	o.ShardCount = count
+	return o
+}
+
+// ownsTopLevel reports whether this shard scans the named child of the root.
+// Subdirectories are spread by name hash so every shard agrees without
+// coordinating; the root's own files and other entries go to shard 0.
+func (o *ScanOptions) ownsTopLevel(name string, kind entry.Kind) bool {
+	if o.ShardCount < 2 {
+		return true
+	}
+	if kind != entry.KindDir {
+		return o.ShardIndex == 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32()%uint32(o.ShardCount)) == o.ShardIndex
+}
+
+// recordedOptions is the part of ScanOptions that shapes a snapshot's
+// contents, stored as JSON in its schema_info.
+type recordedOptions struct {
+	Source          string   `json:"source"`
+	Workers         int      `json:"workers"`
+	AutoWorkers     bool     `json:"auto_workers,omitempty"`
+	Xdev            bool     `json:"xdev"`
+	Exclude         []string `json:"exclude,omitempty"`
+	MaxErrors       int      `json:"max_errors,omitempty"`
+	ReadDirTimeout  string   `json:"readdir_timeout,omitempty"`
+	StatTimeout     string   `json:"stat_timeout,omitempty"`
+	RateLimit       float64  `json:"rate_limit,omitempty"`
+	DescendArchives bool     `json:"descend_archives,omitempty"`
+	ArchiveMemory   int64    `json:"archive_memory,omitempty"`
+	ShardIndex      int      `json:"shard_index,omitempty"`
+	ShardCount      int      `json:"shard_count,omitempty"`
+}
+
+func (o *ScanOptions) recorded() recordedOptions {
+	r := recordedOptions{
+		Source:          fmt.Sprintf("%T", o.source()),
+		Workers:         o.Workers,
+		AutoWorkers:     o.AutoWorkers,
+		Xdev:            o.Xdev,
+		MaxErrors:       o.MaxErrors,
+		RateLimit:       o.Limiter.Rate(),
+		DescendArchives: o.DescendArchives,
+		ShardIndex:      o.ShardIndex,
+		ShardCount:      o.ShardCount,
+	}
+	if src, ok := o.source().(source.OS); ok && src.Method != source.StatAuto {
+		r.Source += ":" + src.Method.String()
+	}
+	for _, re := range o.ExcludePatterns {
+		r.Exclude = append(r.Exclude, re.String())
+	}
+	if o.DescendArchives {
+		r.ArchiveMemory = o.ArchiveMemory
+	}
+	if o.ReadDirTimeout > 0 {
+		r.ReadDirTimeout = o.ReadDirTimeout.String()
+	}
+	if o.StatTimeout > 0 {
+		r.StatTimeout = o.StatTimeout.String()
+	}
+	return r
+}
+
+func (o *ScanOptions) source() source.Source {
+	if o.Source == nil {
+		return source.OS{}
+	}
+	return o.Source
+}
+
+// WithWarn sets the callback for problems that do not stop the scan.
+func (o *ScanOptions) WithWarn(f func(error)) *ScanOptions {
+	o.Warn = f
+	return o
+}
+
+func (o *ScanOptions) warn(err error) {
+	if o.Warn != nil {
+		o.Warn(err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+}
+
 // AddExcludePattern adds a pattern to exclude.
 func (o *ScanOptions) AddExcludePattern(pattern string) error {
 	re, err := regexp.Compile(pattern)
@@ -3,11 +3,13 @@ package scan
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	"github.com/michaelscutari/dug/internal/db"
@@ -16,11 +18,19 @@ import (
 	"github.com/michaelscutari/dug/internal/rollup"
 )
 
+var (
+	// ErrRootMissing is returned when a scan root does not exist.
+	ErrRootMissing = errors.New("scan root does not exist")
+
+	// ErrMaxErrors is returned when a scan stops after reaching
+	// ScanOptions.MaxErrors.
+	ErrMaxErrors = errors.New("maximum error count exceeded")
+)
+
 // Scanner coordinates the filesystem scan.
 type Scanner struct {
 	opts     *ScanOptions
 	root     string
-	rootDev  uint64
 	database *sql.DB
 
 	entryCh     chan entry.Entry
@@ -32,11 +42,17 @@ type Scanner struct {
 
 	inFlight int64
 	dirIDSeq int64
+	topID    int64 // synthetic top directory when scanning several roots
 
 	wg        sync.WaitGroup
 	closeOnce sync.Once
 
 	ingester *db.Ingester
+	tuner    *tuner
+	spill    *spillQueue
+	queueMem int64
+
+	archiveMem int64
 }
 
 // NewScanner creates a new scanner.
@@ -63,6 +79,24 @@ func NewScanner(opts *ScanOptions) *Scanner {
 	if rollupChSize < 10000 {
 		rollupChSize = 10000
 	}
+
+	// Under a memory budget, shrink every channel by the same factor so the
+	// buffers fit even when all of them are full
+	if opts.ChannelMemory > 0 {
+		full := int64(queueSize)*dirWorkBytes + int64(entryChSize)*entryBytes +
+			int64(dirEntryChSize)*dirBytes + int64(dirResultChSize)*dirResultBytes +
+			int64(rollupChSize)*rollupBytes
+		if full > opts.ChannelMemory {
+			scale := func(n, floor int) int {
+				return max(int(int64(n)*opts.ChannelMemory/full), floor)
+			}
+			queueSize = scale(queueSize, 1024)
+			entryChSize = scale(entryChSize, opts.BatchSize)
+			dirEntryChSize = scale(dirEntryChSize, 1024)
+			dirResultChSize = scale(dirResultChSize, 1024)
+			rollupChSize = scale(rollupChSize, 1024)
+		}
+	}
 	return &Scanner{
 		opts:        opts,
 		entryCh:     make(chan entry.Entry, entryChSize),
@@ -76,22 +110,42 @@ func NewScanner(opts *ScanOptions) *Scanner {
 
 // Run executes the scan starting from root and writes to the database.
 func (s *Scanner) Run(ctx context.Context, root string, database *sql.DB) error {
-	root = pathutil.Normalize(root)
-	s.root = root
+	return s.RunRoots(ctx, []string{root}, database)
+}
+
+// RunRoots scans several roots into one database. With more than one root, a
+// synthetic directory at their common ancestor becomes the top level and
+// each root keeps its own device for the cross-device check.
+func (s *Scanner) RunRoots(ctx context.Context, roots []string, database *sql.DB) error {
+	roots, err := normalizeRoots(roots)
+	if err != nil {
+		return err
+	}
+	if len(roots) > 1 && s.opts.ShardCount > 1 {
+		return fmt.Errorf("sharded scans take a single root")
+	}
+	s.root = roots[0]
+	if len(roots) > 1 {
+		s.root = pathutil.CommonAncestor(roots)
+	}
 	s.database = database
 
 	// Create cancellable context for max-errors abort
+	parent := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// Get root device ID for cross-device check
-	rootInfo, err := os.Lstat(root)
-	if err != nil {
-		return fmt.Errorf("failed to stat root: %w", err)
-	}
-
-	if stat, ok := rootInfo.Sys().(*syscall.Stat_t); ok {
-		s.rootDev = uint64(stat.Dev)
+	// Get each root's device ID for cross-device checks
+	rootDevs := make([]uint64, len(roots))
+	for i, root := range roots {
+		rootStat, err := s.opts.source().Lstat(root)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrRootMissing, root)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat root %s: %w", root, err)
+		}
+		rootDevs[i] = rootStat.DevID
 	}
 
 	// Record scan start
@@ -102,35 +156,65 @@ func (s *Scanner) Run(ctx context.Context, root string, database *sql.DB) error
 
 	// Start ingester
 	s.ingester = db.NewIngester(s.database, s.entryCh, s.dirEntryCh, s.rollupCh, s.errorCh, s.opts.BatchSize, s.opts.FlushIntervalMs, s.opts.MaxErrors, s.opts.Verbose, cancel)
+	s.ingester.SetGate(s.opts.Gate)
 	ingesterDone := make(chan error, 1)
 	go func() {
 		ingesterDone <- s.ingester.Run(ctx)
 	}()
 
-	rootID := s.nextDirID()
-	rootDir := entry.Dir{
-		ID:       rootID,
-		Path:     root,
-		Name:     rootInfo.Name(),
-		ParentID: 0,
-		Depth:    0,
+	// With several roots, a synthetic top directory holds them all; its
+	// result is known up front since it has no files of its own. The
+	// directory without a parent is named by its absolute path, and roots
+	// under the top by their path relative to it.
+	seeds := make([]dirWork, len(roots))
+	if len(roots) > 1 {
+		s.topID = s.nextDirID()
+		if err := s.emitDir(ctx, entry.Dir{ID: s.topID, Path: s.root, Name: s.root}); err != nil {
+			return err
+		}
+		s.dirResultCh <- rollup.DirResult{DirID: s.topID, ChildCount: len(roots)}
 	}
-	select {
-	case s.dirEntryCh <- rootDir:
-	case <-ctx.Done():
-		return ctx.Err()
+	for i, root := range roots {
+		dir := entry.Dir{ID: s.nextDirID(), Path: root, Name: root, ParentID: s.topID}
+		if s.topID != 0 {
+			dir.Name, _ = filepath.Rel(s.root, root)
+			dir.Depth = 1
+		}
+		if err := s.emitDir(ctx, dir); err != nil {
+			return err
+		}
+		seeds[i] = dirWork{path: root, dirID: dir.ID, parentID: s.topID, depth: dir.Depth, rootDev: rootDevs[i]}
+	}
+	aggRoot := seeds[0].dirID
+	if s.topID != 0 {
+		aggRoot = s.topID
 	}
 
 	// Start rollup aggregator
-	agg := rollup.NewAggregator([]int64{rootID})
+	agg := rollup.NewAggregator([]int64{aggRoot})
 	aggDone := make(chan error, 1)
 	go func() {
 		aggDone <- agg.Run(ctx, s.dirResultCh, s.rollupCh)
 	}()
 
+	// Directory work beyond the memory budget overflows to disk
+	if s.opts.QueueMemory > 0 {
+		s.spill = newSpillQueue(s.opts.SpillDir)
+		defer s.spill.close()
+	}
+
 	// Start workers
+	if s.opts.AutoWorkers {
+		s.tuner = newTuner(s.opts.MinWorkers, s.opts.MaxWorkers, s.opts.Verbose)
+		go s.tuner.run(ctx)
+	}
 	for i := 0; i < s.opts.Workers; i++ {
-		worker := NewWorker(i, s.opts, s.root, s.rootDev, s.entryCh, s.dirEntryCh, s.errorCh, s.dirResultCh, s.dirQueue, &s.inFlight, &s.dirIDSeq)
+		worker := NewWorker(i, s.opts, s.root, s.entryCh, s.dirEntryCh, s.errorCh, s.dirResultCh, s.dirQueue, &s.inFlight, &s.dirIDSeq)
+		worker.tuner = s.tuner
+		worker.spill = s.spill
+		worker.queueMem = &s.queueMem
+		worker.archiveMem = &s.archiveMem
+		worker.pendingDirs = agg.Pending
 		s.wg.Add(1)
 		go func(w *Worker) {
 			defer s.wg.Done()
@@ -138,15 +222,20 @@ func (s *Scanner) Run(ctx context.Context, root string, database *sql.DB) error
 		}(worker)
 	}
 
-	// Seed the queue with root
-	atomic.AddInt64(&s.inFlight, 1)
+	// Seed the queue with the roots
+	atomic.AddInt64(&s.inFlight, int64(len(seeds)))
 	if s.opts.Verbose {
-		fmt.Fprintf(os.Stderr, "[SCANNER] SEEDED root=%s inFlight=1 queueSize=%d entryChSize=%d\n", root, cap(s.dirQueue), cap(s.entryCh))
+		fmt.Fprintf(os.Stderr, "[SCANNER] SEEDED roots=%d inFlight=%d queueSize=%d entryChSize=%d\n", len(seeds), len(seeds), cap(s.dirQueue), cap(s.entryCh))
 	}
-	select {
-	case s.dirQueue <- dirWork{path: root, dirID: rootID, parentID: 0, depth: 0}:
-	case <-ctx.Done():
-		atomic.AddInt64(&s.inFlight, -1)
+	for i, seed := range seeds {
+		select {
+		case s.dirQueue <- seed:
+		case <-ctx.Done():
+			atomic.AddInt64(&s.inFlight, -int64(len(seeds)-i))
+		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
 
 	// Monitor for completion or cancellation
@@ -164,6 +253,9 @@ func (s *Scanner) Run(ctx context.Context, root string, database *sql.DB) error
 
 	// Ensure queue is closed after workers exit (safe if already closed)
 	s.closeDirQueue()
+	if n := s.spill.Spilled(); n > 0 && s.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "[SCANNER] SPILLED %d directories to disk\n", n)
+	}
 
 	// Close channels to signal completion
 	if s.opts.Verbose {
@@ -185,6 +277,9 @@ func (s *Scanner) Run(ctx context.Context, root string, database *sql.DB) error
 	}
 
 	if ctx.Err() != nil {
+		if parent.Err() == nil && s.opts.MaxErrors > 0 && s.ingester.ErrorCount() >= int64(s.opts.MaxErrors) {
+			return fmt.Errorf("%w (%d)", ErrMaxErrors, s.opts.MaxErrors)
+		}
 		return ctx.Err()
 	}
 
@@ -193,14 +288,33 @@ func (s *Scanner) Run(ctx context.Context, root string, database *sql.DB) error
 		return err
 	}
 
-	return nil
+	if err := s.writeScanRoots(seeds); err != nil {
+		return err
+	}
+
+	if err := s.writeConcurrencySamples(); err != nil {
+		return err
+	}
+
+	return s.writeScanEvents()
 }
 
+// Approximate bytes per buffered item, including a typical path or name,
+// used to fit channel capacities into ChannelMemory.
+const (
+	dirWorkBytes   = 160
+	entryBytes     = 128
+	dirBytes       = 192
+	dirResultBytes = 80
+	rollupBytes    = 64
+)
+
 type dirWork struct {
 	path     string
 	dirID    int64
 	parentID int64
 	depth    int
+	rootDev  uint64 // device of the root this work descends from
 }
 
 func (s *Scanner) monitorCompletion(ctx context.Context) {
@@ -259,15 +373,24 @@ func (s *Scanner) monitorCompletion(ctx context.Context) {
 func (s *Scanner) closeDirQueue() {
 	s.closeOnce.Do(func() {
 		close(s.dirQueue)
+		s.tuner.stop()
 	})
 }
 
 func (s *Scanner) initScanMeta(startTime time.Time) error {
 	_, err := s.database.Exec(
-		`INSERT INTO scan_meta (id, root_path, start_time) VALUES (1, ?, ?)`,
-		s.root, startTime.Unix(),
+		`INSERT INTO scan_meta (id, root_path, start_time, memory_limit, shard_index, shard_count) VALUES (1, ?, ?, ?, ?, ?)`,
+		s.root, startTime.Unix(), s.opts.MemoryLimit, s.opts.ShardIndex, s.opts.ShardCount,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	recorded, err := json.Marshal(s.opts.recorded())
+	if err != nil {
+		return err
+	}
+	return db.SetSchemaInfo(s.database, db.InfoScanOptions, string(recorded))
 }
 
 // Progress returns current scan progress (safe for concurrent access).
@@ -286,8 +409,11 @@ func (s *Scanner) finalizeScanMeta(errorCount int64) error {
 	row := s.database.QueryRow(`SELECT COUNT(*) FROM entries WHERE kind = 0`)
 	row.Scan(&fileCount)
 
-	row = s.database.QueryRow(`SELECT COUNT(*) FROM dirs`)
+	row = s.database.QueryRow(`SELECT COUNT(*) FROM dirs WHERE kind = ?`, entry.KindDir)
 	row.Scan(&dirCount)
+	if s.topID != 0 {
+		dirCount-- // the synthetic top isn't a real directory
+	}
 
 	row = s.database.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM entries WHERE kind = 0`)
 	row.Scan(&totalSize)
@@ -302,6 +428,105 @@ func (s *Scanner) finalizeScanMeta(errorCount int64) error {
 	return err
 }
 
+// writeConcurrencySamples stores the adaptive worker history, if any.
+func (s *Scanner) writeConcurrencySamples() error {
+	samples := s.tuner.Samples()
+	if len(samples) == 0 {
+		return nil
+	}
+
+	tx, err := s.database.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin concurrency transaction: %w", err)
+	}
+	for _, sample := range samples {
+		_, err := tx.Exec(
+			`INSERT INTO scan_concurrency (time, workers, ops_per_sec, avg_latency_us) VALUES (?, ?, ?, ?)`,
+			sample.Time.Unix(), sample.Workers, sample.OpsPerSec, sample.AvgLatency.Microseconds(),
+		)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record concurrency sample: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// writeScanEvents stores pause/resume events, if any.
+func (s *Scanner) writeScanEvents() error {
+	events := s.opts.Gate.Events()
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := s.database.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin events transaction: %w", err)
+	}
+	for _, ev := range events {
+		name := "resume"
+		if ev.Paused {
+			name = "pause"
+		}
+		if _, err := tx.Exec(`INSERT INTO scan_events (time, event, detail) VALUES (?, ?, ?)`, ev.Time.Unix(), name, ev.Reason); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record scan event: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// normalizeRoots cleans the roots and rejects duplicates and nesting, which
+// would scan the same directories twice.
+func normalizeRoots(roots []string) ([]string, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no roots to scan")
+	}
+	out := make([]string, len(roots))
+	for i, root := range roots {
+		out[i] = pathutil.Normalize(root)
+	}
+	if len(out) > 1 {
+		for i, a := range out {
+			if !filepath.IsAbs(a) {
+				return nil, fmt.Errorf("root %s must be absolute when scanning several roots", a)
+			}
+			for j, b := range out {
+				if i != j && pathutil.IsWithin(a, b) {
+					return nil, fmt.Errorf("root %s overlaps root %s", a, b)
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s *Scanner) emitDir(ctx context.Context, dir entry.Dir) error {
+	select {
+	case s.dirEntryCh <- dir:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeScanRoots stores each root's totals from its rollup.
+func (s *Scanner) writeScanRoots(seeds []dirWork) error {
+	for _, seed := range seeds {
+		_, err := s.database.Exec(`
+			INSERT INTO scan_roots (dir_id, path, dev_id, total_size, total_blocks, file_count, dir_count)
+			SELECT ?, ?, ?, COALESCE(r.total_size, 0), COALESCE(r.total_blocks, 0),
+			       COALESCE(r.total_files, 0), COALESCE(r.total_dirs, 0) + 1
+			FROM (SELECT 1) LEFT JOIN rollups r ON r.dir_id = ?`,
+			seed.dirID, seed.path, int64(seed.rootDev), seed.dirID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record scan root %s: %w", seed.path, err)
+		}
+	}
+	return nil
+}
+
 func (s *Scanner) nextDirID() int64 {
 	return atomic.AddInt64(&s.dirIDSeq, 1)
 }
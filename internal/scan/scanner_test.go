@@ -0,0 +1,228 @@
+package scan
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/source"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestScannerWithMemorySource(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := db.InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+
+	mem := source.NewMemory()
+	mem.AddFile("/data/a.txt", source.Stat{Size: 100, Blocks: 4096})
+	mem.AddFile("/data/sub/b.txt", source.Stat{Size: 50, Blocks: 4096})
+	mem.AddFile("/data/sub/deep/c.txt", source.Stat{Size: 25, Blocks: 0})
+	mem.AddDir("/data/empty", source.Stat{})
+	mem.AddFile("/data/.snapshot/old.txt", source.Stat{Size: 999})
+
+	opts := DefaultOptions().WithWorkers(2).WithSource(mem)
+	if err := NewScanner(opts).Run(context.Background(), "/data", database); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	root, err := db.GetRollup(database, "/data")
+	if err != nil || root == nil {
+		t.Fatalf("root rollup: %v", err)
+	}
+	if root.TotalSize != 175 || root.TotalBlocks != 8192 || root.TotalFiles != 3 || root.TotalDirs != 3 {
+		t.Fatalf("unexpected root rollup: %+v", root)
+	}
+
+	children, err := db.LoadChildren(database, "/data", "name", 10)
+	if err != nil {
+		t.Fatalf("load children: %v", err)
+	}
+	var names []string
+	for _, c := range children {
+		names = append(names, c.Name)
+	}
+	if len(names) != 3 || names[0] != "a.txt" || names[1] != "empty" || names[2] != "sub" {
+		t.Fatalf("unexpected children: %v", names)
+	}
+}
+
+func TestScannerMultipleRoots(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := db.InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+
+	mem := source.NewMemory()
+	mem.AddFile("/home/u/a.txt", source.Stat{Size: 10, Blocks: 4096})
+	mem.AddFile("/data/b.txt", source.Stat{Size: 20, Blocks: 4096})
+	mem.AddFile("/data/sub/c.txt", source.Stat{Size: 30, Blocks: 4096})
+
+	opts := DefaultOptions().WithWorkers(2).WithSource(mem)
+	if err := NewScanner(opts).RunRoots(context.Background(), []string{"/home/u", "/data"}, database); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	top, err := db.GetRollup(database, "/")
+	if err != nil || top == nil {
+		t.Fatalf("top rollup: %v", err)
+	}
+	if top.TotalSize != 60 || top.TotalFiles != 3 {
+		t.Fatalf("unexpected top rollup: %+v", top)
+	}
+
+	var rootPath string
+	var dirCount int64
+	database.QueryRow(`SELECT root_path, dir_count FROM scan_meta WHERE id = 1`).Scan(&rootPath, &dirCount)
+	if rootPath != "/" || dirCount != 3 {
+		t.Fatalf("unexpected scan_meta root=%q dirs=%d", rootPath, dirCount)
+	}
+
+	var size, files int64
+	if err := database.QueryRow(`SELECT total_size, file_count FROM scan_roots WHERE path = '/data'`).Scan(&size, &files); err != nil {
+		t.Fatalf("scan_roots: %v", err)
+	}
+	if size != 50 || files != 2 {
+		t.Fatalf("unexpected /data totals: size=%d files=%d", size, files)
+	}
+
+	if parent, err := db.ParentPath(database, "/home/u"); err != nil || parent != "/" {
+		t.Fatalf("expected parent / for /home/u, got %q (%v)", parent, err)
+	}
+
+	if err := NewScanner(opts).RunRoots(context.Background(), []string{"/data", "/data/sub"}, database); err == nil {
+		t.Fatalf("expected error for nested roots")
+	}
+}
+
+// blockingSource is a Memory tree whose ReadDir and Lstat of the given
+// paths hang until release is closed, like calls to a dead NFS server.
+type blockingSource struct {
+	*source.Memory
+	hangReadDir map[string]bool
+	hangLstat   map[string]bool
+	release     chan struct{}
+}
+
+func (s blockingSource) ReadDir(path string) ([]source.DirEntry, error) {
+	if s.hangReadDir[path] {
+		<-s.release
+	}
+	return s.Memory.ReadDir(path)
+}
+
+func (s blockingSource) Lstat(path string) (source.Stat, error) {
+	if s.hangLstat[path] {
+		<-s.release
+	}
+	return s.Memory.Lstat(path)
+}
+
+func TestScannerTimesOutBlockedCalls(t *testing.T) {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	if err := db.InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+
+	mem := source.NewMemory()
+	mem.AddFile("/data/hung/lost.txt", source.Stat{Size: 1})
+	mem.AddFile("/data/slow/stuck.txt", source.Stat{Size: 2})
+	mem.AddFile("/data/ok/a.txt", source.Stat{Size: 100})
+	mem.AddFile("/data/b.txt", source.Stat{Size: 10})
+	src := blockingSource{
+		Memory:      mem,
+		hangReadDir: map[string]bool{"/data/hung": true},
+		hangLstat:   map[string]bool{"/data/slow/stuck.txt": true},
+		release:     make(chan struct{}),
+	}
+	defer close(src.release)
+
+	opts := DefaultOptions().WithWorkers(2).WithSource(src).
+		WithReadDirTimeout(50 * time.Millisecond).
+		WithStatTimeout(50 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() { done <- NewScanner(opts).Run(context.Background(), "/data", database) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("scan did not finish while calls were blocked")
+	}
+
+	for _, name := range []string{"hung", "slow"} {
+		var timedOut int
+		if err := database.QueryRow(`SELECT timed_out FROM dirs WHERE name = ?`, name).Scan(&timedOut); err != nil || timedOut != 1 {
+			t.Fatalf("dirs.timed_out for %s = %d, %v; want 1", name, timedOut, err)
+		}
+	}
+	var timeouts int
+	if err := database.QueryRow(`SELECT COUNT(*) FROM scan_errors WHERE timed_out = 1`).Scan(&timeouts); err != nil || timeouts != 2 {
+		t.Fatalf("timed-out scan errors = %d, %v; want 2", timeouts, err)
+	}
+
+	// Siblings of the blocked calls are still counted
+	root, err := db.GetRollup(database, "/data")
+	if err != nil || root == nil {
+		t.Fatalf("root rollup: %v", err)
+	}
+	if root.TotalSize != 110 || root.TotalFiles != 2 || root.TotalDirs != 3 {
+		t.Fatalf("unexpected root rollup: %+v", root)
+	}
+}
+
+func TestSpillQueueRoundTrip(t *testing.T) {
+	q := newSpillQueue(t.TempDir())
+	defer q.close()
+
+	const n = spillSegmentRecords + 10
+	for i := 0; i < n; i++ {
+		if _, err := q.push(dirWork{path: fmt.Sprintf("/root/d%d", i), dirID: int64(i + 2), parentID: 1, depth: 1}); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+	if q.Len() != n {
+		t.Fatalf("expected %d pending, got %d", n, q.Len())
+	}
+
+	seen := make(map[int64]bool, n)
+	for q.Len() > 0 {
+		batch, lost, err := q.popBatch(spillBatch)
+		if err != nil || lost != 0 {
+			t.Fatalf("pop: lost=%d err=%v", lost, err)
+		}
+		for _, w := range batch {
+			if w.path != fmt.Sprintf("/root/d%d", w.dirID-2) || w.parentID != 1 || w.depth != 1 {
+				t.Fatalf("corrupt record: %+v", w)
+			}
+			seen[w.dirID] = true
+		}
+	}
+	if len(seen) != n {
+		t.Fatalf("expected %d distinct records back, got %d", n, len(seen))
+	}
+}
@@ -0,0 +1,273 @@
+package scan
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// spillSegmentRecords is how many records go in one segment file before a new
+// one is started. Fully read segments are deleted, so disk use shrinks as
+// workers drain the queue.
+const spillSegmentRecords = 64 * 1024
+
+// spillBatch is how many records a worker reloads at once.
+const spillBatch = 1024
+
+// dirWorkOverhead approximates the memory a queued dirWork costs beyond its
+// path bytes (struct, slice slot and string header).
+const dirWorkOverhead = 64
+
+var errSpillDisabled = errors.New("spill queue disabled after an earlier error")
+
+// spillQueue holds directory work that didn't fit in the memory budget.
+// Records are appended to segment files in a private temp directory and read
+// back oldest segment first. Order doesn't matter to the scan; the queue only
+// has to hand every record back exactly once.
+//
+// Records lost to an I/O error are reported to the caller, which must settle
+// its in-flight count so the scan ends (and fails in rollup) instead of
+// waiting forever.
+type spillQueue struct {
+	parent string
+
+	mu       sync.Mutex
+	dir      string // created on first push
+	sealed   []spillSegment
+	seq      int
+	w        *os.File
+	bw       *bufio.Writer
+	wName    string
+	wCount   int
+	r        *os.File
+	br       *bufio.Reader
+	rName    string
+	rLeft    int // records not yet read from r
+	scratch  []byte
+	disabled bool
+
+	pending int64 // records on disk not yet read back (atomic)
+	spilled int64 // records ever written (atomic)
+}
+
+type spillSegment struct {
+	name  string
+	count int
+}
+
+func newSpillQueue(parent string) *spillQueue {
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	return &spillQueue{parent: parent}
+}
+
+// Len returns the number of records waiting on disk.
+func (q *spillQueue) Len() int64 {
+	if q == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&q.pending)
+}
+
+// Spilled returns the number of records ever written to disk.
+func (q *spillQueue) Spilled() int64 {
+	if q == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&q.spilled)
+}
+
+// push appends work to the current segment. On error work was not queued and
+// the caller keeps it; lost counts earlier records that went down with the
+// broken segment. After an error the queue stays disabled.
+func (q *spillQueue) push(work dirWork) (lost int, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.disabled {
+		return 0, errSpillDisabled
+	}
+	if err := q.writeLocked(work); err != nil {
+		q.disabled = true
+		return q.discardWriterLocked(), fmt.Errorf("failed to spill directory queue: %w", err)
+	}
+	return 0, nil
+}
+
+func (q *spillQueue) writeLocked(work dirWork) error {
+	if q.w != nil && q.wCount >= spillSegmentRecords {
+		if err := q.sealLocked(); err != nil {
+			return err
+		}
+	}
+	if q.dir == "" {
+		dir, err := os.MkdirTemp(q.parent, "dug-spill-*")
+		if err != nil {
+			return err
+		}
+		q.dir = dir
+	}
+	if q.w == nil {
+		q.seq++
+		q.wName = filepath.Join(q.dir, fmt.Sprintf("%06d.seg", q.seq))
+		f, err := os.Create(q.wName)
+		if err != nil {
+			return err
+		}
+		q.w = f
+		q.bw = bufio.NewWriterSize(f, 256*1024)
+		q.wCount = 0
+	}
+
+	buf := q.scratch[:0]
+	buf = binary.AppendUvarint(buf, uint64(work.dirID))
+	buf = binary.AppendUvarint(buf, uint64(work.parentID))
+	buf = binary.AppendUvarint(buf, uint64(work.depth))
+	buf = binary.AppendUvarint(buf, work.rootDev)
+	buf = binary.AppendUvarint(buf, uint64(len(work.path)))
+	buf = append(buf, work.path...)
+	q.scratch = buf
+	if _, err := q.bw.Write(buf); err != nil {
+		return err
+	}
+
+	q.wCount++
+	atomic.AddInt64(&q.pending, 1)
+	atomic.AddInt64(&q.spilled, 1)
+	return nil
+}
+
+// sealLocked finishes the current write segment so it can be read. On error
+// the segment stays open for discardWriterLocked.
+func (q *spillQueue) sealLocked() error {
+	if q.w == nil {
+		return nil
+	}
+	if err := q.bw.Flush(); err != nil {
+		return err
+	}
+	if err := q.w.Close(); err != nil {
+		return err
+	}
+	q.sealed = append(q.sealed, spillSegment{name: q.wName, count: q.wCount})
+	q.w, q.bw, q.wCount = nil, nil, 0
+	return nil
+}
+
+// discardWriterLocked drops the open write segment and returns how many
+// records it held.
+func (q *spillQueue) discardWriterLocked() int {
+	if q.w == nil {
+		return 0
+	}
+	q.w.Close()
+	os.Remove(q.wName)
+	lost := q.wCount
+	atomic.AddInt64(&q.pending, -int64(lost))
+	q.w, q.bw, q.wCount = nil, nil, 0
+	return lost
+}
+
+// popBatch reads back up to n records, returning an empty batch once nothing
+// is pending. An unreadable segment is dropped and lost reports how many
+// records went with it.
+func (q *spillQueue) popBatch(n int) (batch []dirWork, lost int, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(batch) < n && atomic.LoadInt64(&q.pending) > 0 {
+		if q.r == nil {
+			if len(q.sealed) == 0 {
+				if err := q.sealLocked(); err != nil {
+					q.disabled = true
+					return batch, q.discardWriterLocked(), fmt.Errorf("failed to seal spill segment: %w", err)
+				}
+			}
+			if len(q.sealed) == 0 {
+				break
+			}
+			seg := q.sealed[0]
+			q.sealed = q.sealed[1:]
+			f, err := os.Open(seg.name)
+			if err != nil {
+				atomic.AddInt64(&q.pending, -int64(seg.count))
+				return batch, seg.count, fmt.Errorf("failed to open spill segment: %w", err)
+			}
+			q.r, q.br = f, bufio.NewReaderSize(f, 256*1024)
+			q.rName, q.rLeft = seg.name, seg.count
+		}
+
+		if q.rLeft == 0 {
+			q.closeReaderLocked()
+			continue
+		}
+		work, err := readDirWork(q.br)
+		if err != nil {
+			lost := q.rLeft
+			atomic.AddInt64(&q.pending, -int64(lost))
+			q.closeReaderLocked()
+			return batch, lost, fmt.Errorf("failed to read spill segment: %w", err)
+		}
+		q.rLeft--
+		atomic.AddInt64(&q.pending, -1)
+		batch = append(batch, work)
+	}
+	return batch, 0, nil
+}
+
+func (q *spillQueue) closeReaderLocked() {
+	q.r.Close()
+	os.Remove(q.rName)
+	q.r, q.br, q.rLeft = nil, nil, 0
+}
+
+func readDirWork(r *bufio.Reader) (dirWork, error) {
+	var fields [5]uint64
+	for i := range fields {
+		v, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return dirWork{}, err
+		}
+		fields[i] = v
+	}
+	path := make([]byte, fields[4])
+	if _, err := io.ReadFull(r, path); err != nil {
+		return dirWork{}, err
+	}
+	return dirWork{
+		path:     string(path),
+		dirID:    int64(fields[0]),
+		parentID: int64(fields[1]),
+		depth:    int(fields[2]),
+		rootDev:  fields[3],
+	}, nil
+}
+
+// close removes all segment files.
+func (q *spillQueue) close() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.w != nil {
+		q.w.Close()
+	}
+	if q.r != nil {
+		q.r.Close()
+	}
+	if q.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(q.dir)
+}
@@ -0,0 +1,74 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errTimedOut is returned when a filesystem call exceeds its deadline.
+var errTimedOut = errors.New("operation timed out")
+
+// callWithTimeout runs fn and waits at most d for it to return. A zero
+// duration runs fn inline. On timeout the call is abandoned in its own
+// goroutine, which exits on its own if the server ever answers; the caller
+// carries on as a fresh worker would.
+func callWithTimeout[T any](ctx context.Context, d time.Duration, fn func() (T, error)) (T, error) {
+	return callWithRelease(ctx, d, fn, nil)
+}
+
+// callWithRelease is callWithTimeout for calls that return a resource. If the
+// call is abandoned and later succeeds, release is applied to its result so
+// the resource isn't leaked.
+func callWithRelease[T any](ctx context.Context, d time.Duration, fn func() (T, error), release func(T)) (T, error) {
+	if d <= 0 {
+		return fn()
+	}
+
+	type result struct {
+		val T
+		err error
+	}
+	var mu sync.Mutex
+	abandoned := false
+	done := make(chan result, 1)
+	go func() {
+		val, err := fn()
+		mu.Lock()
+		defer mu.Unlock()
+		if abandoned {
+			if err == nil && release != nil {
+				release(val)
+			}
+			return
+		}
+		done <- result{val: val, err: err}
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	abandon := func(err error) (T, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		// The call may have finished while we were giving up on it.
+		select {
+		case r := <-done:
+			return r.val, r.err
+		default:
+		}
+		abandoned = true
+		var zero T
+		return zero, err
+	}
+
+	select {
+	case r := <-done:
+		return r.val, r.err
+	case <-timer.C:
+		return abandon(errTimedOut)
+	case <-ctx.Done():
+		return abandon(ctx.Err())
+	}
+}
@@ -0,0 +1,197 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const tuneInterval = 2 * time.Second
+
+// ConcurrencySample records the active worker count chosen at one point of
+// an adaptive scan along with the measurements behind it.
+type ConcurrencySample struct {
+	Time       time.Time
+	Workers    int
+	OpsPerSec  float64
+	AvgLatency time.Duration
+}
+
+// tuner grows and shrinks the active worker pool from observed readdir and
+// lstat latency (AIMD): one more worker while throughput keeps up and
+// latency stays near its baseline, half as many once latency doubles.
+// A nil tuner means a fixed pool and every method is a no-op.
+type tuner struct {
+	min, max int
+	active   atomic.Int64
+	opCount  atomic.Int64
+	opNanos  atomic.Int64
+	verbose  bool
+
+	mu       sync.Mutex
+	wake     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+	samples  []ConcurrencySample
+
+	// Measurements from the previous tick, owned by run.
+	lastCount, lastNanos int64
+	lastTick             time.Time
+	lastThroughput       float64
+	baseline             time.Duration
+}
+
+func newTuner(min, max int, verbose bool) *tuner {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	t := &tuner{
+		min:     min,
+		max:     max,
+		verbose: verbose,
+		wake:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	t.active.Store(int64(min))
+	return t
+}
+
+// record adds the latency of one filesystem call.
+func (t *tuner) record(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.opCount.Add(1)
+	t.opNanos.Add(int64(d))
+}
+
+// admit blocks a worker whose id is above the active limit until it is
+// needed again or the scan ends. It returns false if ctx is done.
+func (t *tuner) admit(ctx context.Context, id int) bool {
+	if t == nil {
+		return true
+	}
+	for int64(id) >= t.active.Load() {
+		t.mu.Lock()
+		wake := t.wake
+		t.mu.Unlock()
+		select {
+		case <-wake:
+		case <-t.stopped:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// stop releases all parked workers so they can observe the closed queue.
+func (t *tuner) stop() {
+	if t == nil {
+		return
+	}
+	t.stopOnce.Do(func() { close(t.stopped) })
+}
+
+func (t *tuner) setActive(n int) {
+	t.active.Store(int64(n))
+	t.mu.Lock()
+	close(t.wake)
+	t.wake = make(chan struct{})
+	t.mu.Unlock()
+}
+
+// run adjusts the pool every tuneInterval until ctx is done or stop is called.
+func (t *tuner) run(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	ticker := time.NewTicker(tuneInterval)
+	defer ticker.Stop()
+
+	t.lastTick = time.Now()
+	t.addSample(t.lastTick, int(t.active.Load()), 0, 0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.stopped:
+			return
+		case now := <-ticker.C:
+			t.adjust(now)
+		}
+	}
+}
+
+// adjust picks the next worker count from the calls recorded since the
+// previous tick and returns it.
+func (t *tuner) adjust(now time.Time) int {
+	active := int(t.active.Load())
+	count := t.opCount.Load()
+	nanos := t.opNanos.Load()
+	ops := count - t.lastCount
+	opNanos := nanos - t.lastNanos
+	elapsed := now.Sub(t.lastTick).Seconds()
+	t.lastCount, t.lastNanos, t.lastTick = count, nanos, now
+	if ops == 0 || elapsed <= 0 {
+		return active
+	}
+
+	latency := time.Duration(opNanos / ops)
+	throughput := float64(ops) / elapsed
+
+	// The baseline is the best latency seen, allowed to drift up
+	// slowly so a permanently slower server isn't punished forever.
+	if t.baseline == 0 || latency < t.baseline {
+		t.baseline = latency
+	} else {
+		t.baseline += t.baseline / 50
+	}
+
+	next := active
+	switch {
+	case latency > 2*t.baseline:
+		next = max(t.min, active/2)
+	case throughput >= t.lastThroughput*0.95:
+		next = min(t.max, active+1)
+	}
+	t.lastThroughput = throughput
+
+	if next != active {
+		t.setActive(next)
+		if t.verbose {
+			fmt.Fprintf(os.Stderr, "[TUNER] workers=%d->%d ops=%.0f/s latency=%s baseline=%s\n",
+				active, next, throughput, latency, t.baseline)
+		}
+	}
+	t.addSample(now, next, throughput, latency)
+	return next
+}
+
+func (t *tuner) addSample(now time.Time, workers int, throughput float64, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples = append(t.samples, ConcurrencySample{
+		Time:       now,
+		Workers:    workers,
+		OpsPerSec:  throughput,
+		AvgLatency: latency,
+	})
+}
+
+// Samples returns the recorded concurrency history.
+func (t *tuner) Samples() []ConcurrencySample {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ConcurrencySample(nil), t.samples...)
+}
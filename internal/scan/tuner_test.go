@@ -0,0 +1,87 @@
+package scan
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTunerGrowsAndShrinks(t *testing.T) {
+	tu := newTuner(2, 5, false)
+	start := time.Unix(1700000000, 0)
+	tu.lastTick = start
+
+	// Each tick records 1000 calls at the given latency, so throughput is
+	// steady and only latency moves the pool.
+	ticks := []struct {
+		latency time.Duration
+		ops     int
+		want    int
+	}{
+		{time.Millisecond, 1000, 3},
+		{time.Millisecond, 1000, 4},
+		{time.Millisecond, 1000, 5},
+		{time.Millisecond, 1000, 5},      // capped at MaxWorkers
+		{10 * time.Millisecond, 1000, 2}, // latency doubled: halve
+		{10 * time.Millisecond, 1000, 2}, // floored at MinWorkers
+		{0, 0, 2},                        // an idle tick changes nothing
+		{time.Millisecond, 1000, 3},
+		{time.Millisecond, 100, 3}, // throughput fell: hold
+	}
+	now := start
+	var want []ConcurrencySample
+	for i, tick := range ticks {
+		for range tick.ops {
+			tu.record(tick.latency)
+		}
+		now = now.Add(tuneInterval)
+		if got := tu.adjust(now); got != tick.want {
+			t.Fatalf("tick %d: %d workers, want %d", i, got, tick.want)
+		}
+		if got := int(tu.active.Load()); got != tick.want {
+			t.Fatalf("tick %d: active limit %d, want %d", i, got, tick.want)
+		}
+		if tick.ops > 0 {
+			want = append(want, ConcurrencySample{
+				Time:       now,
+				Workers:    tick.want,
+				OpsPerSec:  float64(tick.ops) / tuneInterval.Seconds(),
+				AvgLatency: tick.latency,
+			})
+		}
+	}
+
+	samples := tu.Samples()
+	if len(samples) != len(want) {
+		t.Fatalf("got %d samples, want %d: %+v", len(samples), len(want), samples)
+	}
+	for i, s := range samples {
+		if !s.Time.Equal(want[i].Time) || s.Workers != want[i].Workers || s.OpsPerSec != want[i].OpsPerSec || s.AvgLatency != want[i].AvgLatency {
+			t.Errorf("sample %d is %+v, want %+v", i, s, want[i])
+		}
+	}
+}
+
+func TestTunerParksWorkersAboveLimit(t *testing.T) {
+	tu := newTuner(1, 3, false)
+	admitted := make(chan bool)
+	go func() { admitted <- tu.admit(context.Background(), 2) }()
+
+	select {
+	case <-admitted:
+		t.Fatal("worker 2 admitted with one active worker")
+	case <-time.After(20 * time.Millisecond):
+	}
+	tu.setActive(3)
+	if !<-admitted {
+		t.Fatal("worker 2 not admitted after the pool grew")
+	}
+
+	tu.setActive(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { admitted <- tu.admit(ctx, 2) }()
+	cancel()
+	if <-admitted {
+		t.Fatal("worker admitted after its context was cancelled")
+	}
+}
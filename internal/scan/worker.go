@@ -2,15 +2,16 @@ package scan
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	"github.com/michaelscutari/dug/internal/entry"
 	"github.com/michaelscutari/dug/internal/rollup"
+	"github.com/michaelscutari/dug/internal/source"
 )
 
 // DEBUG: Use scan options to control verbosity.
@@ -21,7 +22,6 @@ type Worker struct {
 	id       int
 	opts     *ScanOptions
 	root     string
-	rootDev  uint64
 	entryCh  chan<- entry.Entry
 	dirCh    chan<- entry.Dir
 	errorCh  chan<- entry.ScanError
@@ -29,16 +29,23 @@ type Worker struct {
 	dirQueue chan dirWork
 	inFlight *int64
 	stack    []dirWork
-	dirIDSeq *int64
+	spill    *spillQueue
+	queueMem *int64 // bytes held in all worker stacks
+
+	archiveMem *int64 // bytes of archive listings held by all workers
+
+	pendingDirs func() int64 // aggregator backlog, nil when not tracked
+	dirIDSeq    *int64
+	tuner       *tuner
+	src         source.Source
 }
 
 // NewWorker creates a new worker.
-func NewWorker(id int, opts *ScanOptions, root string, rootDev uint64, entryCh chan<- entry.Entry, dirCh chan<- entry.Dir, errorCh chan<- entry.ScanError, dirResCh chan<- rollup.DirResult, dirQueue chan dirWork, inFlight *int64, dirIDSeq *int64) *Worker {
+func NewWorker(id int, opts *ScanOptions, root string, entryCh chan<- entry.Entry, dirCh chan<- entry.Dir, errorCh chan<- entry.ScanError, dirResCh chan<- rollup.DirResult, dirQueue chan dirWork, inFlight *int64, dirIDSeq *int64) *Worker {
 	return &Worker{
 		id:       id,
 		opts:     opts,
 		root:     root,
-		rootDev:  rootDev,
 		entryCh:  entryCh,
 		dirCh:    dirCh,
 		errorCh:  errorCh,
@@ -46,6 +53,10 @@ func NewWorker(id int, opts *ScanOptions, root string, rootDev uint64, entryCh c
 		dirQueue: dirQueue,
 		inFlight: inFlight,
 		dirIDSeq: dirIDSeq,
+		src:      opts.source(),
+		queueMem: new(int64),
+
+		archiveMem: new(int64),
 	}
 }
 
@@ -70,9 +81,14 @@ func (w *Worker) Run(ctx context.Context) {
 			fmt.Fprintf(os.Stderr, "[W%d] LOOP#%d inFlight=%d queueLen=%d stackLen=%d\n", w.id, loopCount, inFlight, len(w.dirQueue), len(w.stack))
 		}
 
+		if err := w.opts.Gate.Wait(ctx); err != nil {
+			return
+		}
+
 		if len(w.stack) > 0 {
 			work := w.stack[len(w.stack)-1]
 			w.stack = w.stack[:len(w.stack)-1]
+			atomic.AddInt64(w.queueMem, -workSize(work))
 			if w.opts.Verbose && loopCount%500 == 0 {
 				fmt.Fprintf(os.Stderr, "[W%d] POP-STACK depth=%d stackLen=%d path=%s\n", w.id, work.depth, len(w.stack), work.path)
 			}
@@ -80,6 +96,17 @@ func (w *Worker) Run(ctx context.Context) {
 			continue
 		}
 
+		// Spilled work is only reloaded once this worker's stack is empty,
+		// and always before it parks or blocks, so nothing is stranded on disk
+		if w.spill.Len() > 0 && w.reloadSpill(ctx) {
+			continue
+		}
+
+		// Idle workers above the adaptive limit park here
+		if !w.tuner.admit(ctx, w.id) {
+			return
+		}
+
 		if w.opts.Verbose && loopCount%1000 == 0 {
 			fmt.Fprintf(os.Stderr, "[W%d] WAITING-QUEUE inFlight=%d queueLen=%d\n", w.id, inFlight, len(w.dirQueue))
 		}
@@ -119,8 +146,22 @@ func (w *Worker) ProcessDirectory(ctx context.Context, work dirWork) {
 		fmt.Fprintf(os.Stderr, "[W%d] READDIR-START depth=%d path=%s\n", w.id, depth, dirPath)
 	}
 
+	if err := w.opts.Limiter.Wait(ctx); err != nil {
+		return
+	}
 	readStart := time.Now()
-	dirEntries, err := os.ReadDir(dirPath)
+	dir, err := callWithRelease(ctx, w.opts.ReadDirTimeout, func() (source.Dir, error) {
+		return source.OpenDir(w.src, dirPath)
+	}, func(d source.Dir) { d.Close() })
+	var dirEntries []source.DirEntry
+	if err == nil {
+		defer dir.Close()
+		dirEntries, err = callWithTimeout(ctx, w.opts.ReadDirTimeout, dir.ReadDir)
+	}
+	w.tuner.record(time.Since(readStart))
+	if ctx.Err() != nil {
+		return
+	}
 	if w.opts.Verbose {
 		if took := time.Since(readStart); took > slowOpThreshold {
 			fmt.Fprintf(os.Stderr, "[W%d] READDIR-SLOW depth=%d took=%s path=%s\n", w.id, depth, took, dirPath)
@@ -135,6 +176,11 @@ func (w *Worker) ProcessDirectory(ctx context.Context, work dirWork) {
 		}
 	}
 
+	if errors.Is(err, errTimedOut) {
+		w.reportTimeout(ctx, dirPath, work.dirID, fmt.Sprintf("readdir timed out after %s", w.opts.ReadDirTimeout))
+		w.emitDirResult(ctx, rollup.DirResult{DirID: work.dirID, ParentID: work.parentID})
+		return
+	}
 	if err != nil {
 		// Non-blocking send - drop error if channel full (errors are sampled anyway)
 		select {
@@ -144,83 +190,110 @@ func (w *Worker) ProcessDirectory(ctx context.Context, work dirWork) {
 		}:
 		default:
 		}
-		w.emitDirResult(ctx, work.dirID, work.parentID, 0, 0, 0, 0)
+		w.emitDirResult(ctx, rollup.DirResult{DirID: work.dirID, ParentID: work.parentID})
 		return
 	}
 
-	var fileSize int64
-	var fileBlocks int64
-	var fileCount int64
+	res := rollup.DirResult{DirID: work.dirID, ParentID: work.parentID}
 	childDirs := make([]dirWork, 0, 16)
 
 	for i, de := range dirEntries {
-		// Check for cancellation every 100 entries and at start
-		if i%100 == 0 && ctx.Err() != nil {
+		// Check for cancellation and pauses every 100 entries and at start
+		if i%100 == 0 && (ctx.Err() != nil || w.opts.Gate.Wait(ctx) != nil) {
 			if w.opts.Verbose {
 				fmt.Fprintf(os.Stderr, "[W%d] CTX-CANCEL in loop\n", w.id)
 			}
 			return
 		}
 
-		childPath := filepath.Join(dirPath, de.Name())
+		childPath := filepath.Join(dirPath, de.Name)
 
 		if w.opts.ShouldExclude(childPath) {
 			continue
 		}
 
-		// Always use Lstat to avoid following symlinks
-		statStart := time.Now()
-		info, err := os.Lstat(childPath)
-		if w.opts.Verbose {
-			if took := time.Since(statStart); took > slowOpThreshold {
-				fmt.Fprintf(os.Stderr, "[W%d] LSTAT-SLOW depth=%d took=%s path=%s\n", w.id, depth, took, childPath)
+		// A subdirectory contributes nothing from its own inode, so when the
+		// listing already says it's a directory the stat can be skipped. With
+		// --xdev only its device ID is needed, which is cheaper to ask for.
+		var st source.Stat
+		if de.Kind == entry.KindDir && !w.opts.Xdev {
+			st.Kind = entry.KindDir
+		} else {
+			if err := w.opts.Limiter.Wait(ctx); err != nil {
+				return
 			}
-		}
-		if err != nil {
+
+			// Always use Lstat to avoid following symlinks
+			name, devOnly := de.Name, de.Kind == entry.KindDir
+			statStart := time.Now()
+			st, err = callWithTimeout(ctx, w.opts.StatTimeout, func() (source.Stat, error) {
+				if devOnly {
+					// Something replaced since the listing needs a full stat
+					if st, err := source.StatDev(dir, name); err != nil || st.Kind == entry.KindDir {
+						return st, err
+					}
+				}
+				return dir.Lstat(name)
+			})
+			w.tuner.record(time.Since(statStart))
 			if w.opts.Verbose {
-				fmt.Fprintf(os.Stderr, "[W%d] LSTAT-ERR path=%s err=%v\n", w.id, childPath, err)
+				if took := time.Since(statStart); took > slowOpThreshold {
+					fmt.Fprintf(os.Stderr, "[W%d] LSTAT-SLOW depth=%d took=%s path=%s\n", w.id, depth, took, childPath)
+				}
 			}
-			// Non-blocking send - drop error if channel full (errors are sampled anyway)
-			select {
-			case w.errorCh <- entry.ScanError{
-				Path:    childPath,
-				Message: err.Error(),
-			}:
-			default:
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, errTimedOut) {
+				// The server is unlikely to answer the remaining children either;
+				// keep what was gathered and report the directory as partial.
+				w.reportTimeout(ctx, childPath, work.dirID, fmt.Sprintf("lstat timed out after %s", w.opts.StatTimeout))
+				break
+			}
+			if err != nil {
+				if w.opts.Verbose {
+					fmt.Fprintf(os.Stderr, "[W%d] LSTAT-ERR path=%s err=%v\n", w.id, childPath, err)
+				}
+				// Non-blocking send - drop error if channel full (errors are sampled anyway)
+				select {
+				case w.errorCh <- entry.ScanError{
+					Path:    childPath,
+					Message: err.Error(),
+				}:
+				default:
+				}
+				continue
 			}
-			continue
-		}
-
-		// Get device ID, inode, and blocks from stat
-		var devID, inode uint64
-		var blocks int64
-		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-			devID = uint64(stat.Dev)
-			inode = stat.Ino
-			blocks = stat.Blocks * 512 // st_blocks is in 512-byte units
 		}
 
 		// Cross-device check
-		if w.opts.Xdev && devID != 0 && devID != w.rootDev {
+		if w.opts.Xdev && st.DevID != 0 && st.DevID != work.rootDev {
 			continue
 		}
 
-		kind := entry.KindFromMode(info.Mode())
+		kind := st.Kind
+
+		// Sharded scans split the root's children between processes
+		if depth == 0 && !w.opts.ownsTopLevel(de.Name, kind) {
+			continue
+		}
 
 		// Queue subdirectories for processing (fallback to local stack if queue is full)
 		if kind == entry.KindFile {
-			fileSize += info.Size()
-			fileBlocks += blocks
-			fileCount++
+			res.FileSize += st.Size
+			res.FileBlocks += st.Blocks
+			res.FileCount++
+			res.FileSlack += entry.Slack(st.Size, st.Blocks)
+			res.FileSparse += entry.SparseSavings(st.Size, st.Blocks)
 			e := entry.Entry{
 				ParentID: work.dirID,
-				Name:     de.Name(),
+				Name:     de.Name,
 				Kind:     kind,
-				Size:     info.Size(),
-				Blocks:   blocks,
-				ModTime:  info.ModTime(),
-				DevID:    devID,
-				Inode:    inode,
+				Size:     st.Size,
+				Blocks:   st.Blocks,
+				ModTime:  st.ModTime,
+				DevID:    st.DevID,
+				Inode:    st.Inode,
 			}
 			select {
 			case w.entryCh <- e:
@@ -236,12 +309,15 @@ func (w *Worker) ProcessDirectory(ctx context.Context, work dirWork) {
 					return
 				}
 			}
+			if w.opts.DescendArchives && source.IsArchive(de.Name) {
+				w.descendArchive(ctx, work, childPath, de.Name, st.Size)
+			}
 		} else if kind == entry.KindDir {
 			childID := atomic.AddInt64(w.dirIDSeq, 1)
 			dirEntry := entry.Dir{
 				ID:       childID,
 				Path:     childPath,
-				Name:     de.Name(),
+				Name:     de.Name,
 				ParentID: work.dirID,
 				Depth:    depth + 1,
 			}
@@ -250,17 +326,17 @@ func (w *Worker) ProcessDirectory(ctx context.Context, work dirWork) {
 			case <-ctx.Done():
 				return
 			}
-			childDirs = append(childDirs, dirWork{path: childPath, dirID: childID, parentID: work.dirID, depth: depth + 1})
+			childDirs = append(childDirs, dirWork{path: childPath, dirID: childID, parentID: work.dirID, depth: depth + 1, rootDev: work.rootDev})
 		} else {
 			e := entry.Entry{
 				ParentID: work.dirID,
-				Name:     de.Name(),
+				Name:     de.Name,
 				Kind:     kind,
-				Size:     info.Size(),
-				Blocks:   blocks,
-				ModTime:  info.ModTime(),
-				DevID:    devID,
-				Inode:    inode,
+				Size:     st.Size,
+				Blocks:   st.Blocks,
+				ModTime:  st.ModTime,
+				DevID:    st.DevID,
+				Inode:    st.Inode,
 			}
 			select {
 			case w.entryCh <- e:
@@ -279,7 +355,8 @@ func (w *Worker) ProcessDirectory(ctx context.Context, work dirWork) {
 		}
 	}
 
-	w.emitDirResult(ctx, work.dirID, work.parentID, fileSize, fileBlocks, fileCount, len(childDirs))
+	res.ChildCount = len(childDirs)
+	w.emitDirResult(ctx, res)
 
 	for i := len(childDirs) - 1; i >= 0; i-- {
 		w.enqueueOrStack(ctx, childDirs[i])
@@ -303,35 +380,111 @@ func (w *Worker) enqueueOrStack(ctx context.Context, work dirWork) {
 	}
 
 	newInFlight := atomic.AddInt64(w.inFlight, 1)
-	select {
-	case w.dirQueue <- work:
-		if w.opts.Verbose && newInFlight%1000 == 0 {
-			fmt.Fprintf(os.Stderr, "[W%d] ENQUEUE inFlight=%d queueLen=%d depth=%d\n", w.id, newInFlight, len(w.dirQueue), work.depth)
+
+	// When the aggregator is holding too many unfinished directories, stop
+	// sharing new ones: keeping them on the local stack makes this worker
+	// finish its subtree depth-first, which lets those rollups complete.
+	if !w.backpressured() {
+		select {
+		case w.dirQueue <- work:
+			if w.opts.Verbose && newInFlight%1000 == 0 {
+				fmt.Fprintf(os.Stderr, "[W%d] ENQUEUE inFlight=%d queueLen=%d depth=%d\n", w.id, newInFlight, len(w.dirQueue), work.depth)
+			}
+			return
+		default:
 		}
-		return
-	default:
-		// Queue full: keep work local to avoid deadlock
-		w.stack = append(w.stack, work)
-		if w.opts.Verbose && len(w.stack)%100 == 1 {
-			fmt.Fprintf(os.Stderr, "[W%d] STACK-FULL queueLen=%d stackLen=%d inFlight=%d depth=%d path=%s\n", w.id, len(w.dirQueue), len(w.stack), newInFlight, work.depth, work.path)
+	}
+
+	// Queue full: keep work local to avoid deadlock, spilling to disk once
+	// the stacks exceed the memory budget
+	if w.spill != nil && atomic.LoadInt64(w.queueMem) >= w.opts.QueueMemory {
+		lost, err := w.spill.push(work)
+		if err == nil {
+			return
 		}
+		if err != errSpillDisabled {
+			w.opts.warn(fmt.Errorf("%w; keeping directory queue in memory", err))
+			w.dropSpilled(ctx, lost, err)
+		}
+	}
+	w.stack = append(w.stack, work)
+	atomic.AddInt64(w.queueMem, workSize(work))
+	if w.opts.Verbose && len(w.stack)%100 == 1 {
+		fmt.Fprintf(os.Stderr, "[W%d] STACK-FULL queueLen=%d stackLen=%d inFlight=%d depth=%d path=%s\n", w.id, len(w.dirQueue), len(w.stack), newInFlight, work.depth, work.path)
 	}
 }
 
-func (w *Worker) emitDirResult(ctx context.Context, dirID, parentID int64, size, blocks, files int64, childCount int) {
-	if ctx.Err() != nil {
+func (w *Worker) backpressured() bool {
+	return w.pendingDirs != nil && w.opts.MaxPendingDirs > 0 && w.pendingDirs() >= int64(w.opts.MaxPendingDirs)
+}
+
+// reloadSpill moves a batch of spilled work back into memory, handing as much
+// as fits to other workers through the queue. It reports whether any work was
+// reloaded.
+func (w *Worker) reloadSpill(ctx context.Context) bool {
+	batch, lost, err := w.spill.popBatch(spillBatch)
+	if err != nil {
+		w.opts.warn(err)
+		w.dropSpilled(ctx, lost, err)
+	}
+	shared := !w.backpressured()
+	for _, work := range batch {
+		if shared {
+			select {
+			case w.dirQueue <- work:
+				continue
+			default:
+			}
+		}
+		w.stack = append(w.stack, work)
+		atomic.AddInt64(w.queueMem, workSize(work))
+	}
+	return len(batch) > 0
+}
+
+// dropSpilled settles the accounting for spilled work that can't be read
+// back. Those directories never report to the aggregator, so the scan still
+// ends and then fails with incomplete rollups rather than hanging.
+func (w *Worker) dropSpilled(ctx context.Context, lost int, err error) {
+	if lost == 0 {
 		return
 	}
+	atomic.AddInt64(w.inFlight, -int64(lost))
+	select {
+	case w.errorCh <- entry.ScanError{Path: w.root, Message: fmt.Sprintf("%d queued directories lost: %v", lost, err)}:
+	case <-ctx.Done():
+	}
+}
 
+// workSize estimates the memory a queued dirWork holds.
+func workSize(work dirWork) int64 {
+	return int64(len(work.path)) + dirWorkOverhead
+}
+
+// reportTimeout records a timed-out directory. Unlike other errors it is never
+// dropped, since the ingester also uses it to flag the directory row.
+func (w *Worker) reportTimeout(ctx context.Context, path string, dirID int64, msg string) {
+	if w.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "[W%d] TIMEOUT dir=%d msg=%q path=%s\n", w.id, dirID, msg, path)
+	}
 	select {
-	case w.dirResCh <- rollup.DirResult{
-		DirID:      dirID,
-		ParentID:   parentID,
-		FileSize:   size,
-		FileBlocks: blocks,
-		FileCount:  files,
-		ChildCount: childCount,
+	case w.errorCh <- entry.ScanError{
+		Path:     path,
+		Message:  msg,
+		DirID:    dirID,
+		TimedOut: true,
 	}:
 	case <-ctx.Done():
 	}
 }
+
+func (w *Worker) emitDirResult(ctx context.Context, res rollup.DirResult) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	select {
+	case w.dirResCh <- res:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,111 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/michaelscutari/dug/internal/db"
+)
+
+// Archive modes for snapshots past retention.
+const (
+	ArchiveNone    = ""        // delete them
+	ArchiveGzip    = "gzip"    // vacuum and gzip them whole
+	ArchiveSummary = "summary" // keep directories and rollups down to a depth
+)
+
+// ArchiveDir is the subdirectory of the output directory that archived
+// snapshots are moved to.
+const ArchiveDir = "archive"
+
+// DefaultArchiveDepth is how deep summary archives keep directories.
+const DefaultArchiveDepth = 3
+
+// SetArchive makes retention archive snapshots instead of deleting them,
+// in mode gzip or summary. Depth is how many levels below the top summary
+// archives keep.
+func (m *Manager) SetArchive(mode string, depth int) {
+	m.archive = mode
+	m.archiveDepth = depth
+}
+
+// archiveSnapshot compacts the snapshot name into the archive directory.
+// The original is left for the caller to remove.
+func (m *Manager) archiveSnapshot(name string) error {
+	dir := filepath.Join(m.outputDir, ArchiveDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	// VACUUM INTO writes a compact copy without touching the original
+	compact := filepath.Join(dir, "."+name+".tmp")
+	os.Remove(compact)
+	defer os.Remove(compact)
+	if err := vacuumInto(filepath.Join(m.outputDir, name), compact); err != nil {
+		return err
+	}
+
+	switch m.archive {
+	case ArchiveSummary:
+		database, err := sql.Open("sqlite", compact)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", compact, err)
+		}
+		err = db.Summarize(database, m.archiveDepth)
+		database.Close()
+		if err != nil {
+			return err
+		}
+		return os.Rename(compact, filepath.Join(dir, name))
+	default:
+		return gzipFile(compact, filepath.Join(dir, name+db.CompressedExt))
+	}
+}
+
+func vacuumInto(src, dst string) error {
+	database, err := db.OpenReadOnly(src)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	if _, err := database.Exec(`VACUUM INTO ?`, dst); err != nil {
+		return fmt.Errorf("failed to compact %s: %w", src, err)
+	}
+	return nil
+}
+
+// gzipFile compresses src to dst, which appears only once complete.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rename %s: %w", tmp, err)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package snapshot
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,33 +13,50 @@ import (
 	"time"
 
 	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/merge"
 	"github.com/michaelscutari/dug/internal/scan"
 
 	_ "modernc.org/sqlite"
 )
 
+// ErrLocked is returned when another scan or merge holds the output
+// directory's lock.
+var ErrLocked = errors.New("another scan is in progress")
+
 // ProgressFunc is called periodically with current scan progress.
 type ProgressFunc func(files, dirs, errors int64, totalBytes int64)
 
 // StageFunc is called when scan stage changes.
 type StageFunc func(stage string)
 
+// WarnFunc is called with problems that do not fail the snapshot.
+type WarnFunc func(err error)
+
 // Manager handles the scan lifecycle including locking and retention.
 type Manager struct {
 	outputDir    string
-	retention    int
+	policy       Policy
+	archive      string
+	archiveDepth int
 	lockFile     *os.File
 	progressFunc ProgressFunc
 	stageFunc    StageFunc
+	warnFunc     WarnFunc
 	indexMode    string
+	searchIndex  bool
 	sqliteTmpDir string
+	sqliteCache  int64
+	sqliteMmap   int64
+	version      string
 }
 
-// NewManager creates a new snapshot manager.
+// NewManager creates a new snapshot manager that keeps the newest
+// retention snapshots, or all of them when retention is 0.
 func NewManager(outputDir string, retention int) *Manager {
 	return &Manager{
-		outputDir: outputDir,
-		retention: retention,
+		outputDir:    outputDir,
+		policy:       Policy{Last: retention},
+		archiveDepth: DefaultArchiveDepth,
 	}
 }
 
@@ -52,18 +70,97 @@ func (m *Manager) SetStageFunc(f StageFunc) {
 	m.stageFunc = f
 }
 
+// SetWarnFunc sets a callback for warnings, which are printed to stderr
+// when none is set. It also receives the scanner's warnings unless the scan
+// options set their own.
+func (m *Manager) SetWarnFunc(f WarnFunc) {
+	m.warnFunc = f
+}
+
+func (m *Manager) warn(err error) {
+	if m.warnFunc != nil {
+		m.warnFunc(err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+}
+
 // SetIndexMode sets the index build mode: memory|disk|skip.
 func (m *Manager) SetIndexMode(mode string) {
 	m.indexMode = mode
 }
 
+// SetSearchIndex enables the name index used by db.Search. It is built with
+// the other indexes, so index mode skip leaves it out too.
+func (m *Manager) SetSearchIndex(enabled bool) {
+	m.searchIndex = enabled
+}
+
 // SetSQLiteTmpDir sets the temp directory for SQLite during index build.
 func (m *Manager) SetSQLiteTmpDir(dir string) {
 	m.sqliteTmpDir = dir
 }
 
+// SetVersion sets the dug version recorded in each snapshot's schema_info.
+func (m *Manager) SetVersion(v string) {
+	m.version = v
+}
+
+// SetSQLiteMemory overrides the SQLite page cache and mmap window sizes in
+// bytes. Zero cache keeps the defaults.
+func (m *Manager) SetSQLiteMemory(cache, mmap int64) {
+	m.sqliteCache = cache
+	m.sqliteMmap = mmap
+}
+
 // RunScan executes a complete scan workflow.
 func (m *Manager) RunScan(ctx context.Context, root string, opts *scan.ScanOptions) (string, error) {
+	return m.RunScanRoots(ctx, []string{root}, opts)
+}
+
+// RunScanRoots executes a complete scan workflow over several roots, which
+// end up in one snapshot under a synthetic top directory.
+func (m *Manager) RunScanRoots(ctx context.Context, roots []string, opts *scan.ScanOptions) (string, error) {
+	return m.build(func(database *sql.DB) error {
+		return m.scanInto(ctx, database, roots, opts)
+	})
+}
+
+// RunMerge builds a snapshot by merging existing snapshot databases.
+func (m *Manager) RunMerge(ctx context.Context, inputs []string) (string, error) {
+	return m.build(m.mergeFill(ctx, inputs))
+}
+
+// MergeFile merges existing snapshot databases into a standalone database
+// at path, outside the snapshot rotation. The database is built in the
+// output directory, which should be path's, and renamed into place.
+func (m *Manager) MergeFile(ctx context.Context, inputs []string, path string) error {
+	if err := os.MkdirAll(m.outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	tempPath, err := m.fillTemp(m.mergeFill(ctx, inputs))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename database: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) mergeFill(ctx context.Context, inputs []string) func(*sql.DB) error {
+	return func(database *sql.DB) error {
+		if m.stageFunc != nil {
+			m.stageFunc("merge")
+		}
+		return merge.Merge(ctx, database, inputs)
+	}
+}
+
+// build creates a temp database, lets fill populate it, then indexes,
+// finalizes and installs it as the newest snapshot.
+func (m *Manager) build(fill func(database *sql.DB) error) (string, error) {
 	// Ensure output directory exists
 	if err := os.MkdirAll(m.outputDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create output directory: %w", err)
@@ -75,6 +172,44 @@ func (m *Manager) RunScan(ctx context.Context, root string, opts *scan.ScanOptio
 	}
 	defer m.releaseLock()
 
+	tempPath, err := m.fillTemp(fill)
+	if err != nil {
+		return "", err
+	}
+
+	// Atomic rename to final location
+	finalName := fmt.Sprintf("dug-%s.db", time.Now().Format(snapshotTimeLayout))
+	finalPath := filepath.Join(m.outputDir, finalName)
+
+	if err := os.Rename(tempPath, finalPath); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to rename database: %w", err)
+	}
+
+	// Update latest.db symlink atomically via temp symlink + rename
+	latestPath := filepath.Join(m.outputDir, "latest.db")
+	tempLink := filepath.Join(m.outputDir, ".latest.db.tmp")
+	os.Remove(tempLink) // Clean up any stale temp link
+	if err := os.Symlink(finalName, tempLink); err == nil {
+		if err := os.Rename(tempLink, latestPath); err != nil {
+			os.Remove(tempLink)
+			m.warn(fmt.Errorf("failed to update latest.db symlink: %w", err))
+		}
+	} else {
+		m.warn(fmt.Errorf("failed to create latest.db symlink: %w", err))
+	}
+
+	// Prune old snapshots
+	if _, err := m.pruneOldSnapshots(); err != nil {
+		m.warn(fmt.Errorf("failed to prune old snapshots: %w", err))
+	}
+
+	return finalPath, nil
+}
+
+// fillTemp creates a temp database in the output directory, lets fill
+// populate it, then indexes and finalizes it. It returns the temp path.
+func (m *Manager) fillTemp(fill func(database *sql.DB) error) (string, error) {
 	// Create temp database file
 	tempPath := filepath.Join(m.outputDir, fmt.Sprintf(".dug-temp-%d.db", time.Now().UnixNano()))
 	database, err := sql.Open("sqlite", tempPath)
@@ -95,38 +230,25 @@ func (m *Manager) RunScan(ctx context.Context, root string, opts *scan.ScanOptio
 		os.Remove(tempPath)
 		return "", fmt.Errorf("failed to apply pragmas: %w", err)
 	}
-
-	// Run scan with progress reporting
-	scanner := scan.NewScanner(opts)
-	if m.stageFunc != nil {
-		m.stageFunc("scan")
-	}
-
-	// Start progress reporter if callback is set
-	progressDone := make(chan struct{})
-	if m.progressFunc != nil {
-		go func() {
-			ticker := time.NewTicker(100 * time.Millisecond)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-progressDone:
-					return
-				case <-ticker.C:
-					if p := scanner.Progress(); p != nil {
-						m.progressFunc(p.Files, p.Dirs, p.Errors, p.TotalBytes)
-					}
-				}
-			}
-		}()
+	if m.sqliteCache > 0 {
+		if err := db.ApplyCachePragmas(database, m.sqliteCache, m.sqliteMmap); err != nil {
+			database.Close()
+			os.Remove(tempPath)
+			return "", fmt.Errorf("failed to apply pragmas: %w", err)
+		}
 	}
 
-	scanErr := scanner.Run(ctx, root, database)
-	close(progressDone)
-	if scanErr != nil {
+	if err := fill(database); err != nil {
 		database.Close()
 		os.Remove(tempPath)
-		return "", fmt.Errorf("scan failed: %w", scanErr)
+		return "", err
+	}
+	if m.version != "" {
+		if err := db.SetSchemaInfo(database, db.InfoDugVersion, m.version); err != nil {
+			database.Close()
+			os.Remove(tempPath)
+			return "", err
+		}
 	}
 
 	// Build indexes
@@ -147,6 +269,21 @@ func (m *Manager) RunScan(ctx context.Context, root string, opts *scan.ScanOptio
 			os.Remove(tempPath)
 			return "", fmt.Errorf("failed to build indexes: %w", err)
 		}
+		if m.searchIndex {
+			if m.stageFunc != nil {
+				m.stageFunc("search")
+			}
+			if err := db.BuildSearchIndex(database); err != nil {
+				database.Close()
+				os.Remove(tempPath)
+				return "", err
+			}
+		}
+	}
+
+	// Record the high-water mark now that the heavy phases are done
+	if _, err := database.Exec(`UPDATE scan_meta SET peak_rss = MAX(peak_rss, ?) WHERE id = 1`, peakRSS()); err != nil {
+		m.warn(fmt.Errorf("failed to record peak RSS: %w", err))
 	}
 
 	// Finalize
@@ -161,34 +298,45 @@ func (m *Manager) RunScan(ctx context.Context, root string, opts *scan.ScanOptio
 
 	database.Close()
 
-	// Atomic rename to final location
-	finalName := fmt.Sprintf("dug-%s.db", time.Now().Format("20060102-150405"))
-	finalPath := filepath.Join(m.outputDir, finalName)
+	return tempPath, nil
+}
 
-	if err := os.Rename(tempPath, finalPath); err != nil {
-		os.Remove(tempPath)
-		return "", fmt.Errorf("failed to rename database: %w", err)
+// scanInto runs the scanner over roots with progress reporting.
+func (m *Manager) scanInto(ctx context.Context, database *sql.DB, roots []string, opts *scan.ScanOptions) error {
+	// Run scan with progress reporting
+	if opts != nil && opts.Warn == nil && m.warnFunc != nil {
+		opts.Warn = m.warnFunc
 	}
-
-	// Update latest.db symlink atomically via temp symlink + rename
-	latestPath := filepath.Join(m.outputDir, "latest.db")
-	tempLink := filepath.Join(m.outputDir, ".latest.db.tmp")
-	os.Remove(tempLink) // Clean up any stale temp link
-	if err := os.Symlink(finalName, tempLink); err == nil {
-		if err := os.Rename(tempLink, latestPath); err != nil {
-			os.Remove(tempLink)
-			fmt.Fprintf(os.Stderr, "warning: failed to update latest.db symlink: %v\n", err)
-		}
-	} else {
-		fmt.Fprintf(os.Stderr, "warning: failed to create latest.db symlink: %v\n", err)
+	scanner := scan.NewScanner(opts)
+	if m.stageFunc != nil {
+		m.stageFunc("scan")
 	}
 
-	// Prune old snapshots
-	if err := m.pruneOldSnapshots(); err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to prune old snapshots: %v\n", err)
+	// Start progress reporter if callback is set
+	progressDone := make(chan struct{})
+	if m.progressFunc != nil {
+		go func() {
+			ticker := time.NewTicker(100 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-progressDone:
+					return
+				case <-ticker.C:
+					if p := scanner.Progress(); p != nil {
+						m.progressFunc(p.Files, p.Dirs, p.Errors, p.TotalBytes)
+					}
+				}
+			}
+		}()
 	}
 
-	return finalPath, nil
+	scanErr := scanner.RunRoots(ctx, roots, database)
+	close(progressDone)
+	if scanErr != nil {
+		return fmt.Errorf("scan failed: %w", scanErr)
+	}
+	return nil
 }
 
 func (m *Manager) acquireLock() error {
@@ -201,7 +349,7 @@ func (m *Manager) acquireLock() error {
 	// Try to acquire exclusive lock
 	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
 		f.Close()
-		return fmt.Errorf("another scan is in progress")
+		return ErrLocked
 	}
 
 	m.lockFile = f
@@ -216,37 +364,27 @@ func (m *Manager) releaseLock() {
 	}
 }
 
-func (m *Manager) pruneOldSnapshots() error {
-	if m.retention <= 0 {
-		return nil
-	}
-
-	entries, err := os.ReadDir(m.outputDir)
+// pruneOldSnapshots removes the snapshots the retention policy does not
+// keep, archiving them first if asked.
+func (m *Manager) pruneOldSnapshots() ([]Plan, error) {
+	plans, err := m.PlanRetention()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// Find all dug-*.db files
-	var snapshots []string
-	for _, e := range entries {
-		if !e.IsDir() && strings.HasPrefix(e.Name(), "dug-") && strings.HasSuffix(e.Name(), ".db") {
-			snapshots = append(snapshots, e.Name())
+	for _, p := range plans {
+		if p.Keep {
+			continue
 		}
-	}
-
-	// Sort by name (which includes timestamp, so chronological)
-	sort.Strings(snapshots)
-
-	// Remove oldest if over retention
-	for len(snapshots) > m.retention {
-		oldPath := filepath.Join(m.outputDir, snapshots[0])
-		if err := os.Remove(oldPath); err != nil {
-			return fmt.Errorf("failed to remove %s: %w", snapshots[0], err)
+		if m.archive != ArchiveNone {
+			if err := m.archiveSnapshot(p.Name); err != nil {
+				return plans, fmt.Errorf("failed to archive %s: %w", p.Name, err)
+			}
+		}
+		if err := os.Remove(filepath.Join(m.outputDir, p.Name)); err != nil {
+			return plans, fmt.Errorf("failed to remove %s: %w", p.Name, err)
 		}
-		snapshots = snapshots[1:]
 	}
-
-	return nil
+	return plans, nil
 }
 
 // GetLatest returns the path to the latest snapshot.
@@ -2,11 +2,15 @@ package snapshot
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/michaelscutari/dug/internal/db"
 	"github.com/michaelscutari/dug/internal/scan"
 )
 
@@ -58,3 +62,107 @@ func TestManagerRunScanCreatesLatestAndRetention(t *testing.T) {
 		t.Fatalf("expected first db to be pruned")
 	}
 }
+
+func TestPruneArchivesPastRetention(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "b", "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	outDir := t.TempDir()
+	mgr := NewManager(outDir, 1)
+	latest, err := mgr.RunScan(context.Background(), root, scan.DefaultOptions().WithWorkers(1))
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	data, err := os.ReadFile(latest)
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+
+	for _, tc := range []struct {
+		mode, archived string
+		entries        int64
+	}{
+		{ArchiveGzip, "dug-20200101-000000.db.gz", 1},
+		{ArchiveSummary, "dug-20200101-000000.db", 0},
+	} {
+		old := filepath.Join(outDir, "dug-20200101-000000.db")
+		if err := os.WriteFile(old, data, 0644); err != nil {
+			t.Fatalf("write old snapshot: %v", err)
+		}
+		mgr.SetArchive(tc.mode, 1)
+		if _, err := mgr.pruneOldSnapshots(); err != nil {
+			t.Fatalf("%s: prune: %v", tc.mode, err)
+		}
+		if _, err := os.Stat(old); err == nil {
+			t.Fatalf("%s: expected old snapshot to be removed", tc.mode)
+		}
+
+		path, cleanup, err := db.Uncompress(filepath.Join(outDir, ArchiveDir, tc.archived))
+		if err != nil {
+			t.Fatalf("%s: uncompress: %v", tc.mode, err)
+		}
+		database, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("%s: open archive: %v", tc.mode, err)
+		}
+		var entries, deepest, files int64
+		err = database.QueryRow(`SELECT (SELECT COUNT(*) FROM entries), (SELECT MAX(depth) FROM dirs), file_count FROM scan_meta`).
+			Scan(&entries, &deepest, &files)
+		database.Close()
+		cleanup()
+		if err != nil {
+			t.Fatalf("%s: query archive: %v", tc.mode, err)
+		}
+		if entries != tc.entries || files != 1 {
+			t.Fatalf("%s: entries = %d, file_count = %d", tc.mode, entries, files)
+		}
+		if tc.mode == ArchiveSummary && deepest != 1 {
+			t.Fatalf("summary keeps depth %d, want 1", deepest)
+		}
+	}
+}
+
+func TestPlanRetentionKeepsEachPeriodsNewest(t *testing.T) {
+	outDir := t.TempDir()
+	start := time.Date(2026, 3, 31, 2, 0, 0, 0, time.Local)
+	for i := 0; i < 120; i++ {
+		name := fmt.Sprintf("dug-%s.db", start.AddDate(0, 0, -i).Format(snapshotTimeLayout))
+		if err := os.WriteFile(filepath.Join(outDir, name), nil, 0644); err != nil {
+			t.Fatalf("write snapshot: %v", err)
+		}
+	}
+	// Neither its name nor its contents say when it was taken
+	if err := os.WriteFile(filepath.Join(outDir, "dug-copy.db"), nil, 0644); err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+
+	mgr := NewManager(outDir, 1)
+	mgr.SetPolicy(Policy{Last: 1, Daily: 3, Monthly: 2, Yearly: -1})
+	plans, err := mgr.PlanRetention()
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+
+	var kept []string
+	for _, p := range plans {
+		if p.Keep {
+			kept = append(kept, p.Name+" "+strings.Join(p.Reasons, ","))
+		}
+	}
+	want := []string{
+		"dug-20260331-020000.db last,daily,monthly,yearly",
+		"dug-20260330-020000.db daily",
+		"dug-20260329-020000.db daily",
+		"dug-20260228-020000.db monthly",
+		"dug-20251231-020000.db yearly",
+		"dug-copy.db unknown time",
+	}
+	if strings.Join(kept, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("kept:\n%s\nwant:\n%s", strings.Join(kept, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,179 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/db"
+)
+
+// snapshotTimeLayout is the timestamp in snapshot names, in local time.
+const snapshotTimeLayout = "20060102-150405"
+
+// Policy says which snapshots retention keeps, in the style of
+// grandfather-father-son backup rotation. Last keeps the newest snapshots;
+// Daily, Weekly, Monthly and Yearly keep the newest snapshot of each of
+// that many recent days, ISO weeks, months and years that have one. A
+// snapshot is kept if any rule keeps it, and a negative count keeps every
+// period. A policy with no periodic rules and Last <= 0 keeps everything.
+type Policy struct {
+	Last    int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+// periodic reports whether the policy has rules beyond Last.
+func (p Policy) periodic() bool {
+	return p.Daily != 0 || p.Weekly != 0 || p.Monthly != 0 || p.Yearly != 0
+}
+
+// String describes the policy, e.g. "last 5, daily 7, yearly all".
+func (p Policy) String() string {
+	if !p.periodic() && p.Last <= 0 {
+		return "keep all"
+	}
+	var parts []string
+	for _, r := range p.rules() {
+		switch {
+		case r.count < 0:
+			parts = append(parts, r.name+" all")
+		case r.count > 0:
+			parts = append(parts, fmt.Sprintf("%s %d", r.name, r.count))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type rule struct {
+	name   string
+	count  int
+	bucket func(time.Time) string
+}
+
+func (p Policy) rules() []rule {
+	return []rule{
+		{"last", p.Last, nil},
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+// SetPolicy replaces the retention count given to NewManager with a
+// policy. Snapshots it does not keep are pruned, or archived if SetArchive
+// was called.
+func (m *Manager) SetPolicy(p Policy) {
+	m.policy = p
+}
+
+// Plan is retention's decision for one snapshot.
+type Plan struct {
+	Name    string    // file name in the output directory
+	Time    time.Time // when the snapshot was taken; zero if unknown
+	Keep    bool
+	Reasons []string // the rules that keep it
+}
+
+// PlanRetention applies the retention policy to the snapshots in the
+// output directory without changing anything. Plans are ordered newest
+// first. Snapshot times come from their names, or from scan_meta for
+// snapshots renamed by hand; snapshots with neither are always kept.
+func (m *Manager) PlanRetention() ([]Plan, error) {
+	entries, err := os.ReadDir(m.outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []Plan
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, "dug-") || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		plans = append(plans, Plan{Name: name, Time: m.snapshotTime(name)})
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		if !plans[i].Time.Equal(plans[j].Time) {
+			return plans[i].Time.After(plans[j].Time)
+		}
+		return plans[i].Name > plans[j].Name
+	})
+
+	keep := func(p *Plan, reason string) {
+		p.Keep = true
+		p.Reasons = append(p.Reasons, reason)
+	}
+	for i := range plans {
+		switch {
+		case plans[i].Time.IsZero():
+			keep(&plans[i], "unknown time")
+		case !m.policy.periodic() && m.policy.Last <= 0:
+			keep(&plans[i], "keep all")
+		}
+	}
+
+	for _, r := range m.policy.rules() {
+		left := r.count
+		last := ""
+		for i := range plans {
+			if left == 0 {
+				break
+			}
+			if plans[i].Time.IsZero() {
+				continue
+			}
+			if r.bucket != nil {
+				b := r.bucket(plans[i].Time)
+				if b == last {
+					continue
+				}
+				last = b
+			}
+			keep(&plans[i], r.name)
+			if left > 0 {
+				left--
+			}
+		}
+	}
+	return plans, nil
+}
+
+// Prune applies the retention policy to the output directory under its
+// lock, as a scan does when it finishes, and returns what it decided.
+func (m *Manager) Prune() ([]Plan, error) {
+	if err := m.acquireLock(); err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	defer m.releaseLock()
+	return m.pruneOldSnapshots()
+}
+
+// snapshotTime returns when the snapshot name was taken, from its name or
+// else its scan_meta, and the zero time if neither says.
+func (m *Manager) snapshotTime(name string) time.Time {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, "dug-"), ".db")
+	if t, err := time.ParseInLocation(snapshotTimeLayout, stamp, time.Local); err == nil {
+		return t
+	}
+
+	database, err := db.OpenReadOnly(filepath.Join(m.outputDir, name))
+	if err != nil {
+		return time.Time{}
+	}
+	defer database.Close()
+	var start int64
+	if err := database.QueryRow(`SELECT start_time FROM scan_meta WHERE id = 1`).Scan(&start); err != nil || start <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(start, 0)
+}
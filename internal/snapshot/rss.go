@@ -0,0 +1,20 @@
+package snapshot
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakRSS returns the process's maximum resident set size in bytes, or 0 if
+// it can't be read.
+func peakRSS() int64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	// ru_maxrss is in KiB on Linux and bytes on macOS
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss)
+	}
+	return int64(ru.Maxrss) * 1024
+}
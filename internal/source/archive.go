@@ -0,0 +1,169 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+// File is an open file whose contents can be read sequentially or at an offset.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+// Opener is implemented by sources that can read file contents, which is
+// needed to list the members of archives.
+type Opener interface {
+	Open(path string) (File, error)
+}
+
+// Open opens a file on the local filesystem.
+func (OS) Open(path string) (File, error) {
+	return os.Open(path)
+}
+
+// ArchiveMember is one entry listed from a tar or zip archive.
+type ArchiveMember struct {
+	Path    string // slash-separated, relative to the archive root
+	Kind    entry.Kind
+	Size    int64
+	ModTime time.Time
+}
+
+// IsArchive reports whether name has a supported archive extension.
+func IsArchive(name string) bool {
+	return archiveFormat(name) != ""
+}
+
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// ReadArchive lists the members of the archive held in f, picking the
+// format from name. size is the archive's length in bytes.
+func ReadArchive(name string, f File, size int64, fn func(ArchiveMember) error) error {
+	switch archiveFormat(name) {
+	case "tar":
+		return ReadTar(f, false, fn)
+	case "tgz":
+		return ReadTar(f, true, fn)
+	case "zip":
+		return readZip(f, size, fn)
+	}
+	return fmt.Errorf("unsupported archive %q", name)
+}
+
+// ReadTar lists the members of a tar stream, optionally gzip-compressed.
+func ReadTar(r io.Reader, gzipped bool, fn func(ArchiveMember) error) error {
+	if gzipped {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+		name := cleanMemberPath(hdr.Name)
+		if name == "" {
+			continue
+		}
+		m := ArchiveMember{
+			Path:    name,
+			Kind:    entry.KindFromMode(hdr.FileInfo().Mode()),
+			ModTime: hdr.ModTime,
+		}
+		if m.Kind == entry.KindFile {
+			m.Size = hdr.Size
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+}
+
+func readZip(r io.ReaderAt, size int64, fn func(ArchiveMember) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to open zip: %w", err)
+	}
+	for _, zf := range zr.File {
+		name := cleanMemberPath(zf.Name)
+		if name == "" {
+			continue
+		}
+		m := ArchiveMember{
+			Path:    name,
+			Kind:    entry.KindFromMode(zf.FileInfo().Mode()),
+			ModTime: zf.Modified,
+		}
+		if m.Kind == entry.KindFile {
+			m.Size = int64(zf.UncompressedSize64)
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanMemberPath turns an archive member name into a relative path that
+// cannot escape the archive root. It returns "" for the root itself.
+func cleanMemberPath(name string) string {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	return strings.TrimPrefix(name, "/")
+}
+
+// ParseTar replays a tar stream into a Memory tree, so a snapshot can be
+// built for a host dug cannot run on. Gzip compression is detected
+// automatically. Member paths are resolved against base. Tar headers carry
+// no block counts, so disk usage is left at zero.
+func ParseTar(r io.Reader, base string) (*Memory, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	gzipped := len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+
+	m := NewMemory()
+	err := ReadTar(br, gzipped, func(am ArchiveMember) error {
+		p := path.Join(base, am.Path)
+		st := Stat{Kind: am.Kind, Size: am.Size, ModTime: am.ModTime}
+		if am.Kind == entry.KindDir {
+			return m.AddDir(p, st)
+		}
+		return m.AddFile(p, st)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
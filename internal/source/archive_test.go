@@ -0,0 +1,143 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+// bytesFile is an in-memory File.
+type bytesFile struct{ *bytes.Reader }
+
+func (bytesFile) Close() error { return nil }
+
+// fixtureMember is one member of the fixture archives. Names that climb out
+// of the archive must come back cleaned.
+type fixtureMember struct {
+	name string
+	body string
+	dir  bool
+}
+
+var fixtureMembers = []fixtureMember{
+	{name: "proj/", dir: true},
+	{name: "proj/a.txt", body: "hello"},
+	{name: "../escape/b.bin", body: "0123456789"},
+}
+
+var fixtureTime = time.Unix(1700000000, 0)
+
+func tarFixture(t *testing.T, gzipped bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if gzipped {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	for _, m := range fixtureMembers {
+		hdr := &tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.body)), ModTime: fixtureTime, Typeflag: tar.TypeReg}
+		if m.dir {
+			hdr.Mode, hdr.Typeflag = 0755, tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(m.body)); err != nil {
+			t.Fatalf("write body: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatalf("close gzip: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func zipFixture(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range fixtureMembers {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: m.name, Method: zip.Deflate, Modified: fixtureTime})
+		if err != nil {
+			t.Fatalf("create %s: %v", m.name, err)
+		}
+		if _, err := w.Write([]byte(m.body)); err != nil {
+			t.Fatalf("write %s: %v", m.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadArchiveListsMembers(t *testing.T) {
+	fixtures := map[string][]byte{
+		"data.tar":    tarFixture(t, false),
+		"data.tar.gz": tarFixture(t, true),
+		"DATA.TGZ":    tarFixture(t, true),
+		"data.zip":    zipFixture(t),
+	}
+	want := []ArchiveMember{
+		{Path: "proj", Kind: entry.KindDir},
+		{Path: "proj/a.txt", Kind: entry.KindFile, Size: 5},
+		{Path: "escape/b.bin", Kind: entry.KindFile, Size: 10},
+	}
+
+	for name, data := range fixtures {
+		if !IsArchive(name) {
+			t.Fatalf("%s: not recognized as an archive", name)
+		}
+		var got []ArchiveMember
+		err := ReadArchive(name, bytesFile{bytes.NewReader(data)}, int64(len(data)), func(m ArchiveMember) error {
+			got = append(got, m)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: read archive: %v", name, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d members, want %d: %+v", name, len(got), len(want), got)
+		}
+		for i, m := range got {
+			if m.Path != want[i].Path || m.Kind != want[i].Kind || m.Size != want[i].Size {
+				t.Errorf("%s: member %d is %+v, want %+v", name, i, m, want[i])
+			}
+			if !m.ModTime.Equal(fixtureTime) {
+				t.Errorf("%s: %s modified %v, want %v", name, m.Path, m.ModTime, fixtureTime)
+			}
+		}
+
+		// An error from the callback stops the listing, as cancellation does
+		stop := errors.New("stop")
+		var seen int
+		err = ReadArchive(name, bytesFile{bytes.NewReader(data)}, int64(len(data)), func(ArchiveMember) error {
+			seen++
+			return stop
+		})
+		if !errors.Is(err, stop) || seen != 1 {
+			t.Fatalf("%s: got %v after %d members, want the callback's error after 1", name, err, seen)
+		}
+	}
+
+	if IsArchive("notes.txt") {
+		t.Fatalf("notes.txt recognized as an archive")
+	}
+	data := []byte("not an archive")
+	if err := ReadArchive("broken.zip", bytesFile{bytes.NewReader(data)}, int64(len(data)), func(ArchiveMember) error { return nil }); err == nil {
+		t.Fatalf("expected an error for a corrupt zip")
+	}
+}
@@ -0,0 +1,99 @@
+package source
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is an open directory whose children can be listed and stat'ed by name.
+// Sources backed by a real filesystem use it to resolve each child relative to
+// the directory instead of walking the full path again.
+type Dir interface {
+	// ReadDir lists the children of the directory.
+	ReadDir() ([]DirEntry, error)
+
+	// Lstat returns metadata for the named child without following symlinks.
+	Lstat(name string) (Stat, error)
+
+	// Close releases the directory. Calls still in flight finish first.
+	Close() error
+}
+
+// DirOpener is implemented by sources that can hold a directory open.
+type DirOpener interface {
+	OpenDir(path string) (Dir, error)
+}
+
+// OpenDir opens path on src, falling back to per-path calls for sources that
+// don't implement DirOpener.
+func OpenDir(src Source, path string) (Dir, error) {
+	if o, ok := src.(DirOpener); ok {
+		return o.OpenDir(path)
+	}
+	return pathDir{src: src, path: path}, nil
+}
+
+// DevStater is implemented by Dirs that can learn a child's type and device
+// more cheaply than a full Lstat.
+type DevStater interface {
+	// StatDev returns the Kind and DevID of the named child without
+	// following symlinks. Other fields may be left zero.
+	StatDev(name string) (Stat, error)
+}
+
+// StatDev returns at least the Kind and DevID of the named child of d,
+// which is all the scanner needs of a subdirectory under --xdev. Dirs
+// without a cheaper call fall back to Lstat.
+func StatDev(d Dir, name string) (Stat, error) {
+	if ds, ok := d.(DevStater); ok {
+		return ds.StatDev(name)
+	}
+	return d.Lstat(name)
+}
+
+// pathDir adapts a plain Source to Dir by joining names onto the path.
+type pathDir struct {
+	src  Source
+	path string
+}
+
+func (d pathDir) ReadDir() ([]DirEntry, error)    { return d.src.ReadDir(d.path) }
+func (d pathDir) Lstat(name string) (Stat, error) { return d.src.Lstat(filepath.Join(d.path, name)) }
+func (d pathDir) Close() error                    { return nil }
+
+// StatMethod selects how OS lists directories and stats their children.
+type StatMethod int
+
+const (
+	// StatAuto uses the fastest method the platform supports.
+	StatAuto StatMethod = iota
+	// StatLstat uses os.ReadDir and os.Lstat on full paths.
+	StatLstat
+	// StatFstatat reads getdents64 batches and calls fstatat relative to the
+	// directory fd (Linux only).
+	StatFstatat
+	// StatStatx reads getdents64 batches and calls statx with only the fields
+	// dug records (Linux only).
+	StatStatx
+)
+
+// StatMethods lists the method names accepted by ParseStatMethod.
+var StatMethods = []string{"auto", "lstat", "fstatat", "statx"}
+
+func (m StatMethod) String() string {
+	if m >= 0 && int(m) < len(StatMethods) {
+		return StatMethods[m]
+	}
+	return "unknown"
+}
+
+// ParseStatMethod parses a method name such as "statx".
+func ParseStatMethod(s string) (StatMethod, error) {
+	for i, name := range StatMethods {
+		if strings.EqualFold(s, name) {
+			return StatMethod(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown stat method %q (want %s)", s, strings.Join(StatMethods, ", "))
+}
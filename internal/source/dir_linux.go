@@ -0,0 +1,249 @@
+package source
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+// direntBufSize is the getdents64 buffer size. Larger batches mean fewer
+// round trips to the server on network filesystems.
+const direntBufSize = 128 << 10
+
+// statxMask asks only for the fields dug records, so filesystems can skip
+// fetching the rest (owners, link counts, access and change times).
+const statxMask = unix.STATX_TYPE | unix.STATX_SIZE | unix.STATX_BLOCKS | unix.STATX_MTIME | unix.STATX_INO
+
+var direntBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, direntBufSize)
+		return &buf
+	},
+}
+
+// statxUnsupported is set once statx fails with ENOSYS (old kernels, some
+// seccomp profiles) so later calls go straight to fstatat.
+var statxUnsupported atomic.Bool
+
+// OpenDir opens path and returns a Dir that reads entries with getdents64 and
+// stats children relative to the directory fd.
+func (o OS) OpenDir(path string) (Dir, error) {
+	if o.Method == StatLstat {
+		return pathDir{src: o, path: path}, nil
+	}
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return &fdDir{fd: fd, path: path, statx: o.Method != StatFstatat}, nil
+}
+
+// fdDir is a directory held open by fd. Calls abandoned after a timeout may
+// still be running when the scanner closes it, so the fd is reference counted
+// and only closed once the last call returns.
+type fdDir struct {
+	path  string
+	statx bool
+
+	mu     sync.Mutex
+	fd     int
+	refs   int
+	closed bool
+}
+
+func (d *fdDir) acquire() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return -1, unix.EBADF
+	}
+	d.refs++
+	return d.fd, nil
+}
+
+func (d *fdDir) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refs--
+	if d.closed && d.refs == 0 {
+		unix.Close(d.fd)
+	}
+}
+
+// Close closes the fd once no calls are in flight.
+func (d *fdDir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	if d.refs == 0 {
+		return unix.Close(d.fd)
+	}
+	return nil
+}
+
+// ReadDir lists the directory in getdents64 batches using a pooled buffer.
+func (d *fdDir) ReadDir() ([]DirEntry, error) {
+	fd, err := d.acquire()
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: d.path, Err: err}
+	}
+	defer d.release()
+
+	bufp := direntBufPool.Get().(*[]byte)
+	defer direntBufPool.Put(bufp)
+	buf := *bufp
+
+	var out []DirEntry
+	for {
+		n, err := unix.Getdents(fd, buf)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: d.path, Err: err}
+		}
+		if n <= 0 {
+			return out, nil
+		}
+		out = parseDirents(buf[:n], out)
+	}
+}
+
+// parseDirents appends the entries in a getdents64 buffer to out. Each record
+// is laid out as struct linux_dirent64: d_ino (8), d_off (8), d_reclen (2),
+// d_type (1), then the NUL-terminated name.
+func parseDirents(buf []byte, out []DirEntry) []DirEntry {
+	const nameOff = 19
+	for len(buf) >= nameOff {
+		ino := binary.NativeEndian.Uint64(buf[0:8])
+		reclen := int(binary.NativeEndian.Uint16(buf[16:18]))
+		if reclen < nameOff || reclen > len(buf) {
+			break
+		}
+		typ := buf[18]
+		name := buf[nameOff:reclen]
+		for i, c := range name {
+			if c == 0 {
+				name = name[:i]
+				break
+			}
+		}
+		buf = buf[reclen:]
+
+		if ino == 0 || string(name) == "." || string(name) == ".." {
+			continue
+		}
+		out = append(out, DirEntry{Name: string(name), Kind: kindFromDirentType(typ)})
+	}
+	return out
+}
+
+// kindFromDirentType maps d_type. DT_UNKNOWN becomes KindOther so the
+// scanner falls back to stat to learn the real type.
+func kindFromDirentType(typ byte) entry.Kind {
+	switch typ {
+	case unix.DT_REG:
+		return entry.KindFile
+	case unix.DT_DIR:
+		return entry.KindDir
+	case unix.DT_LNK:
+		return entry.KindSymlink
+	default:
+		return entry.KindOther
+	}
+}
+
+// Lstat stats the named child relative to the directory fd.
+func (d *fdDir) Lstat(name string) (Stat, error) {
+	fd, err := d.acquire()
+	if err != nil {
+		return Stat{}, &os.PathError{Op: "lstat", Path: d.path + "/" + name, Err: err}
+	}
+	defer d.release()
+
+	if d.statx && !statxUnsupported.Load() {
+		var stx unix.Statx_t
+		err := unix.Statx(fd, name, unix.AT_SYMLINK_NOFOLLOW, statxMask, &stx)
+		if err == nil {
+			return statFromStatx(&stx), nil
+		}
+		if !errors.Is(err, unix.ENOSYS) {
+			return Stat{}, &os.PathError{Op: "statx", Path: d.path + "/" + name, Err: err}
+		}
+		statxUnsupported.Store(true)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Fstatat(fd, name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return Stat{}, &os.PathError{Op: "fstatat", Path: d.path + "/" + name, Err: err}
+	}
+	return Stat{
+		Kind:    kindFromUnixMode(st.Mode),
+		Size:    st.Size,
+		Blocks:  st.Blocks * 512,
+		ModTime: time.Unix(st.Mtim.Sec, st.Mtim.Nsec),
+		DevID:   uint64(st.Dev),
+		Inode:   st.Ino,
+	}, nil
+}
+
+// StatDev stats the named child for its type and device alone. statx always
+// fills stx_dev whatever the mask, so asking for STATX_TYPE only spares the
+// filesystem fetching size, blocks and times it would otherwise return.
+func (d *fdDir) StatDev(name string) (Stat, error) {
+	if !d.statx || statxUnsupported.Load() {
+		return d.Lstat(name)
+	}
+	fd, err := d.acquire()
+	if err != nil {
+		return Stat{}, &os.PathError{Op: "statx", Path: d.path + "/" + name, Err: err}
+	}
+	defer d.release()
+
+	var stx unix.Statx_t
+	if err := unix.Statx(fd, name, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_TYPE, &stx); err != nil {
+		if errors.Is(err, unix.ENOSYS) {
+			statxUnsupported.Store(true)
+			return d.Lstat(name)
+		}
+		return Stat{}, &os.PathError{Op: "statx", Path: d.path + "/" + name, Err: err}
+	}
+	return Stat{
+		Kind:  kindFromUnixMode(uint32(stx.Mode)),
+		DevID: unix.Mkdev(stx.Dev_major, stx.Dev_minor),
+	}, nil
+}
+
+func statFromStatx(stx *unix.Statx_t) Stat {
+	return Stat{
+		Kind:    kindFromUnixMode(uint32(stx.Mode)),
+		Size:    int64(stx.Size),
+		Blocks:  int64(stx.Blocks) * 512,
+		ModTime: time.Unix(stx.Mtime.Sec, int64(stx.Mtime.Nsec)),
+		DevID:   unix.Mkdev(stx.Dev_major, stx.Dev_minor),
+		Inode:   stx.Ino,
+	}
+}
+
+func kindFromUnixMode(mode uint32) entry.Kind {
+	switch mode & unix.S_IFMT {
+	case unix.S_IFREG:
+		return entry.KindFile
+	case unix.S_IFDIR:
+		return entry.KindDir
+	case unix.S_IFLNK:
+		return entry.KindSymlink
+	default:
+		return entry.KindOther
+	}
+}
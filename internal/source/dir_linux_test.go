@@ -0,0 +1,111 @@
+package source
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+// dirent encodes one struct linux_dirent64 record, padded to 8 bytes as
+// the kernel does.
+func dirent(ino uint64, typ byte, name string) []byte {
+	reclen := (19 + len(name) + 1 + 7) &^ 7
+	buf := make([]byte, reclen)
+	binary.NativeEndian.PutUint64(buf[0:8], ino)
+	binary.NativeEndian.PutUint16(buf[16:18], uint16(reclen))
+	buf[18] = typ
+	copy(buf[19:], name)
+	return buf
+}
+
+func TestParseDirents(t *testing.T) {
+	var buf []byte
+	for _, d := range [][]byte{
+		dirent(1, unix.DT_DIR, "."),
+		dirent(2, unix.DT_DIR, ".."),
+		dirent(10, unix.DT_REG, "file.txt"),
+		dirent(0, unix.DT_REG, "deleted"),
+		dirent(11, unix.DT_DIR, "sub"),
+		dirent(12, unix.DT_LNK, "link"),
+		dirent(13, unix.DT_UNKNOWN, "unknown"),
+		dirent(14, unix.DT_FIFO, "fifo"),
+		dirent(15, unix.DT_REG, "exactly-eight"),
+	} {
+		buf = append(buf, d...)
+	}
+	// A record cut short by the end of the buffer is ignored
+	truncated := dirent(16, unix.DT_REG, "truncated")
+	buf = append(buf, truncated[:len(truncated)-4]...)
+
+	want := []DirEntry{
+		{Name: "file.txt", Kind: entry.KindFile},
+		{Name: "sub", Kind: entry.KindDir},
+		{Name: "link", Kind: entry.KindSymlink},
+		{Name: "unknown", Kind: entry.KindOther},
+		{Name: "fifo", Kind: entry.KindOther},
+		{Name: "exactly-eight", Kind: entry.KindFile},
+	}
+	got := parseDirents(buf, []DirEntry{{Name: "earlier"}})
+	if len(got) != len(want)+1 || got[0].Name != "earlier" {
+		t.Fatalf("got %+v, want %+v appended to the earlier entry", got, want)
+	}
+	for i, w := range want {
+		if got[i+1] != w {
+			t.Errorf("entry %d is %+v, want %+v", i, got[i+1], w)
+		}
+	}
+}
+
+func TestKindFromDirentType(t *testing.T) {
+	for typ, want := range map[byte]entry.Kind{
+		unix.DT_REG:     entry.KindFile,
+		unix.DT_DIR:     entry.KindDir,
+		unix.DT_LNK:     entry.KindSymlink,
+		unix.DT_UNKNOWN: entry.KindOther,
+		unix.DT_SOCK:    entry.KindOther,
+		unix.DT_BLK:     entry.KindOther,
+	} {
+		if got := kindFromDirentType(typ); got != want {
+			t.Errorf("d_type %d: got %s, want %s", typ, got, want)
+		}
+	}
+}
+
+func TestStatDevMatchesLstat(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []StatMethod{StatLstat, StatFstatat, StatStatx} {
+		d, err := OS{Method: method}.OpenDir(dir)
+		if err != nil {
+			t.Fatalf("%s: open: %v", method, err)
+		}
+		for name, kind := range map[string]entry.Kind{"sub": entry.KindDir, "link": entry.KindSymlink} {
+			full, err := d.Lstat(name)
+			if err != nil {
+				t.Fatalf("%s: lstat %s: %v", method, name, err)
+			}
+			dev, err := StatDev(d, name)
+			if err != nil {
+				t.Fatalf("%s: stat dev %s: %v", method, name, err)
+			}
+			if dev.Kind != kind || dev.DevID != full.DevID || dev.DevID == 0 {
+				t.Errorf("%s: %s is %s on device %d, want %s on device %d", method, name, dev.Kind, dev.DevID, kind, full.DevID)
+			}
+		}
+		if _, err := StatDev(d, "missing"); !os.IsNotExist(err) {
+			t.Errorf("%s: stat dev of a missing child: %v", method, err)
+		}
+		d.Close()
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package source
+
+// OpenDir opens path. Only the portable lstat method exists on this platform.
+func (o OS) OpenDir(path string) (Dir, error) {
+	return pathDir{src: o, path: path}, nil
+}
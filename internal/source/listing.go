@@ -0,0 +1,116 @@
+package source
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+// ParseListing replays a file listing into a Memory tree. Each line holds
+//
+//	SIZE BLOCKS MTIME INODE PATH
+//
+// as printed by `find -printf '%s %b %T@ %i %p\n'` (BLOCKS in 512-byte
+// units, MTIME in fractional epoch seconds). A leading find %y type letter
+// (`%y %s %b %T@ %i %p`) is also accepted; without it, any path with
+// children is taken to be a directory. Relative paths are resolved
+// against base. Blank lines and lines starting with '#' are skipped.
+func ParseListing(r io.Reader, base string) (*Memory, error) {
+	m := NewMemory()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		path, st, err := parseListingLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("listing line %d: %w", lineNo, err)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(base, path)
+		}
+		if st.Kind == entry.KindDir {
+			err = m.AddDir(path, st)
+		} else {
+			err = m.AddFile(path, st)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing line %d: %w", lineNo, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read listing: %w", err)
+	}
+	return m, nil
+}
+
+func parseListingLine(line string) (string, Stat, error) {
+	kind := entry.KindFile
+	// A %y type is a letter; a plain line starts with its size, which may be
+	// a single digit followed by a space too
+	if len(line) > 2 && isLetter(line[0]) && line[1] == ' ' && isDigit(line[2]) {
+		switch line[0] {
+		case 'f':
+			kind = entry.KindFile
+		case 'd':
+			kind = entry.KindDir
+		case 'l':
+			kind = entry.KindSymlink
+		case 'b', 'c', 'p', 's', 'D':
+			kind = entry.KindOther
+		default:
+			return "", Stat{}, fmt.Errorf("unknown type %q", line[0])
+		}
+		line = line[2:]
+	}
+
+	fields := strings.SplitN(line, " ", 5)
+	if len(fields) != 5 || fields[4] == "" {
+		return "", Stat{}, fmt.Errorf("expected SIZE BLOCKS MTIME INODE PATH, got %q", line)
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", Stat{}, fmt.Errorf("invalid size %q", fields[0])
+	}
+	blocks, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", Stat{}, fmt.Errorf("invalid blocks %q", fields[1])
+	}
+	mtime, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return "", Stat{}, fmt.Errorf("invalid mtime %q", fields[2])
+	}
+	inode, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return "", Stat{}, fmt.Errorf("invalid inode %q", fields[3])
+	}
+
+	sec, frac := math.Modf(mtime)
+	return fields[4], Stat{
+		Kind:    kind,
+		Size:    size,
+		Blocks:  blocks * 512,
+		ModTime: time.Unix(int64(sec), int64(frac*1e9)),
+		Inode:   inode,
+	}, nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
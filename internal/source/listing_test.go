@@ -0,0 +1,99 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+func TestParseListingSynthesizesDirectories(t *testing.T) {
+	listing := `# find /data -printf '%s %b %T@ %i %p\n'
+4096 8 1700000000.5 10 /data/proj
+1000 8 1700000001.0 11 /data/proj/a.txt
+2000 0 1700000002.0 12 /data/proj/sub/sparse file.img
+`
+	m, err := ParseListing(strings.NewReader(listing), "/")
+	if err != nil {
+		t.Fatalf("parse listing: %v", err)
+	}
+
+	if root := m.Root(); root != "/data/proj" {
+		t.Fatalf("expected root /data/proj, got %s", root)
+	}
+
+	children, err := m.ReadDir("/data/proj")
+	if err != nil {
+		t.Fatalf("readdir: %v", err)
+	}
+	if len(children) != 2 || children[0].Name != "a.txt" || children[1].Name != "sub" || children[1].Kind != entry.KindDir {
+		t.Fatalf("unexpected children: %+v", children)
+	}
+
+	st, err := m.Lstat("/data/proj/sub/sparse file.img")
+	if err != nil {
+		t.Fatalf("lstat: %v", err)
+	}
+	if st.Kind != entry.KindFile || st.Size != 2000 || st.Blocks != 0 || st.Inode != 12 {
+		t.Fatalf("unexpected stat: %+v", st)
+	}
+
+	if st, _ := m.Lstat("/data/proj"); st.Kind != entry.KindDir {
+		t.Fatalf("expected /data/proj promoted to a directory, got %v", st.Kind)
+	}
+
+	if _, err := ParseListing(strings.NewReader("x y z\n"), "/"); err == nil {
+		t.Fatalf("expected error for malformed line")
+	}
+}
+
+func TestParseListingSingleDigitSizes(t *testing.T) {
+	for size := 0; size <= 9; size++ {
+		for _, prefix := range []string{"", "f "} {
+			line := fmt.Sprintf("%s%d 0 1700000000.5 12 /a/file", prefix, size)
+			path, st, err := parseListingLine(line)
+			if err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+			if path != "/a/file" || st.Kind != entry.KindFile || st.Size != int64(size) || st.Inode != 12 {
+				t.Fatalf("%q: got %s %+v", line, path, st)
+			}
+		}
+	}
+}
+
+func TestParseTarDetectsGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name string
+		body string
+	}{{"proj/a.txt", "hello"}, {"proj/sub/b.txt", "hi"}} {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body))}); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		tw.Write([]byte(f.body))
+	}
+	tw.Close()
+	gz.Close()
+
+	m, err := ParseTar(&buf, "/")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if root := m.Root(); root != "/proj" {
+		t.Fatalf("expected root /proj, got %s", root)
+	}
+	st, err := m.Lstat("/proj/sub/b.txt")
+	if err != nil {
+		t.Fatalf("lstat: %v", err)
+	}
+	if st.Kind != entry.KindFile || st.Size != 2 {
+		t.Fatalf("unexpected stat: %+v", st)
+	}
+}
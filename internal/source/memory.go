@@ -0,0 +1,165 @@
+package source
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/michaelscutari/dug/internal/entry"
+	"github.com/michaelscutari/dug/internal/pathutil"
+)
+
+// Memory is an in-memory tree, used for tests and for replaying listings
+// and archives. Parent directories are created implicitly.
+type Memory struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	stat     Stat
+	children map[string]struct{}
+}
+
+// NewMemory creates an empty tree containing only "/".
+func NewMemory() *Memory {
+	m := &Memory{nodes: make(map[string]*memNode)}
+	m.nodes["/"] = &memNode{stat: Stat{Kind: entry.KindDir}, children: make(map[string]struct{})}
+	return m
+}
+
+// AddDir adds a directory, replacing the metadata of an implicit one.
+func (m *Memory) AddDir(path string, st Stat) error {
+	st.Kind = entry.KindDir
+	return m.add(path, st)
+}
+
+// AddFile adds a non-directory entry. st.Kind defaults to KindFile.
+func (m *Memory) AddFile(path string, st Stat) error {
+	if st.Kind == entry.KindDir {
+		st.Kind = entry.KindFile
+	}
+	return m.add(path, st)
+}
+
+func (m *Memory) add(path string, st Stat) error {
+	path = pathutil.Normalize(path)
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("memory source needs absolute paths, got %q", path)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node := m.nodes[path]
+	if node == nil {
+		node = &memNode{}
+		m.nodes[path] = node
+		m.link(path)
+	}
+	if st.Kind == entry.KindDir && node.children == nil {
+		node.children = make(map[string]struct{})
+	}
+	if st.Kind != entry.KindDir && node.children != nil {
+		// Something already lives below this path, so it must be a directory.
+		st.Kind = entry.KindDir
+	}
+	node.stat = st
+	return nil
+}
+
+// link registers path with its parent, creating implicit parents as needed.
+func (m *Memory) link(path string) {
+	for path != "/" {
+		parent := filepath.Dir(path)
+		pnode := m.nodes[parent]
+		if pnode == nil {
+			pnode = &memNode{stat: Stat{Kind: entry.KindDir}, children: make(map[string]struct{})}
+			m.nodes[parent] = pnode
+			pnode.children[filepath.Base(path)] = struct{}{}
+			path = parent
+			continue
+		}
+		if pnode.children == nil {
+			// A listing may name a directory before its children; promote it.
+			pnode.children = make(map[string]struct{})
+			pnode.stat.Kind = entry.KindDir
+		}
+		pnode.children[filepath.Base(path)] = struct{}{}
+		return
+	}
+}
+
+// ReadDir lists a directory in name order.
+func (m *Memory) ReadDir(path string) ([]DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node := m.nodes[path]
+	if node == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	}
+	if node.children == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: syscall.ENOTDIR}
+	}
+
+	out := make([]DirEntry, 0, len(node.children))
+	for name := range node.children {
+		out = append(out, DirEntry{Name: name, Kind: m.nodes[joinPath(path, name)].stat.Kind})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Lstat returns the metadata recorded for path.
+func (m *Memory) Lstat(path string) (Stat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node := m.nodes[path]
+	if node == nil {
+		return Stat{}, &fs.PathError{Op: "lstat", Path: path, Err: fs.ErrNotExist}
+	}
+	return node.stat, nil
+}
+
+// Root returns the deepest directory that contains every entry, which is
+// the natural scan root for a replayed listing.
+func (m *Memory) Root() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	path := "/"
+	for {
+		node := m.nodes[path]
+		if len(node.children) != 1 {
+			return path
+		}
+		var name string
+		for name = range node.children {
+		}
+		child := joinPath(path, name)
+		if m.nodes[child].children == nil {
+			return path
+		}
+		path = child
+	}
+}
+
+// Len returns the number of entries in the tree, including "/".
+func (m *Memory) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.nodes)
+}
+
+func joinPath(dir, name string) string {
+	if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
@@ -0,0 +1,51 @@
+package source
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+// OS reads the live local filesystem. Method picks how OpenDir lists and
+// stats children; ReadDir and Lstat always use the os package.
+type OS struct {
+	Method StatMethod
+}
+
+// ReadDir lists a directory with os.ReadDir.
+func (OS) ReadDir(path string) ([]DirEntry, error) {
+	des, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]DirEntry, len(des))
+	for i, de := range des {
+		out[i] = DirEntry{Name: de.Name(), Kind: entry.KindFromMode(de.Type())}
+	}
+	return out, nil
+}
+
+// Lstat stats a path with os.Lstat.
+func (OS) Lstat(path string) (Stat, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Stat{}, err
+	}
+	return FromFileInfo(info), nil
+}
+
+// FromFileInfo converts an os.FileInfo into a Stat.
+func FromFileInfo(info os.FileInfo) Stat {
+	st := Stat{
+		Kind:    entry.KindFromMode(info.Mode()),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		st.DevID = uint64(sys.Dev)
+		st.Inode = sys.Ino
+		st.Blocks = sys.Blocks * 512 // st_blocks is in 512-byte units
+	}
+	return st
+}
@@ -0,0 +1,36 @@
+package source
+
+import (
+	"time"
+
+	"github.com/michaelscutari/dug/internal/entry"
+)
+
+// Stat is the file metadata dug records for one entry.
+type Stat struct {
+	Kind    entry.Kind
+	Size    int64 // Apparent size (st_size)
+	Blocks  int64 // Disk usage in bytes (st_blocks * 512)
+	ModTime time.Time
+	DevID   uint64
+	Inode   uint64
+}
+
+// DirEntry is one child returned by ReadDir. Kind comes from the directory
+// listing itself. The scanner trusts KindDir without a stat, so sources that
+// can't tell a child's type must report KindOther and let Lstat decide.
+type DirEntry struct {
+	Name string
+	Kind entry.Kind
+}
+
+// Source supplies directory listings and metadata to the scanner. Paths are
+// absolute and already normalized. Implementations must be safe for
+// concurrent use by multiple workers.
+type Source interface {
+	// ReadDir lists the children of a directory.
+	ReadDir(path string) ([]DirEntry, error)
+
+	// Lstat returns metadata for path without following symlinks.
+	Lstat(path string) (Stat, error)
+}
@@ -0,0 +1,167 @@
+package throttle
+
+import (
+	"context"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const controlInterval = time.Second
+
+// ChangeFunc is called whenever the controller changes the limiter's rate.
+type ChangeFunc func(rate float64, reason string)
+
+// Controller keeps a Limiter in line with a time-of-day schedule, an
+// optional control file, and manual adjustments (e.g. from signals).
+// Manual and control-file rates override the schedule until cleared.
+type Controller struct {
+	limiter     *Limiter
+	schedule    Schedule
+	controlFile string
+	onChange    ChangeFunc
+
+	mu          sync.Mutex
+	override    float64
+	hasOverride bool
+	fileMod     time.Time
+	observed    float64
+	lastOps     int64
+	lastSample  time.Time
+}
+
+// NewController creates a controller for l. controlFile may be empty.
+func NewController(l *Limiter, schedule Schedule, controlFile string) *Controller {
+	return &Controller{
+		limiter:     l,
+		schedule:    schedule,
+		controlFile: controlFile,
+		lastSample:  time.Now(),
+	}
+}
+
+// SetChangeFunc sets a callback for rate changes.
+func (c *Controller) SetChangeFunc(f ChangeFunc) {
+	c.onChange = f
+}
+
+// Run applies the schedule and polls the control file until ctx is done.
+func (c *Controller) Run(ctx context.Context) {
+	c.apply("schedule")
+	ticker := time.NewTicker(controlInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.sample()
+			c.readControlFile()
+			c.apply("schedule")
+		}
+	}
+}
+
+// Halve cuts the current rate in half. When unlimited, it halves the
+// throughput observed over the last interval instead.
+func (c *Controller) Halve() {
+	c.mu.Lock()
+	current := c.limiter.Rate()
+	if current <= 0 {
+		current = c.observed
+	}
+	if current > 0 {
+		c.override = math.Max(1, current/2)
+		c.hasOverride = true
+	}
+	c.mu.Unlock()
+	c.apply("manual")
+}
+
+// Double doubles the current rate. It has no effect when unlimited.
+func (c *Controller) Double() {
+	c.mu.Lock()
+	if current := c.limiter.Rate(); current > 0 {
+		c.override = current * 2
+		c.hasOverride = true
+	}
+	c.mu.Unlock()
+	c.apply("manual")
+}
+
+// Reset drops any manual or control-file override and returns to the schedule.
+func (c *Controller) Reset() {
+	c.mu.Lock()
+	c.hasOverride = false
+	c.mu.Unlock()
+	c.apply("reset")
+}
+
+func (c *Controller) sample() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	ops := c.limiter.Ops()
+	if elapsed := now.Sub(c.lastSample).Seconds(); elapsed > 0 {
+		c.observed = float64(ops-c.lastOps) / elapsed
+	}
+	c.lastOps = ops
+	c.lastSample = now
+}
+
+// readControlFile applies the rate written in the control file whenever the
+// file changes. The file holds a number of ops/sec (0 = unlimited) or
+// "auto"/nothing to return to the schedule.
+func (c *Controller) readControlFile() {
+	if c.controlFile == "" {
+		return
+	}
+	info, err := os.Stat(c.controlFile)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if info.ModTime().Equal(c.fileMod) {
+		return
+	}
+	c.fileMod = info.ModTime()
+
+	data, err := os.ReadFile(c.controlFile)
+	if err != nil {
+		return
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" || text == "auto" {
+		c.hasOverride = false
+		return
+	}
+	if rate, err := strconv.ParseFloat(text, 64); err == nil && rate >= 0 {
+		c.override = rate
+		c.hasOverride = true
+	}
+}
+
+func (c *Controller) apply(reason string) {
+	c.mu.Lock()
+	target := c.schedule.RateAt(time.Now())
+	if c.hasOverride {
+		target = c.override
+		if reason == "schedule" {
+			reason = "override"
+		}
+	}
+	c.mu.Unlock()
+
+	if target == c.limiter.Rate() {
+		return
+	}
+	c.limiter.SetRate(target)
+	if c.onChange != nil {
+		c.onChange(c.limiter.Rate(), reason)
+	}
+}
@@ -0,0 +1,93 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GateEvent records one pause or resume of a Gate.
+type GateEvent struct {
+	Time   time.Time
+	Paused bool
+	Reason string
+}
+
+// Gate lets a running scan be paused and resumed without cancelling it.
+// Workers and the ingester call Wait at safe points.
+//
+// A nil *Gate is valid and never blocks.
+type Gate struct {
+	paused atomic.Bool
+
+	mu     sync.Mutex
+	resume chan struct{}
+	events []GateEvent
+}
+
+// NewGate creates an open gate.
+func NewGate() *Gate {
+	return &Gate{}
+}
+
+// Pause closes the gate. It returns false if the gate was already paused.
+func (g *Gate) Pause(reason string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused.Load() {
+		return false
+	}
+	g.resume = make(chan struct{})
+	g.paused.Store(true)
+	g.events = append(g.events, GateEvent{Time: time.Now(), Paused: true, Reason: reason})
+	return true
+}
+
+// Resume reopens the gate. It returns false if the gate was not paused.
+func (g *Gate) Resume(reason string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused.Load() {
+		return false
+	}
+	g.paused.Store(false)
+	close(g.resume)
+	g.events = append(g.events, GateEvent{Time: time.Now(), Paused: false, Reason: reason})
+	return true
+}
+
+// Paused reports whether the gate is currently closed.
+func (g *Gate) Paused() bool {
+	return g != nil && g.paused.Load()
+}
+
+// Wait blocks while the gate is paused. It returns ctx.Err() if ctx ends first.
+func (g *Gate) Wait(ctx context.Context) error {
+	if !g.Paused() {
+		return nil
+	}
+	g.mu.Lock()
+	resume := g.resume
+	paused := g.paused.Load()
+	g.mu.Unlock()
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Events returns the pause/resume history.
+func (g *Gate) Events() []GateEvent {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]GateEvent(nil), g.events...)
+}
@@ -0,0 +1,41 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGateBlocksUntilResumed(t *testing.T) {
+	g := NewGate()
+	ctx := context.Background()
+	if err := g.Wait(ctx); err != nil {
+		t.Fatalf("open gate blocked: %v", err)
+	}
+
+	if !g.Pause("test") || g.Pause("again") {
+		t.Fatalf("expected only the first pause to take effect")
+	}
+	done := make(chan struct{})
+	go func() {
+		g.Wait(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("wait returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	g.Resume("test")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("wait did not return after resume")
+	}
+
+	if events := g.Events(); len(events) != 2 || !events[0].Paused || events[1].Paused {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
@@ -0,0 +1,117 @@
+package throttle
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limiter is a token bucket shared by all scan workers. Each filesystem
+// operation takes one token. A rate of zero or less means unlimited.
+//
+// A nil *Limiter is valid and never blocks.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	limited atomic.Bool
+	ops     atomic.Int64
+}
+
+// NewLimiter creates a limiter allowing rate operations per second.
+func NewLimiter(rate float64) *Limiter {
+	l := &Limiter{last: time.Now()}
+	l.SetRate(rate)
+	l.tokens = l.burst
+	return l
+}
+
+// SetRate changes the allowed operations per second. It is safe to call
+// while workers are waiting.
+func (l *Limiter) SetRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.refill(now)
+	if rate <= 0 || math.IsInf(rate, 1) || math.IsNaN(rate) {
+		rate = 0
+	}
+	l.rate = rate
+	// Allow roughly 100ms worth of burst so short stalls don't waste capacity.
+	l.burst = math.Max(1, rate/10)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.limited.Store(rate > 0)
+}
+
+// Rate returns the current operations-per-second limit (0 = unlimited).
+func (l *Limiter) Rate() float64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Ops returns the number of operations admitted so far.
+func (l *Limiter) Ops() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.ops.Load()
+}
+
+// Wait blocks until one operation is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.ops.Add(1)
+	if !l.limited.Load() {
+		return nil
+	}
+
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.refill(now)
+	// Reserve the token up front; a negative balance queues later callers
+	// behind this one.
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	if l.rate <= 0 || elapsed <= 0 {
+		return
+	}
+	l.tokens = math.Min(l.burst, l.tokens+elapsed*l.rate)
+}
@@ -0,0 +1,35 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterPacesOperations(t *testing.T) {
+	l := NewLimiter(200)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 60; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	// 20 tokens of burst, then 40 more at 200/s needs at least 200ms.
+	if took := time.Since(start); took < 150*time.Millisecond {
+		t.Fatalf("expected pacing, 60 ops took %s", took)
+	}
+	if l.Ops() != 60 {
+		t.Fatalf("expected 60 ops, got %d", l.Ops())
+	}
+
+	l.SetRate(0)
+	start = time.Now()
+	for i := 0; i < 1000; i++ {
+		l.Wait(ctx)
+	}
+	if took := time.Since(start); took > 50*time.Millisecond {
+		t.Fatalf("unlimited limiter blocked for %s", took)
+	}
+}
@@ -0,0 +1,81 @@
+package throttle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window applies a rate between two times of day. Windows may wrap past
+// midnight, e.g. 22:00-06:00, and one that ends where it starts, e.g.
+// 00:00-00:00, covers the whole day.
+type Window struct {
+	Start time.Duration // offset from local midnight
+	End   time.Duration
+	Rate  float64
+}
+
+// ParseWindow parses "HH:MM-HH:MM=RATE". A rate of 0 means full speed.
+func ParseWindow(s string) (Window, error) {
+	span, rateStr, ok := strings.Cut(s, "=")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid window %q (expected HH:MM-HH:MM=RATE)", s)
+	}
+	startStr, endStr, ok := strings.Cut(span, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid window %q (expected HH:MM-HH:MM=RATE)", s)
+	}
+
+	start, err := parseClock(startStr)
+	if err != nil {
+		return Window{}, err
+	}
+	end, err := parseClock(endStr)
+	if err != nil {
+		return Window{}, err
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(rateStr), 64)
+	if err != nil || rate < 0 {
+		return Window{}, fmt.Errorf("invalid rate %q in window %q", rateStr, s)
+	}
+
+	return Window{Start: start, End: end, Rate: rate}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether the time of day of t falls in the window.
+func (w Window) Contains(t time.Time) bool {
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start == w.End {
+		return true
+	}
+	if w.Start < w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// Schedule picks a rate by time of day. The first matching window wins;
+// Default applies outside every window.
+type Schedule struct {
+	Default float64
+	Windows []Window
+}
+
+// RateAt returns the scheduled rate at t.
+func (s Schedule) RateAt(t time.Time) float64 {
+	for _, w := range s.Windows {
+		if w.Contains(t) {
+			return w.Rate
+		}
+	}
+	return s.Default
+}
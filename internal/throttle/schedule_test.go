@@ -0,0 +1,47 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleWrapsMidnight(t *testing.T) {
+	w, err := ParseWindow("22:00-06:00=0")
+	if err != nil {
+		t.Fatalf("parse window: %v", err)
+	}
+	sched := Schedule{Default: 500, Windows: []Window{w}}
+
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 1, 1, hour, min, 0, 0, time.Local)
+	}
+	cases := []struct {
+		t    time.Time
+		want float64
+	}{
+		{at(23, 30), 0},
+		{at(2, 0), 0},
+		{at(6, 0), 500},
+		{at(12, 0), 500},
+		{at(22, 0), 0},
+	}
+	for _, c := range cases {
+		if got := sched.RateAt(c.t); got != c.want {
+			t.Errorf("RateAt(%s) = %v, want %v", c.t.Format("15:04"), got, c.want)
+		}
+	}
+
+	allDay, err := ParseWindow("00:00-00:00=50")
+	if err != nil {
+		t.Fatalf("parse window: %v", err)
+	}
+	for _, c := range cases {
+		if !allDay.Contains(c.t) {
+			t.Errorf("00:00-00:00 does not contain %s", c.t.Format("15:04"))
+		}
+	}
+
+	if _, err := ParseWindow("22:00=5"); err == nil {
+		t.Fatalf("expected error for window without end")
+	}
+}
@@ -2,6 +2,7 @@ package tui
 
 import (
 	"database/sql"
+	"path/filepath"
 	"strings"
 
 	"github.com/michaelscutari/dug/internal/db"
@@ -48,6 +49,22 @@ type Model struct {
 	filter       string
 	filterActive bool
 	err          error
+
+	// Directory listings load pageSize entries at a time, and the next
+	// page once the cursor nears the end. more is set while the directory
+	// has entries beyond those loaded, and total counts all of them.
+	more        bool
+	loadingMore bool
+	total       int64
+
+	// Whole-snapshot search: searchInput is being typed while searchActive,
+	// and search is the query whose results are listed instead of a
+	// directory. selectPath is put under the cursor once a jump loads.
+	searchInput  string
+	searchActive bool
+	searching    bool
+	search       string
+	selectPath   string
 }
 
 // NewModel creates a new TUI model.
@@ -63,9 +80,18 @@ func (m *Model) Init() tea.Cmd {
 	return m.loadInitialData
 }
 
+// pageSize is how many entries of a directory load at a time, and
+// prefetch how close the cursor gets to the last loaded one before the
+// next page is fetched.
+const (
+	pageSize = 1000
+	prefetch = 100
+)
+
 type dataLoadedMsg struct {
 	scanMeta *entry.ScanMeta
 	entries  []db.DisplayEntry
+	total    int64
 	rollup   *entry.Rollup
 	err      error
 }
@@ -76,10 +102,11 @@ func (m *Model) loadInitialData() tea.Msg {
 		return dataLoadedMsg{err: err}
 	}
 
-	entries, err := db.LoadChildren(m.db, meta.RootPath, m.sort.String(), 1000)
+	entries, err := db.LoadChildren(m.db, meta.RootPath, m.sort.String(), pageSize)
 	if err != nil {
 		return dataLoadedMsg{err: err}
 	}
+	total, _ := db.CountChildren(m.db, meta.RootPath)
 
 	rollup, err := db.GetRollup(m.db, meta.RootPath)
 	if err != nil {
@@ -89,42 +116,140 @@ func (m *Model) loadInitialData() tea.Msg {
 	return dataLoadedMsg{
 		scanMeta: meta,
 		entries:  entries,
+		total:    total,
 		rollup:   rollup,
 	}
 }
 
 type entriesLoadedMsg struct {
 	entries []db.DisplayEntry
+	total   int64
 	rollup  *entry.Rollup
 	err     error
 }
 
 func (m *Model) loadEntries(path string) tea.Cmd {
 	return func() tea.Msg {
-		entries, err := db.LoadChildren(m.db, path, m.sort.String(), 1000)
+		entries, err := db.LoadChildren(m.db, path, m.sort.String(), pageSize)
 		if err != nil {
 			return entriesLoadedMsg{err: err}
 		}
 
 		rollup, _ := db.GetRollup(m.db, path)
+		total, _ := db.CountChildren(m.db, path)
 
 		return entriesLoadedMsg{
 			entries: entries,
+			total:   total,
 			rollup:  rollup,
 		}
 	}
 }
 
+type moreLoadedMsg struct {
+	path    string
+	after   db.Cursor
+	entries []db.DisplayEntry
+	err     error
+}
+
+// loadMore fetches the directory's next page once the cursor is within
+// prefetch entries of the last one loaded.
+func (m *Model) loadMore() tea.Cmd {
+	if !m.more || m.loadingMore || m.search != "" || len(m.allEntries) == 0 || m.cursor < len(m.entries)-prefetch {
+		return nil
+	}
+	m.loadingMore = true
+	path, sortBy := m.currentPath, m.sort.String()
+	after := m.allEntries[len(m.allEntries)-1].Cursor(sortBy)
+	return func() tea.Msg {
+		entries, err := db.LoadChildrenAfter(m.db, path, sortBy, after, pageSize)
+		return moreLoadedMsg{path: path, after: after, entries: entries, err: err}
+	}
+}
+
+type searchLoadedMsg struct {
+	query   string
+	entries []db.DisplayEntry
+	err     error
+}
+
+// searchLimit caps the results listed for one search.
+const searchLimit = 1000
+
+func (m *Model) runSearch(query string) tea.Cmd {
+	return func() tea.Msg {
+		entries, err := db.Search(m.db, query, searchLimit)
+		return searchLoadedMsg{query: query, entries: entries, err: err}
+	}
+}
+
+// jumpTo opens the directory holding path and selects path in it.
+func (m *Model) jumpTo(e db.DisplayEntry) tea.Cmd {
+	parent := filepath.Dir(e.Path)
+	if e.Kind.IsContainer() {
+		if p, err := db.ParentPath(m.db, e.Path); err == nil && p != "" {
+			parent = p
+		}
+	}
+	m.currentPath = parent
+	m.selectPath = e.Path
+	m.search = ""
+	return m.loadEntries(parent)
+}
+
 func (m *Model) helpLine() string {
 	if m.filterActive {
 		return "Type to filter | Enter: apply | Esc: clear | q: quit"
 	}
-	return "↑/↓ move | Enter: open | Backspace: close | s/d/n/f: sort | /: filter | q: quit"
+	if m.searchActive {
+		return "Type a name (* and ? are wildcards) | Enter: search | Esc: cancel"
+	}
+	if m.search != "" {
+		return "↑/↓ move | Enter: jump to | Esc: back | /: filter | ctrl+f: search | q: quit"
+	}
+	return "↑/↓ move | Enter: open | Backspace: close | s/d/n/f: sort | /: filter | ctrl+f: search | q: quit"
 }
 
 func (m *Model) setEntries(entries []db.DisplayEntry) {
 	m.allEntries = entries
+	m.more = m.search == "" && len(entries) == pageSize
+	m.loadingMore = false
 	m.applyFilter()
+	if m.selectPath != "" {
+		for i, e := range m.entries {
+			if e.Path == m.selectPath {
+				m.cursor = i
+				break
+			}
+		}
+		m.selectPath = ""
+	}
+}
+
+// displayName is how an entry is labelled: its name, or in search results
+// its path below the scan root.
+func (m *Model) displayName(e db.DisplayEntry) string {
+	if m.search == "" || m.scanMeta == nil {
+		return e.Name
+	}
+	root := m.scanMeta.RootPath
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	return strings.TrimPrefix(e.Path, root)
+}
+
+// appendEntries adds a further page of the directory, keeping the cursor.
+func (m *Model) appendEntries(entries []db.DisplayEntry) {
+	m.allEntries = append(m.allEntries, entries...)
+	m.more = len(entries) == pageSize
+	needle := strings.ToLower(m.filter)
+	for _, e := range entries {
+		if m.filter == "" || strings.Contains(strings.ToLower(e.Name), needle) {
+			m.entries = append(m.entries, e)
+		}
+	}
 }
 
 func (m *Model) applyFilter() {
@@ -52,6 +52,10 @@ var (
 	symlinkStyle = lipgloss.NewStyle().
 			Foreground(colorHighlight)
 
+	archiveStyle = lipgloss.NewStyle().
+			Foreground(colorWarning).
+			Italic(true)
+
 	sizeStyle = lipgloss.NewStyle().
 			Foreground(colorSuccess).
 			Width(10).
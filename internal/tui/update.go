@@ -3,9 +3,9 @@ package tui
 import (
 	"path/filepath"
 
-	"github.com/michaelscutari/dug/internal/entry"
-
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/entry"
 )
 
 // Update implements tea.Model.
@@ -29,6 +29,7 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.filter = ""
 		m.filterActive = false
 		m.setEntries(msg.entries)
+		m.total = msg.total
 		m.rollup = msg.rollup
 		return m, nil
 
@@ -37,17 +38,85 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.err = msg.err
 			return m, nil
 		}
+		m.search = ""
 		m.filter = ""
 		m.filterActive = false
 		m.setEntries(msg.entries)
+		m.total = msg.total
 		m.rollup = msg.rollup
 		return m, nil
+
+	case moreLoadedMsg:
+		// Pages for a listing that has since been replaced are dropped
+		if msg.path != m.currentPath || m.search != "" || len(m.allEntries) == 0 ||
+			msg.after != m.allEntries[len(m.allEntries)-1].Cursor(m.sort.String()) {
+			return m, nil
+		}
+		m.loadingMore = false
+		if msg.err != nil {
+			m.err = msg.err
+			return m, nil
+		}
+		m.appendEntries(msg.entries)
+		return m, m.loadMore()
+
+	case searchLoadedMsg:
+		m.searching = false
+		if msg.err != nil {
+			m.err = msg.err
+			return m, nil
+		}
+		m.search = msg.query
+		m.filter = ""
+		m.filterActive = false
+		m.setEntries(msg.entries)
+		// Bars compare results against the whole snapshot
+		if m.scanMeta != nil {
+			m.rollup = &entry.Rollup{
+				TotalSize:   m.scanMeta.TotalSize,
+				TotalBlocks: m.scanMeta.TotalBlocks,
+				TotalFiles:  m.scanMeta.FileCount,
+				TotalDirs:   m.scanMeta.DirCount,
+			}
+		}
+		return m, nil
 	}
 
 	return m, nil
 }
 
 func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.searchActive {
+		switch msg.String() {
+		case "enter":
+			m.searchActive = false
+			if m.searchInput == "" {
+				return m, nil
+			}
+			m.searching = true
+			return m, m.runSearch(m.searchInput)
+
+		case "esc":
+			m.searchActive = false
+			return m, nil
+
+		case "backspace":
+			if len(m.searchInput) > 0 {
+				runes := []rune(m.searchInput)
+				m.searchInput = string(runes[:len(runes)-1])
+			}
+			return m, nil
+
+		case "ctrl+c":
+			return m, tea.Quit
+		}
+
+		if msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace {
+			m.searchInput += msg.String()
+		}
+		return m, nil
+	}
+
 	if m.filterActive {
 		switch msg.String() {
 		case "enter":
@@ -95,12 +164,26 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		if m.cursor < len(m.entries)-1 {
 			m.cursor++
 		}
+		return m, m.loadMore()
+
+	case "ctrl+f":
+		m.searchActive = true
+		m.searchInput = ""
+		return m, nil
+
+	case "esc":
+		if m.search != "" {
+			return m, m.loadEntries(m.currentPath)
+		}
 		return m, nil
 
 	case "enter", "l", "right":
 		if len(m.entries) > 0 && m.cursor < len(m.entries) {
 			selected := m.entries[m.cursor]
-			if selected.Kind == entry.KindDir {
+			if m.search != "" {
+				return m, m.jumpTo(selected)
+			}
+			if selected.Kind.IsContainer() {
 				m.currentPath = selected.Path
 				m.filter = ""
 				m.filterActive = false
@@ -110,8 +193,14 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case "backspace", "h", "left":
+		if m.search != "" {
+			return m, m.loadEntries(m.currentPath)
+		}
 		if m.scanMeta != nil && m.currentPath != m.scanMeta.RootPath {
-			parent := filepath.Dir(m.currentPath)
+			parent, err := db.ParentPath(m.db, m.currentPath)
+			if err != nil || parent == "" {
+				parent = filepath.Dir(m.currentPath)
+			}
 			m.currentPath = parent
 			m.filter = ""
 			m.filterActive = false
@@ -147,7 +236,7 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		if len(m.entries) > 0 {
 			m.cursor = len(m.entries) - 1
 		}
-		return m, nil
+		return m, m.loadMore()
 
 	case "pgup":
 		m.cursor -= 10
@@ -164,7 +253,7 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		if m.cursor < 0 {
 			m.cursor = 0
 		}
-		return m, nil
+		return m, m.loadMore()
 	}
 
 	return m, nil
@@ -57,6 +57,12 @@ func (m *Model) View() string {
 
 	// Status line
 	status := fmt.Sprintf("Items: %s", FormatCount(int64(len(m.entries))))
+	if m.search != "" {
+		status += fmt.Sprintf(" | Search: %q", m.search)
+		if len(m.entries) >= searchLimit {
+			status += " (first " + FormatCount(searchLimit) + ")"
+		}
+	}
 	if m.filter != "" {
 		status += fmt.Sprintf(" | Filter: %q", m.filter)
 	}
@@ -67,7 +73,12 @@ func (m *Model) View() string {
 	}
 	writeLine(statusStyle.Render(status))
 
-	// Filter input
+	// Search and filter input
+	if m.searchActive {
+		writeLine(filterStyle.Render(fmt.Sprintf("Search: %s_", m.searchInput)))
+	} else if m.searching {
+		writeLine(filterStyle.Render(fmt.Sprintf("Searching for %q...", m.searchInput)))
+	}
 	if m.filterActive {
 		filterLine := fmt.Sprintf("Filter: %s_", m.filter)
 		writeLine(filterStyle.Render(filterLine))
@@ -148,7 +159,15 @@ func (m *Model) View() string {
 	}
 	help := m.helpLine()
 	if len(m.entries) > 0 {
-		help = fmt.Sprintf("%s [%d/%d]", help, m.cursor+1, len(m.entries))
+		// Unfiltered listings count entries not loaded yet; filtered ones
+		// mark that more may match
+		count, suffix := int64(len(m.entries)), ""
+		if m.filter == "" && m.search == "" && m.total > count {
+			count = m.total
+		} else if m.more {
+			suffix = "+"
+		}
+		help = fmt.Sprintf("%s [%d/%d%s]", help, m.cursor+1, count, suffix)
 	}
 	b.WriteString(helpStyle.Render(help))
 
@@ -234,14 +253,12 @@ func (m *Model) formatEntry(e db.DisplayEntry, selected bool, widths columnWidth
 	dirs := FormatCount(e.TotalDirs)
 
 	// Format name with type indicator
-	var rawName string
+	rawName := m.displayName(e)
 	switch e.Kind {
-	case entry.KindDir:
-		rawName = e.Name + "/"
+	case entry.KindDir, entry.KindArchive:
+		rawName += "/"
 	case entry.KindSymlink:
-		rawName = e.Name + "@"
-	default:
-		rawName = e.Name
+		rawName += "@"
 	}
 
 	rawName = truncateRight(rawName, nameWidth)
@@ -249,6 +266,8 @@ func (m *Model) formatEntry(e db.DisplayEntry, selected bool, widths columnWidth
 	switch e.Kind {
 	case entry.KindDir:
 		styledName = dirStyle.Render(rawName)
+	case entry.KindArchive:
+		styledName = archiveStyle.Render(rawName)
 	case entry.KindSymlink:
 		styledName = symlinkStyle.Render(rawName)
 	default:
@@ -0,0 +1,433 @@
+// Package dugdb reads dug snapshot databases.
+//
+// It is the supported way to use snapshots from other Go programs. The
+// snapshot schema changes between dug releases; this API does not, and
+// reads every schema version the dug release it ships with can read.
+// Incompatible API changes would move to a new import path.
+//
+//	snap, err := dugdb.Open("/scans/latest.db")
+//	if err != nil {
+//		return err
+//	}
+//	defer snap.Close()
+//
+//	for e, err := range snap.Children(ctx, "/home", dugdb.ListOptions{Sort: dugdb.BySize}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(e.Path, e.TotalSize)
+//	}
+package dugdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/entry"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	// ErrNotFound is returned for paths that are not directories in the
+	// snapshot.
+	ErrNotFound = errors.New("dugdb: no such directory in snapshot")
+
+	// ErrSchemaTooNew is returned by Open for snapshots written by a newer
+	// dug whose layout this package cannot read.
+	ErrSchemaTooNew = errors.New("dugdb: snapshot schema is too new")
+)
+
+// pageSize is how many rows an iterator fetches at a time.
+const pageSize = 1000
+
+// Kind is the type of an entry.
+type Kind uint8
+
+const (
+	File          Kind = Kind(entry.KindFile)
+	Dir           Kind = Kind(entry.KindDir)
+	Symlink       Kind = Kind(entry.KindSymlink)
+	Other         Kind = Kind(entry.KindOther)
+	Archive       Kind = Kind(entry.KindArchive)       // a tar or zip archive listed as a directory
+	ArchiveMember Kind = Kind(entry.KindArchiveMember) // a file inside an archive
+)
+
+func (k Kind) String() string {
+	return entry.Kind(k).String()
+}
+
+// IsDir reports whether entries of this kind have children.
+func (k Kind) IsDir() bool {
+	return entry.Kind(k).IsContainer()
+}
+
+// Entry is a file or directory in a snapshot.
+type Entry struct {
+	Path    string
+	Name    string
+	Kind    Kind
+	Size    int64 // Apparent size; zero for directories
+	Blocks  int64 // Disk usage in bytes; zero for directories
+	ModTime time.Time
+
+	// Totals over everything below a directory, or the file itself.
+	TotalSize   int64
+	TotalBlocks int64
+	Files       int64
+	Dirs        int64
+}
+
+// Meta describes the scan a snapshot came from.
+type Meta struct {
+	Root          string
+	Start         time.Time
+	End           time.Time
+	Size          int64 // Apparent size
+	Blocks        int64 // Disk usage in bytes
+	Files         int64
+	Dirs          int64
+	Errors        int64
+	SchemaVersion int
+	DugVersion    string // empty for snapshots from before versioning
+	Roots         []Root // set for multi-root and merged snapshots
+}
+
+// Root holds the totals of one root of a multi-root or merged snapshot.
+type Root struct {
+	Path   string
+	Size   int64
+	Blocks int64
+	Files  int64
+	Dirs   int64
+}
+
+// ScanError is a sampled error recorded during the scan.
+type ScanError struct {
+	Path    string
+	Message string
+	// TimedOut marks a directory left incomplete by a readdir or stat
+	// timeout.
+	TimedOut bool
+}
+
+// Sort orders the children of a directory.
+type Sort int
+
+const (
+	BySize  Sort = iota // apparent size, largest first
+	ByDisk              // disk usage, largest first
+	ByName              // name, ascending
+	ByFiles             // file count, largest first
+)
+
+func (s Sort) column() string {
+	switch s {
+	case ByDisk:
+		return "disk"
+	case ByName:
+		return "name"
+	case ByFiles:
+		return "files"
+	default:
+		return "size"
+	}
+}
+
+// Page selects part of a listing. The zero Page is everything.
+type Page struct {
+	Offset int
+	Limit  int // 0 = no limit
+}
+
+// ListOptions controls Children.
+type ListOptions struct {
+	Sort Sort
+	Page
+}
+
+// WalkOptions controls Walk.
+type WalkOptions struct {
+	Sort     Sort // order of siblings
+	MaxDepth int  // levels below the starting directory (0 = unlimited)
+	Files    bool // yield files as well as directories
+}
+
+// Snapshot is an open, read-only snapshot database. It is safe for
+// concurrent use.
+type Snapshot struct {
+	db      *sql.DB
+	path    string
+	cleanup func()
+}
+
+// Open opens the snapshot at path read-only. Archived snapshots ending in
+// .gz are decompressed to a temporary file first, removed again by Close.
+func Open(path string) (*Snapshot, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	local, cleanup, err := db.Uncompress(path)
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.OpenReadOnly(local)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	if err := db.ApplyReadPragmas(database); err != nil {
+		database.Close()
+		cleanup()
+		return nil, err
+	}
+
+	if err := db.CheckSchema(database); err != nil {
+		database.Close()
+		cleanup()
+		var tooNew *db.ErrSchemaTooNew
+		if errors.As(err, &tooNew) {
+			return nil, fmt.Errorf("%w: %v", ErrSchemaTooNew, err)
+		}
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", path, err)
+	}
+	return &Snapshot{db: database, path: path, cleanup: cleanup}, nil
+}
+
+// Close releases the snapshot.
+func (s *Snapshot) Close() error {
+	db.Forget(s.db)
+	err := s.db.Close()
+	s.cleanup()
+	return err
+}
+
+// Path returns the path the snapshot was opened from.
+func (s *Snapshot) Path() string {
+	return s.path
+}
+
+// Meta returns the snapshot's scan metadata.
+func (s *Snapshot) Meta(ctx context.Context) (Meta, error) {
+	if err := ctx.Err(); err != nil {
+		return Meta{}, err
+	}
+	m, err := db.GetScanMetaContext(ctx, s.db)
+	if err != nil {
+		return Meta{}, fmt.Errorf("failed to read scan metadata: %w", err)
+	}
+	meta := Meta{
+		Root:   m.RootPath,
+		Start:  m.StartTime,
+		End:    m.EndTime,
+		Size:   m.TotalSize,
+		Blocks: m.TotalBlocks,
+		Files:  m.FileCount,
+		Dirs:   m.DirCount,
+		Errors: m.ErrorCount,
+	}
+	if meta.SchemaVersion, err = db.VersionContext(ctx, s.db); err != nil {
+		return Meta{}, err
+	}
+	info, err := db.SchemaInfoContext(ctx, s.db)
+	if err != nil {
+		return Meta{}, fmt.Errorf("failed to read schema info: %w", err)
+	}
+	meta.DugVersion = info[db.InfoDugVersion]
+
+	roots, err := db.LoadScanRootsContext(ctx, s.db)
+	if err != nil {
+		return Meta{}, fmt.Errorf("failed to read scan roots: %w", err)
+	}
+	for _, r := range roots {
+		meta.Roots = append(meta.Roots, Root(r))
+	}
+	return meta, nil
+}
+
+// Stat returns the directory at path with its totals.
+func (s *Snapshot) Stat(ctx context.Context, path string) (Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return Entry{}, err
+	}
+	path = filepath.Clean(path)
+	r, err := db.GetRollupContext(ctx, s.db, path)
+	if err != nil {
+		return Entry{}, err
+	}
+	if r == nil {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	return Entry{
+		Path:        path,
+		Name:        filepath.Base(path),
+		Kind:        Dir,
+		TotalSize:   r.TotalSize,
+		TotalBlocks: r.TotalBlocks,
+		Files:       r.TotalFiles,
+		Dirs:        r.TotalDirs,
+	}, nil
+}
+
+// Children iterates over the entries directly inside the directory at
+// path. Iteration stops at the first error.
+func (s *Snapshot) Children(ctx context.Context, path string, opts ListOptions) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		// Pages after the first continue from the last entry rather than
+		// an offset, so huge directories cost the same per page throughout
+		var after db.Cursor
+		sortBy := opts.Sort.column()
+		pages := paginate(ctx, opts.Page, func(offset, limit int) ([]Entry, error) {
+			var children []db.DisplayEntry
+			var err error
+			if after.IsZero() {
+				children, err = db.LoadChildrenPageContext(ctx, s.db, path, sortBy, offset, limit)
+			} else {
+				children, err = db.LoadChildrenAfterContext(ctx, s.db, path, sortBy, after, limit)
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries := make([]Entry, len(children))
+			for i, c := range children {
+				entries[i] = fromDisplay(c)
+			}
+			if len(children) > 0 {
+				after = children[len(children)-1].Cursor(sortBy)
+			}
+			return entries, nil
+		})
+		pages(yield)
+	}
+}
+
+// Walk iterates depth-first over the directory at path and every directory
+// below it, parents before their children, and over files too when
+// opts.Files is set. Breaking out of the loop stops the walk.
+func (s *Snapshot) Walk(ctx context.Context, path string, opts WalkOptions) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		root, err := s.Stat(ctx, path)
+		if err != nil {
+			yield(Entry{}, err)
+			return
+		}
+		if yield(root, nil) {
+			s.walk(ctx, root.Path, 1, opts, yield)
+		}
+	}
+}
+
+func (s *Snapshot) walk(ctx context.Context, dir string, depth int, opts WalkOptions, yield func(Entry, error) bool) bool {
+	if opts.MaxDepth > 0 && depth > opts.MaxDepth {
+		return true
+	}
+	for e, err := range s.Children(ctx, dir, ListOptions{Sort: opts.Sort}) {
+		if err != nil {
+			yield(Entry{}, err)
+			return false
+		}
+		if !e.Kind.IsDir() && !opts.Files {
+			continue
+		}
+		if !yield(e, nil) {
+			return false
+		}
+		if e.Kind.IsDir() && !s.walk(ctx, e.Path, depth+1, opts, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// Errors iterates over the errors sampled during the scan in the order
+// they were recorded.
+func (s *Snapshot) Errors(ctx context.Context, page Page) iter.Seq2[ScanError, error] {
+	return paginate(ctx, page, func(offset, limit int) ([]ScanError, error) {
+		sampled, err := db.LoadErrorsContext(ctx, s.db, offset, limit)
+		if err != nil {
+			return nil, err
+		}
+		errs := make([]ScanError, len(sampled))
+		for i, e := range sampled {
+			errs[i] = ScanError{Path: e.Path, Message: e.Message, TimedOut: e.TimedOut}
+		}
+		return errs, nil
+	})
+}
+
+// Search returns up to limit files and directories anywhere in the
+// snapshot whose name contains pattern, ignoring ASCII case, largest
+// first. With a '*' or '?' wildcard the pattern must match the whole name.
+func (s *Snapshot) Search(ctx context.Context, pattern string, limit int) ([]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results, err := db.Search(s.db, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(results))
+	for i, r := range results {
+		entries[i] = fromDisplay(r)
+	}
+	return entries, nil
+}
+
+// paginate turns a page fetcher into an iterator over page, fetching
+// pageSize rows at a time.
+func paginate[T any](ctx context.Context, page Page, fetch func(offset, limit int) ([]T, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		offset, remaining := page.Offset, page.Limit
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			n := pageSize
+			if page.Limit > 0 {
+				n = min(n, remaining)
+			}
+			rows, err := fetch(offset, n)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, row := range rows {
+				if !yield(row, nil) {
+					return
+				}
+			}
+			offset += len(rows)
+			remaining -= len(rows)
+			if len(rows) < n || (page.Limit > 0 && remaining == 0) {
+				return
+			}
+		}
+	}
+}
+
+func fromDisplay(d db.DisplayEntry) Entry {
+	return Entry{
+		Path:        d.Path,
+		Name:        d.Name,
+		Kind:        Kind(d.Kind),
+		Size:        d.Size,
+		Blocks:      d.Blocks,
+		ModTime:     d.ModTime,
+		TotalSize:   d.TotalSize,
+		TotalBlocks: d.TotalBlocks,
+		Files:       d.TotalFiles,
+		Dirs:        d.TotalDirs,
+	}
+}
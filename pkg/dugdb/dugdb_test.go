@@ -0,0 +1,112 @@
+package dugdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/michaelscutari/dug/internal/db"
+	"github.com/michaelscutari/dug/internal/scan"
+	"github.com/michaelscutari/dug/internal/source"
+)
+
+func writeSnapshot(t *testing.T) string {
+	t.Helper()
+	mem := source.NewMemory()
+	for i := range 5 {
+		mem.AddFile(fmt.Sprintf("/data/d%d/f.txt", i), source.Stat{Size: int64(100 * (i + 1)), Blocks: 4096})
+		mem.AddFile(fmt.Sprintf("/data/d%d/sub/g.txt", i), source.Stat{Size: 1, Blocks: 4096})
+	}
+	mem.AddFile("/data/top.txt", source.Stat{Size: 7, Blocks: 4096})
+
+	path := filepath.Join(t.TempDir(), "snap.db")
+	database, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+	if err := db.InitSchema(database); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	opts := scan.DefaultOptions().WithWorkers(2).WithSource(mem)
+	if err := scan.NewScanner(opts).Run(context.Background(), "/data", database); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	return path
+}
+
+func TestSnapshotIterators(t *testing.T) {
+	ctx := context.Background()
+	snap, err := Open(writeSnapshot(t))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer snap.Close()
+
+	meta, err := snap.Meta(ctx)
+	if err != nil || meta.Root != "/data" || meta.Files != 11 || meta.SchemaVersion != db.SchemaVersion {
+		t.Fatalf("unexpected meta %+v (%v)", meta, err)
+	}
+
+	// Pages of two, largest first, reassemble the full listing
+	var all, paged []string
+	for e, err := range snap.Children(ctx, "/data", ListOptions{Sort: BySize}) {
+		if err != nil {
+			t.Fatalf("children: %v", err)
+		}
+		all = append(all, e.Name)
+	}
+	for offset := 0; offset < len(all); offset += 2 {
+		for e, err := range snap.Children(ctx, "/data", ListOptions{Sort: BySize, Page: Page{Offset: offset, Limit: 2}}) {
+			if err != nil {
+				t.Fatalf("children page: %v", err)
+			}
+			paged = append(paged, e.Name)
+		}
+	}
+	if len(all) != 6 || all[0] != "d4" || fmt.Sprint(all) != fmt.Sprint(paged) {
+		t.Fatalf("children %v, paged %v", all, paged)
+	}
+
+	var dirs, files int
+	for e, err := range snap.Walk(ctx, "/data", WalkOptions{Files: true}) {
+		if err != nil {
+			t.Fatalf("walk: %v", err)
+		}
+		if e.Kind.IsDir() {
+			dirs++
+		} else {
+			files++
+		}
+	}
+	if dirs != 11 || files != 11 {
+		t.Fatalf("walk saw %d dirs and %d files, want 11 and 11", dirs, files)
+	}
+
+	var shallow []string
+	for e, err := range snap.Walk(ctx, "/data", WalkOptions{Sort: ByName, MaxDepth: 1}) {
+		if err != nil {
+			t.Fatalf("walk: %v", err)
+		}
+		shallow = append(shallow, e.Path)
+		if len(shallow) == 3 {
+			break
+		}
+	}
+	if fmt.Sprint(shallow) != "[/data /data/d0 /data/d1]" {
+		t.Fatalf("shallow walk = %v", shallow)
+	}
+
+	for _, err := range snap.Children(ctx, "/nope", ListOptions{}) {
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("children of a missing dir: %v, want ErrNotFound", err)
+		}
+	}
+	if _, err := snap.Stat(ctx, "/data/d0/f.txt"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("stat of a file: %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package dugscan scans directory trees into dug snapshot databases.
+//
+// It is the supported way to run scans from other Go programs, and writes
+// the same snapshots as dug scan: each run adds a dug-<time>.db to the
+// output directory, points latest.db at it and prunes old snapshots. Read
+// the result with package dugdb.
+//
+//	path, err := dugscan.Run(ctx, dugscan.Options{
+//		Roots:     []string{"/home"},
+//		OutputDir: "/scans",
+//		Progress: func(p dugscan.Progress) {
+//			log.Printf("%s: %d files", p.Stage, p.Files)
+//		},
+//	})
+//
+// Options is extended only with fields whose zero value keeps the earlier
+// behavior.
+package dugscan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/michaelscutari/dug/internal/scan"
+	"github.com/michaelscutari/dug/internal/snapshot"
+)
+
+var (
+	// ErrLocked is returned when another scan holds the output directory.
+	ErrLocked = snapshot.ErrLocked
+
+	// ErrRootMissing is returned when a root does not exist.
+	ErrRootMissing = scan.ErrRootMissing
+
+	// ErrMaxErrors is returned when a scan stops after Options.MaxErrors
+	// errors. No snapshot is written.
+	ErrMaxErrors = scan.ErrMaxErrors
+)
+
+// Options configures a scan. The zero value of each field is its default.
+type Options struct {
+	// Roots are the directories to scan. Several roots end up in one
+	// snapshot under a directory at their common ancestor, and must be
+	// absolute and must not overlap.
+	Roots []string
+
+	// OutputDir holds the snapshots. It is created if missing.
+	OutputDir string
+
+	// Retention is how many snapshots to keep in OutputDir, oldest removed
+	// first. Zero keeps them all.
+	Retention int
+
+	Workers         int           // concurrent directory readers (0 = 8)
+	CrossDevices    bool          // descend into other filesystems
+	Exclude         []string      // regular expressions for paths to skip, besides NFS .snapshot directories
+	MaxErrors       int           // stop with ErrMaxErrors after this many errors (0 = unlimited)
+	ReadDirTimeout  time.Duration // give up on a directory whose read takes longer (0 = no limit)
+	StatTimeout     time.Duration // give up on a directory when one lstat takes longer (0 = no limit)
+	DescendArchives bool          // list the members of tar and zip files as directories
+	SearchIndex     bool          // build the name index used by search
+	SkipIndexes     bool          // write no indexes, for snapshots that are only merged
+
+	// Progress is called with the scan's progress about ten times a second
+	// and whenever the stage changes. Calls never overlap.
+	Progress func(Progress)
+
+	// Warn is called with problems that do not fail the scan, such as a
+	// snapshot that could not be pruned. Nil discards them.
+	Warn func(error)
+}
+
+// Stage is a phase of building a snapshot.
+type Stage string
+
+const (
+	StageScan     Stage = "scan"     // walking the roots
+	StageIndexes  Stage = "indexes"  // building indexes
+	StageSearch   Stage = "search"   // building the name index
+	StageFinalize Stage = "finalize" // writing totals and compacting
+)
+
+// Progress is a snapshot of a running scan's counters.
+type Progress struct {
+	Stage      Stage
+	Files      int64
+	Dirs       int64
+	Errors     int64
+	TotalBytes int64
+}
+
+// Run scans opts.Roots into a new snapshot in opts.OutputDir and returns
+// its path. Canceling ctx stops the scan and returns ctx's error without
+// writing a snapshot.
+func Run(ctx context.Context, opts Options) (string, error) {
+	if len(opts.Roots) == 0 {
+		return "", errors.New("dugscan: no roots to scan")
+	}
+	if opts.OutputDir == "" {
+		return "", errors.New("dugscan: no output directory")
+	}
+
+	scanOpts := scan.DefaultOptions().
+		WithXdev(!opts.CrossDevices).
+		WithMaxErrors(opts.MaxErrors).
+		WithReadDirTimeout(opts.ReadDirTimeout).
+		WithStatTimeout(opts.StatTimeout).
+		WithDescendArchives(opts.DescendArchives).
+		WithWarn(discard)
+	if opts.Workers > 0 {
+		scanOpts.WithWorkers(opts.Workers)
+	}
+	for _, pattern := range opts.Exclude {
+		if err := scanOpts.AddExcludePattern(pattern); err != nil {
+			return "", fmt.Errorf("dugscan: invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+
+	mgr := snapshot.NewManager(opts.OutputDir, opts.Retention)
+	mgr.SetVersion(dugVersion())
+	mgr.SetSearchIndex(opts.SearchIndex)
+	if opts.SkipIndexes {
+		mgr.SetIndexMode("skip")
+	}
+	if opts.Warn != nil {
+		scanOpts.WithWarn(opts.Warn)
+		mgr.SetWarnFunc(opts.Warn)
+	} else {
+		mgr.SetWarnFunc(discard)
+	}
+
+	if opts.Progress != nil {
+		var mu sync.Mutex
+		var p Progress
+		mgr.SetStageFunc(func(stage string) {
+			mu.Lock()
+			defer mu.Unlock()
+			p.Stage = Stage(stage)
+			opts.Progress(p)
+		})
+		mgr.SetProgressFunc(func(files, dirs, errors, totalBytes int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			p.Files, p.Dirs, p.Errors, p.TotalBytes = files, dirs, errors, totalBytes
+			opts.Progress(p)
+		})
+	}
+
+	return mgr.RunScanRoots(ctx, opts.Roots, scanOpts)
+}
+
+func discard(error) {}
+
+// dugVersion returns the version of the dug module this program was built
+// with, recorded in each snapshot's schema_info.
+func dugVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	const module = "github.com/michaelscutari/dug"
+	if info.Main.Path == module {
+		if info.Main.Version == "(devel)" {
+			return ""
+		}
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == module {
+			return dep.Version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package dugscan
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/michaelscutari/dug/pkg/dugdb"
+)
+
+func TestRunWritesSnapshotAndReportsStages(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a/one.txt", "a/b/two.txt", "three.txt"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := t.TempDir()
+
+	var mu sync.Mutex
+	var stages []Stage
+	opts := Options{
+		Roots:     []string{root},
+		OutputDir: out,
+		Workers:   2,
+		Progress: func(p Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(stages) == 0 || stages[len(stages)-1] != p.Stage {
+				stages = append(stages, p.Stage)
+			}
+		},
+	}
+	path, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if stages[0] != StageScan || stages[len(stages)-1] != StageFinalize {
+		t.Fatalf("stages = %v", stages)
+	}
+
+	snap, err := dugdb.Open(path)
+	if err != nil {
+		t.Fatalf("open snapshot: %v", err)
+	}
+	defer snap.Close()
+	meta, err := snap.Meta(context.Background())
+	if err != nil || meta.Root != root || meta.Files != 3 {
+		t.Fatalf("unexpected meta %+v (%v)", meta, err)
+	}
+
+	// A missing root and a held lock fail with their typed errors
+	missing := opts
+	missing.Roots = []string{filepath.Join(root, "nope")}
+	if _, err := Run(context.Background(), missing); !errors.Is(err, ErrRootMissing) {
+		t.Fatalf("missing root: %v, want ErrRootMissing", err)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(out, ".dug.lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Run(context.Background(), opts); !errors.Is(err, ErrLocked) {
+		t.Fatalf("locked: %v, want ErrLocked", err)
+	}
+}